@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pinnings maps roles to CPUs they are meant to be pinned to. It implements
+// flag.Value, so that pinning can be given on command line as -pin role=cpus,
+// with cpus in the same format as Cpus_allowed_list, once for each role.
+type pinnings map[string][]int
+
+func (p pinnings) String() string {
+	var l []string
+	for role, cpus := range p {
+		l = append(l, fmt.Sprintf("%s=%v", role, cpus))
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (p pinnings) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected role=cpus, got %q", v)
+	}
+	cpus, err := parseCPUList(parts[1])
+	if err != nil {
+		return err
+	}
+	p[parts[0]] = cpus
+	return nil
+}
+
+// AffinityViolation is a thread running, or allowed to run, somewhere other
+// than where its process is meant to be pinned.
+type AffinityViolation struct {
+	TID    int    `json:"tid"`
+	Comm   string `json:"comm"`
+	Reason string `json:"reason"`
+}
+
+// AffinityReport describes where threads of a process run, compared to where
+// they are meant to run. Migrations is the number of threads which moved to
+// another CPU since previous sample.
+type AffinityReport struct {
+	IntendedCPUs []int               `json:"intended_cpus,omitempty"`
+	CPUsUsed     []int               `json:"cpus_used"`
+	NumThreads   int                 `json:"num_threads"`
+	Migrations   int                 `json:"migrations"`
+	Violations   []AffinityViolation `json:"violations"`
+}
+
+// AffinityTracker remembers which CPU each thread of a process last ran on,
+// so that we can count migrations between samples. Every monitor has its own.
+type AffinityTracker struct {
+	lastCPU map[int]int
+}
+
+// NewAffinityTracker returns a tracker without any history.
+func NewAffinityTracker() *AffinityTracker {
+	return &AffinityTracker{lastCPU: make(map[int]int)}
+}
+
+// Reset forgets all history, which is what we want once we are looking at a
+// different process.
+func (t *AffinityTracker) Reset() {
+	t.lastCPU = make(map[int]int)
+}
+
+func cpuSet(cpus []int) map[int]struct{} {
+	var m = make(map[int]struct{}, len(cpus))
+	for _, cpu := range cpus {
+		m[cpu] = struct{}{}
+	}
+	return m
+}
+
+// Sample compares threads against intended CPUs, which may be empty if the
+// process is not meant to be pinned at all, in which case there are no
+// violations, but we still count migrations.
+func (t *AffinityTracker) Sample(threads []ThreadInfo, intended []int) *AffinityReport {
+	var rep = &AffinityReport{
+		IntendedCPUs: intended,
+		NumThreads:   len(threads),
+		CPUsUsed:     []int{},
+		Violations:   []AffinityViolation{},
+	}
+	var intendedSet = cpuSet(intended)
+	var used = make(map[int]struct{})
+	var lastCPU = make(map[int]int, len(threads))
+	for _, th := range threads {
+		cpu := th.Stat.Processor
+		used[cpu] = struct{}{}
+		lastCPU[th.TID] = cpu
+		if prev, ok := t.lastCPU[th.TID]; ok && prev != cpu {
+			rep.Migrations++
+		}
+		if len(intended) == 0 {
+			continue
+		}
+		if _, ok := intendedSet[cpu]; !ok {
+			rep.Violations = append(rep.Violations, AffinityViolation{
+				TID:    th.TID,
+				Comm:   th.Stat.Comm,
+				Reason: fmt.Sprintf("last ran on CPU %d, intended %v", cpu, intended),
+			})
+		}
+		for _, allowed := range th.AllowedCPUs {
+			if _, ok := intendedSet[allowed]; !ok {
+				rep.Violations = append(rep.Violations, AffinityViolation{
+					TID:    th.TID,
+					Comm:   th.Stat.Comm,
+					Reason: fmt.Sprintf("allowed on CPUs %v, intended %v", th.AllowedCPUs, intended),
+				})
+				break
+			}
+		}
+	}
+	// Threads which went away are simply forgotten.
+	t.lastCPU = lastCPU
+	for cpu := range used {
+		rep.CPUsUsed = append(rep.CPUsUsed, cpu)
+	}
+	sort.Ints(rep.CPUsUsed)
+	return rep
+}
+
+// SharedCore is a CPU used by more than one monitored instance, where at least
+// one of them is pinned and not every one of them is pinned to this CPU.
+// Instances sharing a core which they are all pinned to are left alone,
+// because that is what somebody asked for.
+type SharedCore struct {
+	CPU       int      `json:"cpu"`
+	Instances []string `json:"instances"`
+}
+
+// SharedCores finds CPUs which are unexpectedly shared between instances.
+func (s *Summaries) SharedCores() []SharedCore {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	var users = make(map[int][]*IntervalReport)
+	for _, rep := range s.m {
+		if rep.Affinity == nil {
+			continue
+		}
+		for _, cpu := range rep.Affinity.CPUsUsed {
+			users[cpu] = append(users[cpu], rep)
+		}
+	}
+	var shared = []SharedCore{}
+	for cpu, reps := range users {
+		if len(reps) < 2 {
+			continue
+		}
+		var pinned, pinnedHere int
+		var instances []string
+		for _, rep := range reps {
+			instances = append(instances, rep.Key())
+			if len(rep.Affinity.IntendedCPUs) == 0 {
+				continue
+			}
+			pinned++
+			if _, ok := cpuSet(rep.Affinity.IntendedCPUs)[cpu]; ok {
+				pinnedHere++
+			}
+		}
+		if pinned == 0 || pinnedHere == len(reps) {
+			continue
+		}
+		sort.Strings(instances)
+		shared = append(shared, SharedCore{CPU: cpu, Instances: instances})
+	}
+	sort.Slice(shared, func(i, j int) bool { return shared[i].CPU < shared[j].CPU })
+	return shared
+}
+
+// SharedCoresToJSON returns serialized list of unexpectedly shared CPUs.
+func (s *Summaries) SharedCoresToJSON() ([]byte, error) {
+	return json.Marshal(s.SharedCores())
+}
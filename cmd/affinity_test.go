@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_pinnings_Set(t *testing.T) {
+	p := make(pinnings)
+	if err := p.Set("worker-1=2-3,6"); err != nil {
+		t.Fatalf("pinnings.Set() error = %v", err)
+	}
+	if !reflect.DeepEqual(p["worker-1"], []int{2, 3, 6}) {
+		t.Errorf("pinnings.Set() = %v, want worker-1 pinned to [2 3 6]", p)
+	}
+	if err := p.Set("worker-1"); err == nil {
+		t.Errorf("pinnings.Set() expected error without CPUs")
+	}
+	if err := p.Set("worker-1=two"); err == nil {
+		t.Errorf("pinnings.Set() expected error for malformed CPU list")
+	}
+}
+
+func thread(tid, cpu int, allowed ...int) ThreadInfo {
+	return ThreadInfo{TID: tid, Stat: ProcStat{PID: tid, Comm: "zeek", Processor: cpu}, AllowedCPUs: allowed}
+}
+
+func TestAffinityTracker_Sample(t *testing.T) {
+	tr := NewAffinityTracker()
+
+	rep := tr.Sample([]ThreadInfo{thread(100, 2, 2), thread(101, 2, 2)}, []int{2})
+	if rep.Migrations != 0 || len(rep.Violations) != 0 {
+		t.Errorf("AffinityTracker.Sample() = %+v, want no migrations and no violations", rep)
+	}
+
+	// Thread 101 lost its pinning and wandered off to CPU 5, and thread 102
+	// is new, so it cannot have migrated.
+	rep = tr.Sample([]ThreadInfo{thread(100, 2, 2), thread(101, 5, 0, 1, 2, 3, 4, 5), thread(102, 2, 2)}, []int{2})
+	if rep.Migrations != 1 {
+		t.Errorf("AffinityTracker.Sample().Migrations = %d, want 1", rep.Migrations)
+	}
+	if len(rep.Violations) != 2 || rep.Violations[0].TID != 101 || rep.Violations[1].TID != 101 {
+		t.Errorf("AffinityTracker.Sample().Violations = %+v, want two for thread 101", rep.Violations)
+	}
+	if !reflect.DeepEqual(rep.CPUsUsed, []int{2, 5}) {
+		t.Errorf("AffinityTracker.Sample().CPUsUsed = %v, want [2 5]", rep.CPUsUsed)
+	}
+
+	// Without intended pinning, there is nothing to violate.
+	rep = tr.Sample([]ThreadInfo{thread(100, 3, 0, 1, 2, 3)}, nil)
+	if rep.Migrations != 1 || len(rep.Violations) != 0 {
+		t.Errorf("AffinityTracker.Sample() = %+v, want one migration and no violations", rep)
+	}
+}
+
+func TestSummaries_SharedCores(t *testing.T) {
+	s := &Summaries{m: make(map[string]*IntervalReport)}
+	for _, r := range []*IntervalReport{
+		{Role: "worker-1-1", PID: 100, Affinity: &AffinityReport{IntendedCPUs: []int{2}, CPUsUsed: []int{2}}},
+		{Role: "worker-1-2", PID: 101, Affinity: &AffinityReport{IntendedCPUs: []int{3}, CPUsUsed: []int{3}}},
+		{Role: "manager", PID: 102, Affinity: &AffinityReport{CPUsUsed: []int{0, 3}}},
+		{Role: "proxy-1", PID: 103, Affinity: &AffinityReport{CPUsUsed: []int{0}}},
+		{Role: "worker-2-1", PID: 104, Affinity: &AffinityReport{IntendedCPUs: []int{6}, CPUsUsed: []int{6}}},
+		{Role: "worker-2-2", PID: 105, Affinity: &AffinityReport{IntendedCPUs: []int{6}, CPUsUsed: []int{6}}},
+	} {
+		s.Insert(r)
+	}
+	want := []SharedCore{{CPU: 3, Instances: []string{"manager/102", "worker-1-2/101"}}}
+	if got := s.SharedCores(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Summaries.SharedCores() = %+v, want %+v", got, want)
+	}
+}
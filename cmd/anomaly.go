@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minAnomalySamples is how many samples of history a detector needs before it
+// scores anything. With fewer, every value looks unusual.
+const minAnomalySamples = 30
+
+// madScale makes median absolute deviation comparable with standard deviation
+// of normally distributed values, which gives us the modified z-score.
+const madScale = 0.6745
+
+// Names of detectors, used to tell which of them flagged a value.
+const (
+	detectorZScore   = "zscore"
+	detectorMAD      = "mad"
+	detectorSeasonal = "seasonal"
+)
+
+// Names of role series we look for anomalies in.
+const (
+	anomalyWindowRate = "window_rate"
+	anomalyRSSBytes   = "rss_bytes"
+)
+
+// AnomalyScore is how far the most recent value of a series departs from its
+// own history, measured by three detectors. Each score is in units of spread,
+// positive above the baseline and negative below it, and NaN until there is
+// enough history.
+// ZScore - distance from mean of the rolling window in standard deviations.
+// MADScore - modified z-score, distance from median of the rolling window in
+// median absolute deviations, which a few earlier outliers do not skew.
+// SeasonalScore - distance from mean of values seen at the same hour of day on
+// previous days, so that a quiet night is compared with other nights.
+// Value is anomalous once any of the scores goes beyond threshold, Detectors
+// tells which of them it was. Scores are signed, so NaN cannot be made -1 for
+// JSON the way other figures are. Scores not there yet are left out of JSON,
+// and are NaN in Prometheus format.
+type AnomalyScore struct {
+	Value         float64   `json:"value"`
+	ZScore        float64   `json:"zscore"`
+	MADScore      float64   `json:"mad_score"`
+	SeasonalScore float64   `json:"seasonal_score"`
+	Threshold     float64   `json:"threshold"`
+	Anomalous     bool      `json:"anomalous"`
+	Detectors     []string  `json:"detectors,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// optionalScore returns nil for a NaN score, which JSON leaves out.
+func optionalScore(v float64) *float64 {
+	if math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+// MarshalJSON leaves out scores for which there is not enough history yet.
+func (sc AnomalyScore) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value         float64   `json:"value"`
+		ZScore        *float64  `json:"zscore,omitempty"`
+		MADScore      *float64  `json:"mad_score,omitempty"`
+		SeasonalScore *float64  `json:"seasonal_score,omitempty"`
+		Threshold     float64   `json:"threshold"`
+		Anomalous     bool      `json:"anomalous"`
+		Detectors     []string  `json:"detectors,omitempty"`
+		Timestamp     time.Time `json:"timestamp"`
+	}{
+		Value:         sc.Value,
+		ZScore:        optionalScore(sc.ZScore),
+		MADScore:      optionalScore(sc.MADScore),
+		SeasonalScore: optionalScore(sc.SeasonalScore),
+		Threshold:     sc.Threshold,
+		Anomalous:     sc.Anomalous,
+		Detectors:     sc.Detectors,
+		Timestamp:     sc.Timestamp,
+	})
+}
+
+// String returns score in Prometheus format with given labels.
+func (sc AnomalyScore) String(labels string) string {
+	var b strings.Builder
+	var anomalous int
+	if sc.Anomalous {
+		anomalous = 1
+	}
+	for _, d := range []struct {
+		name  string
+		score float64
+	}{
+		{detectorZScore, sc.ZScore},
+		{detectorMAD, sc.MADScore},
+		{detectorSeasonal, sc.SeasonalScore},
+	} {
+		fmt.Fprintf(&b, "bro_role_anomaly_score{%s,detector=\"%s\"} %g\n", labels, d.name, d.score)
+	}
+	fmt.Fprintf(&b, "bro_role_anomaly{%s} %d\n", labels, anomalous)
+	return b.String()
+}
+
+// hourStats is a running mean and variance of values seen over one hour of a
+// day, see seasonalBaseline.
+type hourStats struct {
+	day  string
+	n    int
+	mean float64
+	m2   float64
+}
+
+// add updates running figures with Welford's algorithm.
+func (hs *hourStats) add(v float64) {
+	hs.n++
+	d := v - hs.mean
+	hs.mean += d / float64(hs.n)
+	hs.m2 += d * (v - hs.mean)
+}
+
+// seasonalBaseline keeps statistics of every hour of day for a number of most
+// recent days. Hours are in local time, which is what traffic follows.
+type seasonalBaseline struct {
+	days  int
+	hours [24][]hourStats
+}
+
+func (sb *seasonalBaseline) Insert(v float64, now time.Time) {
+	now = now.Local()
+	day := now.Format("2006-01-02")
+	l := sb.hours[now.Hour()]
+	if len(l) == 0 || l[len(l)-1].day != day {
+		l = append(l, hourStats{day: day})
+		if len(l) > sb.days {
+			l = l[len(l)-sb.days:]
+		}
+	}
+	l[len(l)-1].add(v)
+	sb.hours[now.Hour()] = l
+}
+
+// Score returns distance of v from mean of the same hour on previous days in
+// standard deviations, pooling statistics of all of those days.
+func (sb *seasonalBaseline) Score(v float64, now time.Time) float64 {
+	now = now.Local()
+	day := now.Format("2006-01-02")
+	var n int
+	var total float64
+	var prev []hourStats
+	for _, hs := range sb.hours[now.Hour()] {
+		if hs.day != day {
+			prev = append(prev, hs)
+			n += hs.n
+			total += hs.mean * float64(hs.n)
+		}
+	}
+	if n < minAnomalySamples {
+		return math.NaN()
+	}
+	mean := total / float64(n)
+	var m2 float64
+	for _, hs := range prev {
+		m2 += hs.m2 + float64(hs.n)*(hs.mean-mean)*(hs.mean-mean)
+	}
+	return spreadScore(v, mean, math.Sqrt(m2/float64(n-1)))
+}
+
+// spreadScore is distance of v from center in units of spread, NaN when
+// there is no spread to measure it in.
+func spreadScore(v, center, spread float64) float64 {
+	if spread == 0 || math.IsNaN(spread) {
+		return math.NaN()
+	}
+	return (v - center) / spread
+}
+
+// AnomalyDetector scores values of a single series against a rolling window of
+// its history and against its seasonal baseline.
+type AnomalyDetector struct {
+	history   *SampleSeries
+	window    time.Duration
+	seasonal  *seasonalBaseline
+	threshold float64
+}
+
+// NewAnomalyDetector returns a detector with a rolling window of given length,
+// a seasonal baseline over a number of days, flagging values scoring beyond
+// threshold.
+func NewAnomalyDetector(window time.Duration, days int, threshold float64) *AnomalyDetector {
+	return &AnomalyDetector{
+		history:   NewSampleSeries(window),
+		window:    window,
+		seasonal:  &seasonalBaseline{days: days + 1},
+		threshold: threshold,
+	}
+}
+
+// Observe scores v against history so far, then adds it to history.
+func (d *AnomalyDetector) Observe(v float64, now time.Time) AnomalyScore {
+	var values []float64
+	for _, s := range d.history.samples {
+		if now.Sub(s.at) < d.window && !math.IsNaN(s.v) {
+			values = append(values, s.v)
+		}
+	}
+	sc := AnomalyScore{
+		Value:         v,
+		ZScore:        math.NaN(),
+		MADScore:      math.NaN(),
+		SeasonalScore: d.seasonal.Score(v, now),
+		Threshold:     d.threshold,
+		Timestamp:     now,
+	}
+	if len(values) >= minAnomalySamples {
+		sc.ZScore = spreadScore(v, avg(values), stddev(values))
+		sc.MADScore = spreadScore(v, median(values), medianAbsoluteDeviation(values)/madScale)
+	}
+	for _, det := range []struct {
+		name  string
+		score float64
+	}{
+		{detectorZScore, sc.ZScore},
+		{detectorMAD, sc.MADScore},
+		{detectorSeasonal, sc.SeasonalScore},
+	} {
+		if math.Abs(det.score) > d.threshold {
+			sc.Anomalous = true
+			sc.Detectors = append(sc.Detectors, det.name)
+		}
+	}
+	d.history.Add(now, v)
+	d.seasonal.Insert(v, now)
+	return sc
+}
+
+// AnomalyTracker keeps anomaly detectors for series of every role, along with
+// the latest score of each. Roles are observed on every reporting interval,
+// see startIntervalReport.
+type AnomalyTracker struct {
+	window    time.Duration
+	days      int
+	threshold float64
+	detectors map[string]map[string]*AnomalyDetector
+	scores    map[string]map[string]AnomalyScore
+	mtx       sync.Mutex
+}
+
+// NewAnomalyTracker returns a tracker with detectors using a rolling window of
+// given length and a seasonal baseline over a number of previous days.
+func NewAnomalyTracker(window time.Duration, days int, threshold float64) *AnomalyTracker {
+	return &AnomalyTracker{
+		window:    window,
+		days:      days,
+		threshold: threshold,
+		detectors: make(map[string]map[string]*AnomalyDetector),
+		scores:    make(map[string]map[string]AnomalyScore),
+	}
+}
+
+// Observe scores value of a series of role observed at time now. NaNs are
+// ignored, they tell us nothing about whether a role behaves.
+func (t *AnomalyTracker) Observe(role, series string, v float64, now time.Time) {
+	if math.IsNaN(v) {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bySeries, ok := t.detectors[role]
+	if !ok {
+		bySeries = make(map[string]*AnomalyDetector)
+		t.detectors[role] = bySeries
+		t.scores[role] = make(map[string]AnomalyScore)
+	}
+	d, ok := bySeries[series]
+	if !ok {
+		d = NewAnomalyDetector(t.window, t.days, t.threshold)
+		bySeries[series] = d
+	}
+	t.scores[role][series] = d.Observe(v, now)
+}
+
+// Scores returns latest scores of every series of a role, nil if nothing was
+// observed for the role.
+func (t *AnomalyTracker) Scores(role string) map[string]*AnomalyScore {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bySeries, ok := t.scores[role]
+	if !ok {
+		return nil
+	}
+	var m = make(map[string]*AnomalyScore, len(bySeries))
+	for series, sc := range bySeries {
+		sc := sc
+		m[series] = &sc
+	}
+	return m
+}
+
+// anomaliesString returns scores of a role in Prometheus format.
+func anomaliesString(role string, m map[string]*AnomalyScore) string {
+	var b strings.Builder
+	var names []string
+	for series := range m {
+		names = append(names, series)
+	}
+	sort.Strings(names)
+	for _, series := range names {
+		b.WriteString(m[series].String(fmt.Sprintf("role=\"%s\",series=\"%s\"", role, series)))
+	}
+	return b.String()
+}
+
+// ObserveAnomalies scores current CPU rate and RSS of every role with the
+// anomaly tracker. Rates of a role are sums over its instances, the same as
+// in RoleSummary.
+func (s *Summaries) ObserveAnomalies(now time.Time) {
+	if anomalies == nil {
+		return
+	}
+	var rates = make(map[string][]float64)
+	var rss = make(map[string]int)
+	s.mtx.RLock()
+	for _, rep := range s.m {
+		rates[rep.Role] = append(rates[rep.Role], rep.WindowRate)
+		rss[rep.Role] += rep.RSSBytes
+	}
+	s.mtx.RUnlock()
+	for role, l := range rates {
+		// A role none of whose instances has a rate yet would sum up to
+		// zero, which is not what it is using.
+		if countNaNs(l) < len(l) {
+			anomalies.Observe(role, anomalyWindowRate, sum(l), now)
+		}
+		anomalies.Observe(role, anomalyRSSBytes, float64(rss[role]), now)
+	}
+}
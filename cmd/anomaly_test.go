@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noisy returns a value around 0.5 which repeats every five samples.
+func noisy(i int) float64 {
+	return 0.5 + []float64{-0.02, -0.01, 0, 0.01, 0.02}[i%5]
+}
+
+func TestAnomalyDetector_Observe(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.Local)
+	d := NewAnomalyDetector(time.Hour, 7, 3.5)
+	var at time.Time
+	for i := 0; i < 60; i++ {
+		at = start.Add(time.Duration(i) * time.Minute / 2)
+		sc := d.Observe(noisy(i), at)
+		if i < minAnomalySamples && !math.IsNaN(sc.ZScore) {
+			t.Fatalf("AnomalyDetector.Observe() = %+v, want no z-score before %d samples", sc, minAnomalySamples)
+		}
+		if sc.Anomalous {
+			t.Fatalf("AnomalyDetector.Observe() flagged %v at sample %d", sc.Value, i)
+		}
+	}
+	tests := []struct {
+		name      string
+		v         float64
+		detectors []string
+	}{
+		{"usual value", 0.51, nil},
+		{"pegged", 1.0, []string{detectorZScore, detectorMAD}},
+		{"idle", 0.0, []string{detectorZScore, detectorMAD}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Scoring adds to history, so every case scores a copy.
+			dc := *d
+			dc.history = &SampleSeries{keep: d.history.keep, samples: append([]timedSample(nil), d.history.samples...)}
+			dc.seasonal = &seasonalBaseline{days: d.seasonal.days, hours: d.seasonal.hours}
+			sc := dc.Observe(tt.v, at.Add(time.Second))
+			if !reflect.DeepEqual(sc.Detectors, tt.detectors) || sc.Anomalous != (tt.detectors != nil) {
+				t.Errorf("AnomalyDetector.Observe() = %+v, want detectors %v", sc, tt.detectors)
+			}
+			if !math.IsNaN(sc.SeasonalScore) {
+				t.Errorf("AnomalyDetector.Observe() SeasonalScore = %v, want NaN without previous days", sc.SeasonalScore)
+			}
+		})
+	}
+}
+
+func TestSeasonalBaseline(t *testing.T) {
+	sb := &seasonalBaseline{days: 3}
+	// Three days busy at noon and quiet at night.
+	day := time.Date(2020, 9, 13, 0, 0, 0, 0, time.Local)
+	for d := 0; d < 3; d++ {
+		for i := 0; i < 40; i++ {
+			at := day.AddDate(0, 0, d).Add(time.Duration(i) * time.Minute)
+			sb.Insert(0.1+noisy(i)/10, at.Add(2*time.Hour))
+			sb.Insert(0.8+noisy(i)/10, at.Add(12*time.Hour))
+		}
+	}
+	today := day.AddDate(0, 0, 3)
+	tests := []struct {
+		name    string
+		v       float64
+		at      time.Time
+		anomaly bool
+	}{
+		{"quiet night", 0.15, today.Add(2 * time.Hour), false},
+		{"busy night", 0.85, today.Add(2 * time.Hour), true},
+		{"busy noon", 0.85, today.Add(12 * time.Hour), false},
+		{"quiet noon", 0.15, today.Add(12 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sb.Score(tt.v, tt.at)
+			if (math.Abs(got) > 3.5) != tt.anomaly {
+				t.Errorf("seasonalBaseline.Score() = %v, want anomalous %v", got, tt.anomaly)
+			}
+		})
+	}
+	if got := sb.Score(0.5, today.Add(6*time.Hour)); !math.IsNaN(got) {
+		t.Errorf("seasonalBaseline.Score() = %v, want NaN for an hour never seen", got)
+	}
+	// Values of today do not count towards today's baseline, and only the
+	// most recent days are kept.
+	sb.Insert(0.5, today.Add(2*time.Hour))
+	if n := len(sb.hours[2]); n != 3 {
+		t.Errorf("seasonalBaseline keeps %d days, want 3", n)
+	}
+	if got := sb.Score(0.15, today.Add(2*time.Hour)); math.IsNaN(got) || math.Abs(got) > 3.5 {
+		t.Errorf("seasonalBaseline.Score() = %v, want baseline of the two previous days", got)
+	}
+}
+
+func TestSummaries_ObserveAnomalies(t *testing.T) {
+	defer func(a *AnomalyTracker) { anomalies = a }(anomalies)
+	anomalies = NewAnomalyTracker(time.Hour, 7, 3.5)
+	s := &Summaries{m: map[string]*IntervalReport{
+		"worker-1/1": {Role: "worker-1", PID: 1, WindowRate: 0.25, RSSBytes: 100},
+		"worker-1/2": {Role: "worker-1", PID: 2, WindowRate: 0.25, RSSBytes: 100},
+		"manager/3":  {Role: "manager", PID: 3, WindowRate: math.NaN(), RSSBytes: 10},
+	}}
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.Local)
+	for i := 0; i < minAnomalySamples; i++ {
+		s.m["worker-1/1"].WindowRate = noisy(i) / 2
+		s.ObserveAnomalies(start.Add(time.Duration(i) * time.Second))
+	}
+	s.m["worker-1/1"].WindowRate = 0.75
+	s.ObserveAnomalies(start.Add(time.Minute))
+
+	got := anomalies.Scores("worker-1")
+	if sc := got[anomalyWindowRate]; sc == nil || sc.Value != 1.0 || !sc.Anomalous {
+		t.Errorf("AnomalyTracker.Scores() window_rate = %+v, want anomalous sum of instances", sc)
+	}
+	if sc := got[anomalyRSSBytes]; sc == nil || sc.Value != 200 || sc.Anomalous || !math.IsNaN(sc.ZScore) {
+		t.Errorf("AnomalyTracker.Scores() rss_bytes = %+v, want steady RSS without spread", sc)
+	}
+	if _, ok := anomalies.Scores("manager")[anomalyWindowRate]; ok {
+		t.Errorf("AnomalyTracker.Scores() has window_rate of a role without any rate")
+	}
+	out := anomaliesString("worker-1", got)
+	for _, want := range []string{
+		`bro_role_anomaly{role="worker-1",series="window_rate"} 1`,
+		`bro_role_anomaly_score{role="worker-1",series="rss_bytes",detector="seasonal"} NaN`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("anomaliesString() is missing %s:\n%s", want, out)
+		}
+	}
+	data, err := json.Marshal(got[anomalyRSSBytes])
+	if err != nil {
+		t.Fatalf("json.Marshal() of AnomalyScore failed with: %v", err)
+	}
+	if js := string(data); strings.Contains(js, "zscore") || strings.Contains(js, "seasonal_score") || !strings.Contains(js, `"value":200`) {
+		t.Errorf("AnomalyScore.MarshalJSON() = %s, want scores without enough history left out", js)
+	}
+}
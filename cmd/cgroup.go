@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+// cgroupRoot is where the unified (v2) cgroup hierarchy is mounted. It is
+// configurable mainly so that tests can point it at a fixture tree, and unless
+// given on command line, it is under sysfs root, see HostFS. It is emptied
+// when no unified hierarchy is mounted there, see checkCgroupRoot.
+var cgroupRoot string
+
+// checkCgroupRoot returns an error unless the unified hierarchy is mounted at
+// root, which has cgroup.controllers at its top. On hosts with the hybrid
+// layout, /sys/fs/cgroup is a tmpfs holding v1 hierarchies, and paths of
+// unified cgroups do not exist under it.
+func checkCgroupRoot(root string) error {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("no unified cgroup hierarchy at %s: %v", root, err)
+	}
+	return nil
+}
+
+// parseProcCgroup returns path of the unified hierarchy cgroup from contents
+// of /proc/<pid>/cgroup, which is the line with hierarchy ID zero, such as
+// 0::/system.slice/zeek.service
+func parseProcCgroup(data []byte) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), true
+		}
+	}
+	return "", false
+}
+
+// parseFlatKeyed parses cgroup files made of "key value" lines, such as
+// cpu.stat and memory.events.
+func parseFlatKeyed(data []byte) (map[string]uint64, error) {
+	var m = make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value of %s: %v", fields[0], err)
+		}
+		m[fields[0]] = v
+	}
+	return m, scanner.Err()
+}
+
+// CgroupCPUStat is contents of cpu.stat. Throttling counters are only there
+// when the cpu controller is enabled for the cgroup.
+type CgroupCPUStat struct {
+	UsageUsec     uint64 `json:"usage_usec"`
+	UserUsec      uint64 `json:"user_usec"`
+	SystemUsec    uint64 `json:"system_usec"`
+	NrPeriods     uint64 `json:"nr_periods"`
+	NrThrottled   uint64 `json:"nr_throttled"`
+	ThrottledUsec uint64 `json:"throttled_usec"`
+}
+
+// CgroupMemoryEvents is contents of memory.events, each a count of times the
+// event occurred.
+type CgroupMemoryEvents struct {
+	Low     uint64 `json:"low"`
+	High    uint64 `json:"high"`
+	Max     uint64 `json:"max"`
+	OOM     uint64 `json:"oom"`
+	OOMKill uint64 `json:"oom_kill"`
+}
+
+// CgroupIOStat is a single device line from io.stat.
+type CgroupIOStat struct {
+	Device string `json:"device"`
+	RBytes uint64 `json:"rbytes"`
+	WBytes uint64 `json:"wbytes"`
+	RIOs   uint64 `json:"rios"`
+	WIOs   uint64 `json:"wios"`
+	DBytes uint64 `json:"dbytes"`
+	DIOs   uint64 `json:"dios"`
+}
+
+func parseIOStat(data []byte) ([]CgroupIOStat, error) {
+	var l = []CgroupIOStat{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		st := CgroupIOStat{Device: fields[0]}
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			v, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad value of %s for %s: %v", parts[0], st.Device, err)
+			}
+			switch parts[0] {
+			case "rbytes":
+				st.RBytes = v
+			case "wbytes":
+				st.WBytes = v
+			case "rios":
+				st.RIOs = v
+			case "wios":
+				st.WIOs = v
+			case "dbytes":
+				st.DBytes = v
+			case "dios":
+				st.DIOs = v
+			}
+		}
+		l = append(l, st)
+	}
+	return l, scanner.Err()
+}
+
+// CgroupStats are resource figures of the cgroup a monitored process belongs
+// to. When systemd slices impose CPU quotas, throttling explains plateaus in
+// CPU rate which otherwise look like saturation. MemoryMax is -1 when there
+// is no limit. ThrottledShare is share of enforcement periods since previous
+// sample in which the cgroup was throttled.
+type CgroupStats struct {
+	Path           string             `json:"path"`
+	CPU            CgroupCPUStat      `json:"cpu"`
+	ThrottledShare float64            `json:"throttled_share"`
+	MemoryCurrent  uint64             `json:"memory_current"`
+	MemoryMax      int64              `json:"memory_max"`
+	MemoryEvents   CgroupMemoryEvents `json:"memory_events"`
+	IO             []CgroupIOStat     `json:"io"`
+	PidsCurrent    uint64             `json:"pids_current"`
+	Pressure       PressureStats      `json:"pressure,omitempty"`
+}
+
+func readCgroupFile(dir, name string) ([]byte, bool) {
+	data, err := ReadFileNoStat(filepath.Join(dir, name))
+	if err != nil {
+		// Files of controllers which are not enabled for this cgroup are
+		// simply not there, which is not an error.
+		if !os.IsNotExist(err) {
+			handleErr(err, false)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+// readCgroupStats collects stats of cgroup at path, relative to root.
+func readCgroupStats(root, path string) (*CgroupStats, error) {
+	var dir = filepath.Join(root, path)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	st := &CgroupStats{Path: path, MemoryMax: -1, ThrottledShare: math.NaN(), IO: []CgroupIOStat{}}
+	if data, ok := readCgroupFile(dir, "cpu.stat"); ok {
+		m, err := parseFlatKeyed(data)
+		if err != nil {
+			return nil, err
+		}
+		st.CPU = CgroupCPUStat{
+			UsageUsec:     m["usage_usec"],
+			UserUsec:      m["user_usec"],
+			SystemUsec:    m["system_usec"],
+			NrPeriods:     m["nr_periods"],
+			NrThrottled:   m["nr_throttled"],
+			ThrottledUsec: m["throttled_usec"],
+		}
+	}
+	if data, ok := readCgroupFile(dir, "memory.current"); ok {
+		v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad memory.current: %v", err)
+		}
+		st.MemoryCurrent = v
+	}
+	if data, ok := readCgroupFile(dir, "memory.max"); ok {
+		if v := strings.TrimSpace(string(data)); v != "max" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad memory.max: %v", err)
+			}
+			st.MemoryMax = n
+		}
+	}
+	if data, ok := readCgroupFile(dir, "memory.events"); ok {
+		m, err := parseFlatKeyed(data)
+		if err != nil {
+			return nil, err
+		}
+		st.MemoryEvents = CgroupMemoryEvents{
+			Low:     m["low"],
+			High:    m["high"],
+			Max:     m["max"],
+			OOM:     m["oom"],
+			OOMKill: m["oom_kill"],
+		}
+	}
+	if data, ok := readCgroupFile(dir, "io.stat"); ok {
+		l, err := parseIOStat(data)
+		if err != nil {
+			return nil, err
+		}
+		st.IO = l
+	}
+	if data, ok := readCgroupFile(dir, "pids.current"); ok {
+		v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad pids.current: %v", err)
+		}
+		st.PidsCurrent = v
+	}
+	// Pressure is best effort, failing to read it must not cost us the
+	// rest of the stats.
+	if ps, err := readPressureStats(dir, ".pressure"); err == nil && len(ps) > 0 {
+		st.Pressure = ps
+	}
+	return st, nil
+}
+
+// updateSince computes ThrottledShare from change in CPU throttling counters
+// since prev, along with pressure stall time deltas. Prev must be from the
+// same cgroup.
+func (st *CgroupStats) updateSince(prev *CgroupStats) {
+	if prev == nil || prev.Path != st.Path {
+		return
+	}
+	st.Pressure.updateDeltas(prev.Pressure)
+	if st.CPU.NrPeriods <= prev.CPU.NrPeriods {
+		return
+	}
+	st.ThrottledShare = float64(st.CPU.NrThrottled-prev.CPU.NrThrottled) /
+		float64(st.CPU.NrPeriods-prev.CPU.NrPeriods)
+}
+
+func (st CgroupStats) safe() *CgroupStats {
+	if math.IsNaN(st.ThrottledShare) {
+		st.ThrottledShare = -1
+	}
+	return &st
+}
+
+// String returns the stats in Prometheus format with given labels.
+func (st CgroupStats) String(labels string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bro_cgroup_throttled_periods_total{%s} %d\n", labels, st.CPU.NrThrottled)
+	fmt.Fprintf(&b, "bro_cgroup_throttled_usec_total{%s} %d\n", labels, st.CPU.ThrottledUsec)
+	fmt.Fprintf(&b, "bro_cgroup_memory_current_bytes{%s} %d\n", labels, st.MemoryCurrent)
+	if st.MemoryMax >= 0 {
+		fmt.Fprintf(&b, "bro_cgroup_memory_max_bytes{%s} %d\n", labels, st.MemoryMax)
+	}
+	fmt.Fprintf(&b, "bro_cgroup_oom_kills_total{%s} %d\n", labels, st.MemoryEvents.OOMKill)
+	fmt.Fprintf(&b, "bro_cgroup_pids_current{%s} %d\n", labels, st.PidsCurrent)
+	b.WriteString(st.Pressure.String("bro_cgroup", labels))
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFixture creates files under root, keyed by their path relative to root.
+func writeFixture(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_parseProcCgroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   string
+		wantOk bool
+	}{
+		{name: "unified only", data: "0::/system.slice/zeek.service\n",
+			want: "/system.slice/zeek.service", wantOk: true},
+		{name: "hybrid", data: "12:cpu,cpuacct:/system.slice\n1:name=systemd:/system.slice/zeek.service\n0::/system.slice/zeek.service\n",
+			want: "/system.slice/zeek.service", wantOk: true},
+		{name: "legacy only", data: "12:cpu,cpuacct:/system.slice\n", want: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseProcCgroup([]byte(tt.data))
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseProcCgroup() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_readCgroupStats(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"system.slice/zeek.service/cpu.stat": "usage_usec 9000000\nuser_usec 8000000\nsystem_usec 1000000\n" +
+			"nr_periods 1000\nnr_throttled 250\nthrottled_usec 4000000\n",
+		"system.slice/zeek.service/memory.current": "1073741824\n",
+		"system.slice/zeek.service/memory.max":     "2147483648\n",
+		"system.slice/zeek.service/memory.events":  "low 0\nhigh 12\nmax 3\noom 1\noom_kill 1\n",
+		"system.slice/zeek.service/io.stat":        "8:0 rbytes=4096 wbytes=1048576 rios=1 wios=256 dbytes=0 dios=0\n",
+		"system.slice/zeek.service/pids.current":   "42\n",
+		"system.slice/zeek.service/io.pressure":    "some avg10=12.00 avg60=8.00 avg300=2.00 total=700\nfull avg10=10.00 avg60=6.00 avg300=1.00 total=600\n",
+		"system.slice/other.service/memory.max":    "max\n",
+	})
+
+	st, err := readCgroupStats(root, "/system.slice/zeek.service")
+	if err != nil {
+		t.Fatalf("readCgroupStats() error = %v", err)
+	}
+	wantCPU := CgroupCPUStat{UsageUsec: 9000000, UserUsec: 8000000, SystemUsec: 1000000,
+		NrPeriods: 1000, NrThrottled: 250, ThrottledUsec: 4000000}
+	if st.CPU != wantCPU {
+		t.Errorf("readCgroupStats().CPU = %+v, want %+v", st.CPU, wantCPU)
+	}
+	if st.MemoryCurrent != 1<<30 || st.MemoryMax != 1<<31 || st.PidsCurrent != 42 {
+		t.Errorf("readCgroupStats() = %+v, want 1GiB current, 2GiB max and 42 pids", st)
+	}
+	if st.MemoryEvents != (CgroupMemoryEvents{High: 12, Max: 3, OOM: 1, OOMKill: 1}) {
+		t.Errorf("readCgroupStats().MemoryEvents = %+v", st.MemoryEvents)
+	}
+	if p, ok := st.Pressure["io"]; !ok || p.Full.Avg10 != 10 {
+		t.Errorf("readCgroupStats().Pressure = %+v, want io full avg10 of 10", st.Pressure)
+	}
+	if _, ok := st.Pressure["cpu"]; ok {
+		t.Errorf("readCgroupStats().Pressure must not have cpu without cpu.pressure")
+	}
+	wantIO := []CgroupIOStat{{Device: "8:0", RBytes: 4096, WBytes: 1048576, RIOs: 1, WIOs: 256}}
+	if !reflect.DeepEqual(st.IO, wantIO) {
+		t.Errorf("readCgroupStats().IO = %+v, want %+v", st.IO, wantIO)
+	}
+
+	// Controllers which are not enabled leave their files out, and pressure
+	// which cannot be read leaves out only pressure.
+	if err := os.MkdirAll(filepath.Join(root, "system.slice/other.service/cpu.pressure"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	other, err := readCgroupStats(root, "/system.slice/other.service")
+	if err != nil {
+		t.Fatalf("readCgroupStats() error = %v", err)
+	}
+	if other.MemoryMax != -1 || other.CPU != (CgroupCPUStat{}) || other.Pressure != nil {
+		t.Errorf("readCgroupStats() = %+v, want no memory limit and no CPU stats", other)
+	}
+
+	if _, err := readCgroupStats(root, "/no/such.service"); !os.IsNotExist(err) {
+		t.Errorf("readCgroupStats() error = %v, want not exist for missing cgroup", err)
+	}
+}
+
+func Test_checkCgroupRoot(t *testing.T) {
+	unified, hybrid := t.TempDir(), t.TempDir()
+	writeFixture(t, unified, map[string]string{"cgroup.controllers": "cpu io memory pids\n"})
+	writeFixture(t, hybrid, map[string]string{"cpu,cpuacct/cgroup.procs": "1\n"})
+	if err := checkCgroupRoot(unified); err != nil {
+		t.Errorf("checkCgroupRoot() error = %v for unified hierarchy", err)
+	}
+	if err := checkCgroupRoot(hybrid); err == nil {
+		t.Errorf("checkCgroupRoot() expected error for hybrid layout")
+	}
+}
+
+func TestCgroupStats_updateSince(t *testing.T) {
+	prev := &CgroupStats{Path: "/zeek", CPU: CgroupCPUStat{NrPeriods: 1000, NrThrottled: 250}}
+	st := &CgroupStats{Path: "/zeek", CPU: CgroupCPUStat{NrPeriods: 1010, NrThrottled: 258}}
+	st.updateSince(prev)
+	if !tolerance(st.ThrottledShare, 0.8, 1e-9) {
+		t.Errorf("CgroupStats.updateSince() = %v, want 0.8", st.ThrottledShare)
+	}
+
+	moved := &CgroupStats{Path: "/elsewhere", ThrottledShare: -2, CPU: CgroupCPUStat{NrPeriods: 1020}}
+	moved.updateSince(prev)
+	if moved.ThrottledShare != -2 {
+		t.Errorf("CgroupStats.updateSince() must not compare different cgroups")
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"strings"
 )
@@ -33,7 +32,7 @@ func cmdLineArgs(pid int) *CommandLine {
 	var programName string
 	var restOfCmdline []string
 
-	var cmdLinePath = fmt.Sprintf("/proc/%d/cmdline", pid)
+	var cmdLinePath = hostFS.PIDPath(pid, "cmdline")
 	if f, err = os.Open(cmdLinePath); err != nil {
 		handleErr(err, false)
 	}
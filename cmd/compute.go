@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"sort"
 )
 
 func sum(nums []float64) float64 {
@@ -59,3 +60,85 @@ func avg(nums []float64) float64 {
 	var cnt = len(nums) - skip
 	return sum(nums) / float64(cnt)
 }
+
+func withoutNaNs(nums []float64) []float64 {
+	var l = make([]float64, 0, len(nums))
+	for _, n := range nums {
+		if !math.IsNaN(n) {
+			l = append(l, n)
+		}
+	}
+	return l
+}
+
+// coefficientOfVariation is the standard deviation relative to the mean, which
+// makes spread of series with very different means comparable.
+func coefficientOfVariation(nums []float64) float64 {
+	nums = withoutNaNs(nums)
+	if len(nums) < 2 {
+		return math.NaN()
+	}
+	return stddev(nums) / avg(nums)
+}
+
+// maxMeanRatio is the ratio between largest value and the mean. With perfectly
+// even values it is 1, and it grows as one value runs away from the rest.
+func maxMeanRatio(nums []float64) float64 {
+	nums = withoutNaNs(nums)
+	if len(nums) == 0 {
+		return math.NaN()
+	}
+	var max = nums[0]
+	for _, n := range nums[1:] {
+		if n > max {
+			max = n
+		}
+	}
+	return max / avg(nums)
+}
+
+// gini computes the Gini coefficient of non-negative values, which is 0 when
+// all values are equal and approaches 1 as a single value takes everything.
+func gini(nums []float64) float64 {
+	nums = withoutNaNs(nums)
+	if len(nums) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(nums))
+	copy(sorted, nums)
+	sort.Float64s(sorted)
+	var n = float64(len(sorted))
+	var weighted float64
+	for i, v := range sorted {
+		weighted += (2*float64(i+1) - n - 1) * v
+	}
+	return weighted / (n * sum(sorted))
+}
+
+// median returns the middle value, or mean of the two middle values, skipping
+// any NaNs.
+func median(nums []float64) float64 {
+	nums = withoutNaNs(nums)
+	if len(nums) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(nums))
+	copy(sorted, nums)
+	sort.Float64s(sorted)
+	var mid = len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// medianAbsoluteDeviation is the median of absolute deviations from the
+// median, a measure of spread which a few outliers do not move much.
+func medianAbsoluteDeviation(nums []float64) float64 {
+	var m = median(nums)
+	var devs = make([]float64, 0, len(nums))
+	for _, n := range withoutNaNs(nums) {
+		devs = append(devs, math.Abs(n-m))
+	}
+	return median(devs)
+}
@@ -314,3 +314,106 @@ func tolerance(actual, want, ε float64) bool {
 	}
 	return want-actual <= ε
 }
+
+func Test_coefficientOfVariation(t *testing.T) {
+	tests := []struct {
+		name string
+		nums []float64
+		want float64
+		ε    float64
+	}{
+		{name: "evenly loaded workers", nums: []float64{0.5, 0.5, 0.5, 0.5}, want: 0, ε: 0},
+		// Sample standard deviation is 0.45 and mean is 0.325.
+		{name: "one pegged worker", nums: []float64{0.1, 0.1, 0.1, 1.0}, want: 0.45 / 0.325, ε: 0.000000001},
+		{name: "NaNs are skipped", nums: []float64{math.NaN(), 0.5, 0.5}, want: 0, ε: 0},
+		{name: "single value", nums: []float64{0.5}, want: math.NaN(), ε: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coefficientOfVariation(tt.nums); !tolerance(got, tt.want, tt.ε) {
+				t.Errorf("coefficientOfVariation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_maxMeanRatio(t *testing.T) {
+	tests := []struct {
+		name string
+		nums []float64
+		want float64
+		ε    float64
+	}{
+		{name: "evenly loaded workers", nums: []float64{0.5, 0.5, 0.5, 0.5}, want: 1, ε: 0},
+		{name: "one pegged worker", nums: []float64{0.1, 0.1, 0.1, 1.0}, want: 1.0 / 0.325, ε: 0.000000001},
+		{name: "empty", nums: []float64{}, want: math.NaN(), ε: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxMeanRatio(tt.nums); !tolerance(got, tt.want, tt.ε) {
+				t.Errorf("maxMeanRatio() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gini(t *testing.T) {
+	tests := []struct {
+		name string
+		nums []float64
+		want float64
+		ε    float64
+	}{
+		{name: "evenly loaded workers", nums: []float64{0.5, 0.5, 0.5, 0.5}, want: 0, ε: 0},
+		{name: "one worker takes everything", nums: []float64{0, 0, 0, 1}, want: 0.75, ε: 0.000000001},
+		{name: "order does not matter", nums: []float64{3, 1, 2}, want: 2.0 / 9.0, ε: 0.000000001},
+		{name: "empty", nums: []float64{}, want: math.NaN(), ε: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gini(tt.nums); !tolerance(got, tt.want, tt.ε) {
+				t.Errorf("gini() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_median(t *testing.T) {
+	tests := []struct {
+		name string
+		nums []float64
+		want float64
+	}{
+		{name: "odd count", nums: []float64{3, 1, 2}, want: 2},
+		{name: "even count", nums: []float64{4, 1, 3, 2}, want: 2.5},
+		{name: "NaNs are skipped", nums: []float64{math.NaN(), 1, 5}, want: 3},
+		{name: "empty", nums: []float64{}, want: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := median(tt.nums); !tolerance(got, tt.want, 0) {
+				t.Errorf("median() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_medianAbsoluteDeviation(t *testing.T) {
+	tests := []struct {
+		name string
+		nums []float64
+		want float64
+	}{
+		{name: "same values", nums: []float64{0.5, 0.5, 0.5}, want: 0},
+		// Deviations from median of 2 are 1, 1, 0, 0, 2, 4, 7.
+		{name: "outlier barely counts", nums: []float64{1, 1, 2, 2, 4, 6, 9}, want: 1},
+		{name: "empty", nums: []float64{}, want: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medianAbsoluteDeviation(tt.nums); !tolerance(got, tt.want, 0) {
+				t.Errorf("medianAbsoluteDeviation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
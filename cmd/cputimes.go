@@ -2,12 +2,32 @@ package main
 
 // CPUTimes tracks two observations of time for a process. There are
 // two samples stored previous observation and current, for the purposes
-// of computing a delta.
+// of computing a delta. Times are in ticks or nanoseconds, depending on
+// source they come from, see cpuSourceTicks and cpuSourceSchedstat.
 type CPUTimes struct {
-	PrevRunTime      int64 // Total time spent running on or off CPU - last
-	CurrentRunTime   int64 // Total time spent running on or off CPU - latest
-	PrevOnCPUTime    int64 // Time spent on CPU - last
-	CurrentOnCPUTime int64 // Time spent on CPU - latest
+	PrevRunTime      int64  // Total time spent running on or off CPU - last
+	CurrentRunTime   int64  // Total time spent running on or off CPU - latest
+	PrevOnCPUTime    int64  // Time spent on CPU - last
+	CurrentOnCPUTime int64  // Time spent on CPU - latest
+	Source           string // Where times come from
+}
+
+// Update takes a new observation of times from source, and returns true if
+// there is a delta to be computed. If we don't have any previous observation,
+// or it came from a different source, or times went backwards, which means we
+// are no longer looking at same process, we set both previous and current
+// values to this observation, which leaves no delta.
+func (t *CPUTimes) Update(onCPU, runTime int64, source string) bool {
+	if t.PrevRunTime == 0 || t.Source != source ||
+		onCPU < t.CurrentOnCPUTime || runTime < t.CurrentRunTime {
+		t.PrevOnCPUTime, t.CurrentOnCPUTime = onCPU, onCPU
+		t.PrevRunTime, t.CurrentRunTime = runTime, runTime
+		t.Source = source
+		return false
+	}
+	t.PrevOnCPUTime, t.CurrentOnCPUTime = t.CurrentOnCPUTime, onCPU
+	t.PrevRunTime, t.CurrentRunTime = t.CurrentRunTime, runTime
+	return true
 }
 
 // Delta computes a derivative between current sample and previously taken
@@ -29,4 +49,5 @@ func (t *CPUTimes) Reset() {
 	t.CurrentRunTime = 0
 	t.PrevOnCPUTime = 0
 	t.CurrentOnCPUTime = 0
+	t.Source = ""
 }
@@ -117,3 +117,32 @@ func TestCPUTimes_Reset(t *testing.T) {
 		})
 	}
 }
+
+func TestCPUTimes_Update(t *testing.T) {
+	steps := []struct {
+		name      string
+		onCPU     int64
+		runTime   int64
+		source    string
+		wantDelta bool
+		want      float64
+	}{
+		{name: "first sample", onCPU: 100, runTime: 1000, source: cpuSourceTicks},
+		{name: "next sample", onCPU: 150, runTime: 1100, source: cpuSourceTicks, wantDelta: true, want: 0.5},
+		{name: "switch to schedstat", onCPU: 1500000000, runTime: 11000000000, source: cpuSourceSchedstat},
+		{name: "schedstat sample", onCPU: 1750000000, runTime: 12000000000, source: cpuSourceSchedstat, wantDelta: true, want: 0.25},
+		{name: "process restarted", onCPU: 5, runTime: 10, source: cpuSourceSchedstat},
+	}
+	var tr CPUTimes
+	for _, st := range steps {
+		if got := tr.Update(st.onCPU, st.runTime, st.source); got != st.wantDelta {
+			t.Fatalf("Update() %s = %v, want %v", st.name, got, st.wantDelta)
+		}
+		if tr.Source != st.source || tr.CurrentOnCPUTime != st.onCPU || tr.CurrentRunTime != st.runTime {
+			t.Errorf("Update() %s = %+v", st.name, tr)
+		}
+		if st.wantDelta && tr.Delta() != st.want {
+			t.Errorf("Delta() after %s = %v, want %v", st.name, tr.Delta(), st.want)
+		}
+	}
+}
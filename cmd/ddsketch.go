@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultSketchAccuracy is relative accuracy of quantiles estimated by
+// DDSketch, meaning the estimate of any quantile is within 1% of the value it
+// estimates.
+const defaultSketchAccuracy = 0.01
+
+// sketchMinIndexable is the smallest magnitude counted in a bucket of its own,
+// anything closer to zero is counted as zero.
+const sketchMinIndexable = 1e-9
+
+// DDSketch is a quantile sketch with relative-error guarantees, as described
+// in "DDSketch: A Fast and Fully-Mergeable Quantile Sketch with Relative-Error
+// Guarantees" by Masson, Rim and Lee. Values are counted in logarithmically
+// sized buckets, so the sketch stays small however many values it sees, and two
+// sketches with the same accuracy merge without any loss.
+type DDSketch struct {
+	gamma     float64
+	logGamma  float64
+	positive  map[int]uint64
+	negative  map[int]uint64
+	zeroCount uint64
+	count     uint64
+	sum       float64
+	min       float64
+	max       float64
+}
+
+// NewDDSketch returns an empty sketch with the given relative accuracy.
+func NewDDSketch(accuracy float64) *DDSketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &DDSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+		min:      math.Inf(+1),
+		max:      math.Inf(-1),
+	}
+}
+
+func (s *DDSketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns estimate of values counted in bucket i, which is the point
+// with the same relative distance to both bounds of the bucket.
+func (s *DDSketch) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+// Insert adds a value to the sketch. NaNs are ignored.
+func (s *DDSketch) Insert(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	switch {
+	case v > sketchMinIndexable:
+		s.positive[s.index(v)]++
+	case v < -sketchMinIndexable:
+		s.negative[s.index(-v)]++
+	default:
+		s.zeroCount++
+	}
+	s.count++
+	s.sum += v
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+}
+
+// Merge adds every value counted in other, which must have the same accuracy,
+// to this sketch.
+func (s *DDSketch) Merge(other *DDSketch) {
+	for i, n := range other.positive {
+		s.positive[i] += n
+	}
+	for i, n := range other.negative {
+		s.negative[i] += n
+	}
+	s.zeroCount += other.zeroCount
+	s.count += other.count
+	s.sum += other.sum
+	s.min = math.Min(s.min, other.min)
+	s.max = math.Max(s.max, other.max)
+}
+
+// Count returns number of values in the sketch.
+func (s *DDSketch) Count() uint64 {
+	return s.count
+}
+
+// Sum returns sum of values in the sketch.
+func (s *DDSketch) Sum() float64 {
+	return s.sum
+}
+
+// Max returns the largest value in the sketch, NaN if it is empty.
+func (s *DDSketch) Max() float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	return s.max
+}
+
+// Quantile returns estimate of q-quantile, NaN if the sketch is empty. The
+// estimate is clamped to the exact minimum and maximum.
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	rank := uint64(q * float64(s.count-1))
+	var seen uint64
+	var keys []int
+	for i := range s.negative {
+		keys = append(keys, i)
+	}
+	// Most negative values come first, which are those with highest index.
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	for _, i := range keys {
+		if seen += s.negative[i]; seen > rank {
+			return s.clamp(-s.value(i))
+		}
+	}
+	if seen += s.zeroCount; seen > rank {
+		return s.clamp(0)
+	}
+	keys = keys[:0]
+	for i := range s.positive {
+		keys = append(keys, i)
+	}
+	sort.Ints(keys)
+	for _, i := range keys {
+		if seen += s.positive[i]; seen > rank {
+			return s.clamp(s.value(i))
+		}
+	}
+	return s.max
+}
+
+func (s *DDSketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestDDSketch_Quantile(t *testing.T) {
+	tests := []struct {
+		name string
+		gen  func(r *rand.Rand) float64
+	}{
+		{"uniform rates", func(r *rand.Rand) float64 { return r.Float64() }},
+		{"exponential", func(r *rand.Rand) float64 { return r.ExpFloat64() * 1e6 }},
+		{"normal around zero", func(r *rand.Rand) float64 { return r.NormFloat64() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(8191))
+			s := NewDDSketch(defaultSketchAccuracy)
+			var values []float64
+			for i := 0; i < 10000; i++ {
+				v := tt.gen(r)
+				values = append(values, v)
+				s.Insert(v)
+			}
+			sort.Float64s(values)
+			for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+				want := values[int(q*float64(len(values)-1))]
+				got := s.Quantile(q)
+				if math.Abs(got-want) > defaultSketchAccuracy*math.Abs(want)+1e-9 {
+					t.Errorf("DDSketch.Quantile(%g) = %v, want %v within %g%%", q, got, want, defaultSketchAccuracy*100)
+				}
+			}
+			if s.Max() != values[len(values)-1] || s.Count() != 10000 {
+				t.Errorf("DDSketch.Max() = %v, Count() = %d, want %v and 10000", s.Max(), s.Count(), values[len(values)-1])
+			}
+		})
+	}
+}
+
+func TestDDSketch_Merge(t *testing.T) {
+	a, b, all := NewDDSketch(0.01), NewDDSketch(0.01), NewDDSketch(0.01)
+	for i := 0; i < 1000; i++ {
+		v := float64(i) / 1000
+		all.Insert(v)
+		if i%2 == 0 {
+			a.Insert(v)
+		} else {
+			b.Insert(v)
+		}
+	}
+	a.Merge(b)
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		if a.Quantile(q) != all.Quantile(q) {
+			t.Errorf("merged DDSketch.Quantile(%g) = %v, want %v", q, a.Quantile(q), all.Quantile(q))
+		}
+	}
+	if !tolerance(a.Sum(), all.Sum(), 1e-9) || a.Count() != all.Count() {
+		t.Errorf("merged DDSketch sum and count = %v, %d, want %v, %d", a.Sum(), a.Count(), all.Sum(), all.Count())
+	}
+}
+
+func TestDDSketch_empty(t *testing.T) {
+	s := NewDDSketch(defaultSketchAccuracy)
+	s.Insert(math.NaN())
+	if !math.IsNaN(s.Quantile(0.5)) || !math.IsNaN(s.Max()) || s.Count() != 0 {
+		t.Errorf("empty DDSketch = %v, %v, %d, want NaNs", s.Quantile(0.5), s.Max(), s.Count())
+	}
+	s.Insert(0)
+	if s.Quantile(0.99) != 0 {
+		t.Errorf("DDSketch.Quantile() = %v, want 0", s.Quantile(0.99))
+	}
+}
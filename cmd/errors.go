@@ -5,3 +5,6 @@ import (
 )
 
 var errNoInfoForRole = errors.New("no information is available for this role")
+var errNoZeekLogs = errors.New("no Zeek log directory is configured")
+var errNoTaskstats = errors.New("no taskstats in netlink response")
+var errNoNetlinkAnswer = errors.New("no answer in netlink response")
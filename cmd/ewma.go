@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// EWMA is an exponentially weighted moving average of a series sampled at
+// irregular intervals, the way the kernel computes load averages. A sample's
+// weight decays to 1/e of what it was after tau.
+type EWMA struct {
+	tau   time.Duration
+	value float64
+	last  time.Time
+	set   bool
+}
+
+// NewEWMA returns an average with time constant tau.
+func NewEWMA(tau time.Duration) *EWMA {
+	return &EWMA{tau: tau}
+}
+
+// Update adds value v sampled at time at. The first value is taken as it is.
+// NaNs are ignored.
+func (e *EWMA) Update(v float64, at time.Time) {
+	if math.IsNaN(v) {
+		return
+	}
+	if !e.set {
+		e.value, e.last, e.set = v, at, true
+		return
+	}
+	dt := at.Sub(e.last)
+	if dt <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-dt.Seconds()/e.tau.Seconds())
+	e.value += alpha * (v - e.value)
+	e.last = at
+}
+
+// Value returns current average, NaN if nothing was added yet.
+func (e *EWMA) Value() float64 {
+	if !e.set {
+		return math.NaN()
+	}
+	return e.value
+}
+
+// RateEWMAs are load-average-style moving averages of CPU rate.
+type RateEWMAs struct {
+	OneMinute      float64 `json:"1m"`
+	FiveMinutes    float64 `json:"5m"`
+	FifteenMinutes float64 `json:"15m"`
+}
+
+// rateAverages keeps moving averages over one, five and fifteen minutes.
+type rateAverages [3]*EWMA
+
+func newRateAverages() rateAverages {
+	return rateAverages{NewEWMA(time.Minute), NewEWMA(5 * time.Minute), NewEWMA(15 * time.Minute)}
+}
+
+func (ra rateAverages) Update(v float64, at time.Time) {
+	for _, e := range ra {
+		e.Update(v, at)
+	}
+}
+
+func (ra rateAverages) Values() *RateEWMAs {
+	return &RateEWMAs{ra[0].Value(), ra[1].Value(), ra[2].Value()}
+}
+
+func (r RateEWMAs) safe() *RateEWMAs {
+	for _, v := range []*float64{&r.OneMinute, &r.FiveMinutes, &r.FifteenMinutes} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return &r
+}
+
+func (r RateEWMAs) String(labels string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bro_cpu_rate_ewma{%s,window=\"1m\"} %g\n", labels, r.OneMinute)
+	fmt.Fprintf(&b, "bro_cpu_rate_ewma{%s,window=\"5m\"} %g\n", labels, r.FiveMinutes)
+	fmt.Fprintf(&b, "bro_cpu_rate_ewma{%s,window=\"15m\"} %g\n", labels, r.FifteenMinutes)
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEWMA_Update(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		values []float64
+		step   time.Duration
+		want   float64
+	}{
+		{"empty", nil, time.Second, math.NaN()},
+		{"first value", []float64{0.5}, time.Second, 0.5},
+		{"one time constant", []float64{0, 1}, time.Minute, 1 - math.Exp(-1)},
+		{"NaN ignored", []float64{0.5, math.NaN()}, time.Second, 0.5},
+		{"same time ignored", []float64{0.5, 1}, 0, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEWMA(time.Minute)
+			for i, v := range tt.values {
+				e.Update(v, start.Add(time.Duration(i)*tt.step))
+			}
+			got := e.Value()
+			if math.IsNaN(tt.want) != math.IsNaN(got) || !math.IsNaN(got) && !tolerance(got, tt.want, 1e-9) {
+				t.Errorf("EWMA.Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateAverages(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	ra := newRateAverages()
+	ra.Update(0, start)
+	for i := 1; i <= 300; i++ {
+		ra.Update(1, start.Add(time.Duration(i)*time.Second))
+	}
+	got := ra.Values()
+	// Shorter averages follow a step change sooner.
+	if !(got.OneMinute > got.FiveMinutes && got.FiveMinutes > got.FifteenMinutes) {
+		t.Errorf("rateAverages.Values() = %+v, want 1m > 5m > 15m", got)
+	}
+	if !tolerance(got.FiveMinutes, 1-math.Exp(-1), 1e-9) {
+		t.Errorf("rateAverages.Values() 5m = %v, want %v", got.FiveMinutes, 1-math.Exp(-1))
+	}
+	if safe := newRateAverages().Values().safe(); safe.OneMinute != -1 || safe.FifteenMinutes != -1 {
+		t.Errorf("RateEWMAs.safe() = %+v, want -1 for missing averages", safe)
+	}
+}
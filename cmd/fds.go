@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultLimitWarnThreshold is share of a soft limit in use above which we
+// warn about running out of a resource.
+const defaultLimitWarnThreshold = 0.8
+
+// FDCounts is number of open file descriptors of a process by what they
+// refer to. Anything which is not a socket, pipe, anonymous inode or regular
+// file, such as a device, counts as Other.
+type FDCounts struct {
+	Total     int `json:"total"`
+	Socket    int `json:"socket"`
+	Pipe      int `json:"pipe"`
+	Regular   int `json:"regular"`
+	AnonInode int `json:"anon_inode"`
+	Other     int `json:"other"`
+}
+
+// classifyFD tells what a file descriptor refers to from target of its link
+// under /proc/<pid>/fd, such as socket:[12345] or /var/log/zeek/conn.log
+func classifyFD(target string) string {
+	switch {
+	case strings.HasPrefix(target, "socket:"):
+		return "socket"
+	case strings.HasPrefix(target, "pipe:"):
+		return "pipe"
+	case strings.HasPrefix(target, "anon_inode:"):
+		return "anon_inode"
+	case strings.HasPrefix(target, "/dev/"):
+		return "other"
+	case strings.HasPrefix(target, "/"):
+		return "regular"
+	}
+	return "other"
+}
+
+// socketInode extracts inode number from a socket:[12345] link target.
+func socketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+	ino, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	return ino, err == nil
+}
+
+// countFDs reads every link in fd directory, counting descriptors by kind and
+// collecting inodes of sockets along the way. Descriptors closed while we are
+// reading are skipped.
+func countFDs(fdDir string) (FDCounts, []uint64, error) {
+	var counts FDCounts
+	var sockets []uint64
+	f, err := os.Open(fdDir)
+	if err != nil {
+		return counts, nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return counts, nil, err
+	}
+	for _, name := range names {
+		target, err := os.Readlink(filepath.Join(fdDir, name))
+		if err != nil {
+			continue
+		}
+		counts.Total++
+		switch classifyFD(target) {
+		case "socket":
+			counts.Socket++
+			if ino, ok := socketInode(target); ok {
+				sockets = append(sockets, ino)
+			}
+		case "pipe":
+			counts.Pipe++
+		case "anon_inode":
+			counts.AnonInode++
+		case "regular":
+			counts.Regular++
+		default:
+			counts.Other++
+		}
+	}
+	return counts, sockets, nil
+}
+
+// ResourceLimit is a soft and hard limit from /proc/<pid>/limits, where -1
+// means unlimited.
+type ResourceLimit struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+var limitsLine = regexp.MustCompile(`^(Max [a-z ]+?)\s{2,}(\S+)\s+(\S+)`)
+
+func parseLimitValue(v string) (int64, error) {
+	if v == "unlimited" {
+		return -1, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// parseLimits parses contents of /proc/<pid>/limits into limits keyed by their
+// name, such as "Max open files".
+func parseLimits(data []byte) (map[string]ResourceLimit, error) {
+	var m = make(map[string]ResourceLimit)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		match := limitsLine.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		soft, err := parseLimitValue(match[2])
+		if err != nil {
+			return nil, fmt.Errorf("bad soft limit of %s: %v", match[1], err)
+		}
+		hard, err := parseLimitValue(match[3])
+		if err != nil {
+			return nil, fmt.Errorf("bad hard limit of %s: %v", match[1], err)
+		}
+		m[match[1]] = ResourceLimit{Soft: soft, Hard: hard}
+	}
+	return m, scanner.Err()
+}
+
+// LimitUsage is how much of a resource is used relative to its limits.
+// Utilization is NaN when the limit is unlimited.
+type LimitUsage struct {
+	Used            int64   `json:"used"`
+	Soft            int64   `json:"soft"`
+	Hard            int64   `json:"hard"`
+	SoftUtilization float64 `json:"soft_utilization"`
+	HardUtilization float64 `json:"hard_utilization"`
+}
+
+func newLimitUsage(used int64, l ResourceLimit) LimitUsage {
+	var utilization = func(limit int64) float64 {
+		if limit <= 0 {
+			return math.NaN()
+		}
+		return float64(used) / float64(limit)
+	}
+	return LimitUsage{
+		Used:            used,
+		Soft:            l.Soft,
+		Hard:            l.Hard,
+		SoftUtilization: utilization(l.Soft),
+		HardUtilization: utilization(l.Hard),
+	}
+}
+
+func (u LimitUsage) safe() LimitUsage {
+	if math.IsNaN(u.SoftUtilization) {
+		u.SoftUtilization = -1
+	}
+	if math.IsNaN(u.HardUtilization) {
+		u.HardUtilization = -1
+	}
+	return u
+}
+
+// ResourceUsage is use of file descriptors and other limited resources by a
+// process. Zeek loggers leaking file handles after log rotation eventually
+// hit EMFILE, and Warnings lists every limit whose soft utilization is above
+// the warning threshold, so we hear about it before that.
+// Note that process limit applies to all processes of a user, while Used is
+// only threads of this process, which makes it a lower bound.
+type ResourceUsage struct {
+	FDs          FDCounts       `json:"fds"`
+	OpenFiles    LimitUsage     `json:"open_files"`
+	Processes    LimitUsage     `json:"processes"`
+	LockedMemory LimitUsage     `json:"locked_memory"`
+	AddressSpace LimitUsage     `json:"address_space"`
+	Warnings     []LimitWarning `json:"warnings"`
+}
+
+// LimitWarning says that use of a resource is getting close to its limit.
+type LimitWarning struct {
+	Limit   string `json:"limit"`
+	Message string `json:"message"`
+}
+
+// newResourceUsage puts counts of descriptors and other figures in context of
+// limits. Locked memory is expected in bytes. Warnings are disabled when warnAt
+// is not a positive number.
+func newResourceUsage(fds FDCounts, limits map[string]ResourceLimit,
+	s ProcStat, lockedBytes int64, warnAt float64) *ResourceUsage {
+	ru := &ResourceUsage{
+		FDs:          fds,
+		OpenFiles:    newLimitUsage(int64(fds.Total), limits["Max open files"]),
+		Processes:    newLimitUsage(int64(s.NumThreads), limits["Max processes"]),
+		LockedMemory: newLimitUsage(lockedBytes, limits["Max locked memory"]),
+		AddressSpace: newLimitUsage(int64(s.VSize), limits["Max address space"]),
+		Warnings:     []LimitWarning{},
+	}
+	for _, lu := range []struct {
+		name  string
+		usage LimitUsage
+	}{
+		{"open files", ru.OpenFiles},
+		{"processes", ru.Processes},
+		{"locked memory", ru.LockedMemory},
+		{"address space", ru.AddressSpace},
+	} {
+		if warnAt > 0 && lu.usage.SoftUtilization >= warnAt {
+			ru.Warnings = append(ru.Warnings, LimitWarning{
+				Limit: lu.name,
+				Message: fmt.Sprintf("%s at %.0f%% of soft limit %d",
+					lu.name, lu.usage.SoftUtilization*100, lu.usage.Soft),
+			})
+		}
+	}
+	return ru
+}
+
+func (ru ResourceUsage) safe() *ResourceUsage {
+	ru.OpenFiles = ru.OpenFiles.safe()
+	ru.Processes = ru.Processes.safe()
+	ru.LockedMemory = ru.LockedMemory.safe()
+	ru.AddressSpace = ru.AddressSpace.safe()
+	return &ru
+}
+
+// String returns resource usage in Prometheus format with given labels.
+func (ru ResourceUsage) String(labels string) string {
+	var b strings.Builder
+	for _, kc := range []struct {
+		kind  string
+		count int
+	}{
+		{"socket", ru.FDs.Socket},
+		{"pipe", ru.FDs.Pipe},
+		{"regular", ru.FDs.Regular},
+		{"anon_inode", ru.FDs.AnonInode},
+		{"other", ru.FDs.Other},
+	} {
+		fmt.Fprintf(&b, "bro_open_fds{%s,kind=\"%s\"} %d\n", labels, kc.kind, kc.count)
+	}
+	for _, lu := range []struct {
+		name  string
+		usage LimitUsage
+	}{
+		{"open_files", ru.OpenFiles},
+		{"processes", ru.Processes},
+		{"locked_memory", ru.LockedMemory},
+		{"address_space", ru.AddressSpace},
+	} {
+		if !math.IsNaN(lu.usage.SoftUtilization) {
+			fmt.Fprintf(&b, "bro_limit_soft_utilization{%s,limit=\"%s\"} %g\n", labels, lu.name, lu.usage.SoftUtilization)
+		}
+	}
+	return b.String()
+}
+
+// lockedMemoryBytes returns VmLck from contents of /proc/<pid>/status.
+func lockedMemoryBytes(status []byte) int64 {
+	v, ok := statusField(status, "VmLck")
+	if !ok {
+		return 0
+	}
+	kb, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(v, "kB")), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return kb * 1024
+}
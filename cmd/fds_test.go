@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const limitsSample = `Limit                     Soft Limit           Hard Limit           Units     
+Max cpu time              unlimited            unlimited            seconds   
+Max file size             unlimited            unlimited            bytes     
+Max processes             63413                63413                processes 
+Max open files            1024                 524288               files     
+Max locked memory         8388608              8388608              bytes     
+Max address space         unlimited            unlimited            bytes     
+Max realtime timeout      unlimited            unlimited            us        
+`
+
+func Test_classifyFD(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"socket:[1234567]", "socket"},
+		{"pipe:[7654321]", "pipe"},
+		{"anon_inode:[eventfd]", "anon_inode"},
+		{"anon_inode:[eventpoll]", "anon_inode"},
+		{"/var/log/zeek/conn.log", "regular"},
+		{"/var/log/zeek/conn.log (deleted)", "regular"},
+		{"/dev/null", "other"},
+		{"net:[4026531840]", "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := classifyFD(tt.target); got != tt.want {
+				t.Errorf("classifyFD() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_countFDs(t *testing.T) {
+	dir := t.TempDir()
+	for name, target := range map[string]string{
+		"0": "/dev/null", "1": "pipe:[100]", "3": "socket:[200]",
+		"4": "socket:[201]", "5": "/var/log/zeek/conn.log", "6": "anon_inode:[eventfd]",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, sockets, err := countFDs(dir)
+	if err != nil {
+		t.Fatalf("countFDs() error = %v", err)
+	}
+	want := FDCounts{Total: 6, Socket: 2, Pipe: 1, Regular: 1, AnonInode: 1, Other: 1}
+	if counts != want {
+		t.Errorf("countFDs() = %+v, want %+v", counts, want)
+	}
+	if len(sockets) != 2 || sockets[0]+sockets[1] != 401 {
+		t.Errorf("countFDs() socket inodes = %v, want 200 and 201", sockets)
+	}
+}
+
+func Test_parseLimits(t *testing.T) {
+	limits, err := parseLimits([]byte(limitsSample))
+	if err != nil {
+		t.Fatalf("parseLimits() error = %v", err)
+	}
+	if got := limits["Max open files"]; got != (ResourceLimit{Soft: 1024, Hard: 524288}) {
+		t.Errorf("parseLimits() open files = %+v", got)
+	}
+	if got := limits["Max address space"]; got != (ResourceLimit{Soft: -1, Hard: -1}) {
+		t.Errorf("parseLimits() address space = %+v, want unlimited", got)
+	}
+	if len(limits) != 7 {
+		t.Errorf("parseLimits() found %d limits, want 7", len(limits))
+	}
+}
+
+func Test_newResourceUsage(t *testing.T) {
+	limits, _ := parseLimits([]byte(limitsSample))
+	ru := newResourceUsage(FDCounts{Total: 900, Regular: 880, Socket: 20}, limits,
+		ProcStat{NumThreads: 9, VSize: 1 << 30}, 4096, 0.8)
+	if !tolerance(ru.OpenFiles.SoftUtilization, 900.0/1024, 1e-9) {
+		t.Errorf("newResourceUsage().OpenFiles = %+v", ru.OpenFiles)
+	}
+	if !math.IsNaN(ru.AddressSpace.SoftUtilization) {
+		t.Errorf("newResourceUsage().AddressSpace utilization must be NaN when unlimited")
+	}
+	if len(ru.Warnings) != 1 || ru.Warnings[0].Limit != "open files" {
+		t.Errorf("newResourceUsage().Warnings = %+v, want one for open files", ru.Warnings)
+	}
+	if ru = newResourceUsage(FDCounts{Total: 1024}, limits, ProcStat{}, 0, 0); len(ru.Warnings) != 0 {
+		t.Errorf("newResourceUsage().Warnings = %+v, want none when disabled", ru.Warnings)
+	}
+	if got := ru.safe().AddressSpace.SoftUtilization; got != -1 {
+		t.Errorf("ResourceUsage.safe() = %v, want -1", got)
+	}
+}
+
+func Test_lockedMemoryBytes(t *testing.T) {
+	if got := lockedMemoryBytes([]byte("VmPeak:\t  123 kB\nVmLck:\t      16 kB\n")); got != 16384 {
+		t.Errorf("lockedMemoryBytes() = %d, want 16384", got)
+	}
+	if got := lockedMemoryBytes([]byte("Name:\tzeek\n")); got != 0 {
+		t.Errorf("lockedMemoryBytes() = %d, want 0", got)
+	}
+}
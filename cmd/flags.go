@@ -1,12 +1,86 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"log"
+)
 
 func setupCliFlags() {
 	flag.StringVar(&exeLocation, "exeLocation", "/workspace/sandbox/bin/bro", "Path to executable to be monitored")
 	flag.IntVar(&port, "port", defaultPort, "Listen on this port")
 	flag.DurationVar(&reportInterval, "report-interval", defaultReportInterval, "Print summaries for all monitored processes with this interval")
 	flag.StringVar(&hostname, "hostname", defaultHostname, "Address on which to listen")
-	flag.Uint64Var(&windowSize, "window-size", defaultWindowSize, "Length of samples window over which statistics are calculated; the larger the number, the smoother the data")
+	flag.Var(&statsWindows, "windows", "Comma-separated windows of wall-clock time over which statistics are calculated, the shortest of which gives window_rate; the longer the window, the smoother the data")
+	flag.Uint64Var(&windowSize, "window-size", 0, "Deprecated, use -windows instead; number of samples, taken a second apart, in the shortest window over which statistics are calculated")
+	flag.BoolVar(&preciseCPU, "precise-cpu", false, "Compute CPU rates from per-thread schedstat nanoseconds rather than clock ticks, falling back to ticks where schedstat is not available")
+	flag.BoolVar(&useTaskstats, "taskstats", false, "Query CPU, block IO, swap-in, memory reclaim and thrashing delays of monitored processes over taskstats netlink; delays are only counted with kernel.task_delayacct enabled")
+	flag.Var(&groups, "group", "Aggregate roles matching a pattern as name=pattern, e.g. workers=worker-*; may be repeated")
+	flag.DurationVar(&imbalanceWindow, "imbalance-window", defaultImbalanceWindow, "Flag a role group once its CPU load stays skewed for this long")
+	flag.Float64Var(&imbalanceThreshold, "imbalance-threshold", defaultImbalanceThreshold, "Coefficient of variation of CPU rates in a role group above which load is considered skewed")
+	flag.StringVar(&nodeCfg, "node-cfg", "", "Path to Zeek's node.cfg, used to learn intended CPU pinning, capture interface and telemetry endpoint of each node")
+	flag.Var(pinning, "pin", "CPUs a role is meant to be pinned to as role=cpus, e.g. worker-1=2-3; may be repeated")
+	flag.Var(capture, "interface", "Interface a role captures traffic from as role=interface, e.g. worker-1=eth0; may be repeated")
+	flag.StringVar(&zeekLogDir, "zeek-logs", "", "Zeek's log directory, e.g. /opt/zeek/logs/current, from which stats.log, capture_loss.log and reporter.log are followed")
+	flag.IntVar(&reporterKeep, "reporter-keep", defaultReporterKeep, "Number of most recent reporter.log messages kept for each role")
+	flag.Var(telemetry, "telemetry", "URL where a role serves Zeek's own telemetry as role=url, e.g. worker-1=http://localhost:9991/metrics; may be repeated")
+	flag.StringVar(&suricataExe, "suricata-exe", "", "Path to Suricata executable, to be monitored beside Zeek")
+	flag.StringVar(&suricataSocket, "suricata-socket", defaultSuricataSocket, "Path to Suricata's unix command socket")
+	flag.Var(histograms, "hist", "Bucket layout of a histogrammed series (cpu_rate, rss_growth or io_rate) as series=kind:parameters, e.g. cpu_rate=linear:0.05,0.05,20 or io_rate=exponential:4096,4,10 or rss_growth=explicit:0,1048576; may be repeated")
+	flag.Var(&quantileWindows, "quantile-windows", "Comma-separated windows over which p50, p90, p99 and max of CPU rate and other series are estimated for each role")
+	flag.DurationVar(&anomalyWindow, "anomaly-window", defaultAnomalyWindow, "Rolling window of a role's history against which z-score and median absolute deviation of its CPU rate and RSS are computed")
+	flag.IntVar(&anomalyDays, "anomaly-days", defaultAnomalyDays, "Number of previous days over which hour-of-day baseline of a role's CPU rate and RSS is kept")
+	flag.Float64Var(&anomalyThreshold, "anomaly-threshold", defaultAnomalyThreshold, "Score, in units of spread, beyond which a role's CPU rate or RSS is flagged as anomalous")
+	flag.Var(&trendHorizons, "trend-horizons", "Comma-separated horizons over which growth of each role's RSS and PSS is fitted, to spot slow leaks")
+	flag.Int64Var(&memoryCeiling, "memory-ceiling", 0, "Bytes of memory a role may use, against which time to limit is projected; by default the cgroup's memory.max is used")
+	flag.StringVar(&hostFS.Proc, "procfs-root", defaultProcfsRoot, "Mount point of host's procfs, e.g. /host/proc when running in a container")
+	flag.StringVar(&hostFS.Sys, "sysfs-root", defaultSysfsRoot, "Mount point of host's sysfs, e.g. /host/sys when running in a container")
+	flag.StringVar(&hostFS.Root, "host-root", defaultHostRoot, "Mount point of host's root filesystem, e.g. /host when running in a container, through which executables are matched")
+	flag.StringVar(&cgroupRoot, "cgroup-root", defaultCgroupRoot, "Mount point of the unified (v2) cgroup hierarchy")
+	flag.Float64Var(&limitWarnThreshold, "limit-warn", defaultLimitWarnThreshold, "Warn when use of open files or other resource reaches this share of its soft limit")
 	flag.Parse()
+	var set = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	// Unless given, cgroup hierarchy is where it is under sysfs.
+	if !set["cgroup-root"] {
+		cgroupRoot = hostFS.SysPath("fs", "cgroup")
+	}
+	switch {
+	case set["window-size"] && set["windows"]:
+		log.Printf("Ignoring deprecated -window-size, -windows is given")
+	case set["window-size"]:
+		windows, err := windowsWithSize(statsWindows, windowSize)
+		handleErr(err, true)
+		log.Printf("-window-size is deprecated, use -windows=%s instead", &windows)
+		statsWindows = windows
+	}
+	if len(groups) == 0 {
+		groups = defaultGroups
+	}
+	if nodeCfg != "" {
+		nodes, err := readNodeCfg(nodeCfg)
+		handleErr(err, true)
+		for _, node := range nodes {
+			for role, cpus := range node.Pinning() {
+				// Pinning given on command line wins over node.cfg.
+				if _, ok := pinning[role]; !ok {
+					pinning[role] = cpus
+				}
+			}
+			for role, url := range node.TelemetryEndpoints() {
+				if _, ok := telemetry[role]; !ok {
+					telemetry[role] = url
+				}
+			}
+			if node.Interface == "" {
+				continue
+			}
+			for _, role := range node.ProcessNames() {
+				if _, ok := capture[role]; !ok {
+					capture[role] = interfaceName(node.Interface)
+				}
+			}
+		}
+	}
 }
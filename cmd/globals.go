@@ -9,18 +9,165 @@ const defaultHostname = "localhost"
 const defaultPort = 8080
 const defaultReportInterval = time.Second * 5
 
-// defaultWindowSize is the number of samples for statistical functions like
-// average, standard deviation, etc. The larger the window the smoother the data
-// is going to appear, becasuse extreme observations play a lesser role as the
-// window size increases.
-const defaultWindowSize = 10
+// defaultStatsWindows are windows of wall-clock time over which statistical
+// functions like average, standard deviation, etc. are computed. The longer
+// the window the smoother the data is going to appear, becasuse extreme
+// observations play a lesser role as the window grows.
+var defaultStatsWindows = durations{time.Minute, 5 * time.Minute, 15 * time.Minute}
 
 var exeLocation string
 var hostname string
 var port int
+var statsWindows = append(durations(nil), defaultStatsWindows...)
+
+// windowSize is the number of samples in the shortest of statsWindows, which
+// is how the window used to be given. It is deprecated in favour of windows of
+// wall-clock time, see windowsWithSize.
 var windowSize uint64
 var reportInterval time.Duration
 
+// groups are the role groups over which we aggregate, see RoleGroup. Unless
+// any are given on command line, defaultGroups are used.
+var groups roleGroups
+
+// defaultImbalanceWindow is how long CPU load across a group has to stay
+// skewed before we flag it as a sustained imbalance.
+const defaultImbalanceWindow = time.Minute * 5
+
+// defaultImbalanceThreshold is the coefficient of variation of CPU rates in a
+// group above which load is considered skewed.
+const defaultImbalanceThreshold = 0.5
+
+var imbalanceWindow time.Duration
+var imbalanceThreshold float64
+
+// imbalance tracks load imbalance of role groups over time, it is created at
+// startup once flags are parsed.
+var imbalance *ImbalanceTracker
+
+var defaultGroups = roleGroups{
+	{Name: "workers", Pattern: "worker-*"},
+	{Name: "proxies", Pattern: "proxy-*"},
+}
+
+// nodeCfg is path to Zeek's node.cfg, if we are given one.
+var nodeCfg string
+
+// pinning maps roles to CPUs they are meant to be pinned to. It is populated
+// from pin_cpus in node.cfg and from -pin flags, the latter taking precedence.
+var pinning = make(pinnings)
+
+// capture maps roles to interfaces they capture traffic from. It is populated
+// from interface of workers in node.cfg and from -interface flags, the latter
+// taking precedence.
+var capture = make(captureInterfaces)
+
+// netIfs is periodically updated with statistics of capture interfaces.
+var netIfs *InterfaceCollector
+
+// zeekLogDir is Zeek's log directory with stats.log and capture_loss.log, if we
+// are given one.
+var zeekLogDir string
+
+// zeekLogs follows Zeek's logs, it is only created when zeekLogDir is set.
+var zeekLogs *ZeekLogs
+
+// reporterKeep is how many recent reporter.log messages are kept for each role.
+var reporterKeep int
+
+// zeekReporter follows Zeek's reporter.log, it is only created when zeekLogDir
+// is set.
+var zeekReporter *ZeekReporter
+
+// telemetry maps roles to URLs of their Prometheus telemetry. It is populated
+// from metrics_port in node.cfg and from -telemetry flags, the latter taking
+// precedence.
+var telemetry = make(telemetryEndpoints)
+
+// telemetryScraper scrapes telemetry of Zeek nodes and merges it into our own.
+var telemetryScraper *TelemetryScraper
+
+// suricataExe is path to Suricata executable, if it is to be monitored too.
+var suricataExe string
+
+// suricataSocket is path to Suricata's unix command socket.
+var suricataSocket string
+
+// suricata collects counters from Suricata, it is only created when
+// suricataExe is set.
+var suricata *Suricata
+
+// useTaskstats makes us query delay accounting of monitored processes over
+// taskstats netlink interface.
+var useTaskstats bool
+
+// taskstats queries delay accounting, it is only created when useTaskstats is
+// set and the kernel supports taskstats.
+var taskstats *TaskstatsClient
+
+// histograms are bucket layouts of histogrammed series given on command line,
+// any others use defaultHistLayouts.
+var histograms = make(histLayouts)
+
+// defaultQuantileWindows are windows over which quantiles are estimated unless
+// others are given on command line.
+var defaultQuantileWindows = durations{5 * time.Minute, time.Hour}
+
+var quantileWindows = append(durations(nil), defaultQuantileWindows...)
+
+// quantiles estimates quantiles of CPU rate and other series of every role, it
+// is created at startup once flags are parsed.
+var quantiles *QuantileTracker
+
+// defaultAnomalyWindow is how much history of a role's series is used for its
+// rolling z-score and median absolute deviation.
+const defaultAnomalyWindow = time.Hour
+
+// defaultAnomalyDays is how many previous days make up the seasonal baseline
+// of every hour of day.
+const defaultAnomalyDays = 7
+
+// defaultAnomalyThreshold is the score, in units of spread, beyond which a
+// value is flagged as anomalous.
+const defaultAnomalyThreshold = 3.5
+
+var anomalyWindow time.Duration
+var anomalyDays int
+var anomalyThreshold float64
+
+// defaultTrendHorizons are horizons over which memory trends are fitted unless
+// others are given on command line.
+var defaultTrendHorizons = durations{6 * time.Hour, 24 * time.Hour, 72 * time.Hour}
+
+var trendHorizons = append(durations(nil), defaultTrendHorizons...)
+
+// preciseCPU makes rates computed from schedstat nanoseconds rather than from
+// clock ticks, where the kernel supports it.
+var preciseCPU bool
+
+// memoryCeiling is memory in bytes a role may use before we consider it out
+// of memory, when set it is used instead of the cgroup's memory.max.
+var memoryCeiling int64
+
+// memoryTrends fits trends to RSS and PSS of every role, it is created at
+// startup once flags are parsed.
+var memoryTrends *MemoryTrendTracker
+
+// anomalies scores CPU rate and RSS of every role against its own history, it
+// is created at startup once flags are parsed.
+var anomalies *AnomalyTracker
+
+// limitWarnThreshold is share of a soft resource limit in use above which we
+// warn, see ResourceUsage.
+var limitWarnThreshold float64
+
+// hostCPU is periodically updated with utilization of the host's CPUs.
+var hostCPU *HostCPU
+
+// systemPressure is periodically updated with system-wide pressure stall
+// information.
+var systemPressure *SystemPressure
+
 var singleton sync.Once
 
 // metricsReport is the only instance of Summaries struct used in the program.
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errNoSuchGroup = errors.New("no such role group is configured")
+
+// RoleGroup is a named set of roles matched by a shell pattern, such as
+// worker-* or proxy-*, see path.Match for syntax of the pattern.
+type RoleGroup struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// Matches reports whether or not role belongs to this group.
+func (g RoleGroup) Matches(role string) bool {
+	ok, err := path.Match(g.Pattern, role)
+	return err == nil && ok
+}
+
+// roleGroups implements flag.Value, so that groups can be given on command
+// line as -group name=pattern, once for each group.
+type roleGroups []RoleGroup
+
+func (g *roleGroups) String() string {
+	if g == nil {
+		return ""
+	}
+	var l = make([]string, len(*g))
+	for i, group := range *g {
+		l[i] = group.Name + "=" + group.Pattern
+	}
+	return strings.Join(l, ",")
+}
+
+func (g *roleGroups) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected name=pattern, got %q", v)
+	}
+	if _, err := path.Match(parts[1], ""); err != nil {
+		return fmt.Errorf("bad pattern %q: %v", parts[1], err)
+	}
+	*g = append(*g, RoleGroup{Name: parts[0], Pattern: parts[1]})
+	return nil
+}
+
+// Find returns a group by its name.
+func (g roleGroups) Find(name string) (RoleGroup, bool) {
+	for _, group := range g {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return RoleGroup{}, false
+}
+
+// SeriesStats summarizes a single series, such as CPU rate, across all members
+// of a group. Spread is the difference between largest and smallest values.
+type SeriesStats struct {
+	Sum    float64 `json:"sum"`
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Spread float64 `json:"spread"`
+}
+
+// newSeriesStats computes stats over nums, skipping any NaNs. If there is
+// nothing left after skipping NaNs, everything except the sum is a NaN.
+func newSeriesStats(nums []float64) SeriesStats {
+	var st = SeriesStats{
+		Sum:  sum(nums),
+		Mean: avg(nums),
+		Min:  math.NaN(),
+		Max:  math.NaN(),
+	}
+	for _, n := range nums {
+		if math.IsNaN(n) {
+			continue
+		}
+		if math.IsNaN(st.Min) || n < st.Min {
+			st.Min = n
+		}
+		if math.IsNaN(st.Max) || n > st.Max {
+			st.Max = n
+		}
+	}
+	st.Spread = st.Max - st.Min
+	return st
+}
+
+// safe converts NaNs to -1's for the same reasons safeIntervalReport does.
+func (st SeriesStats) safe() SeriesStats {
+	for _, v := range []*float64{&st.Sum, &st.Mean, &st.Min, &st.Max, &st.Spread} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return st
+}
+
+// GroupSummary is an aggregate over all instances of all roles in a group.
+// Statistics are computed per instance, so mean CPU rate of a group is the
+// mean over every process in it, regardless of how processes map to roles.
+type GroupSummary struct {
+	RoleGroup
+	NumRoles     int         `json:"num_roles"`
+	NumInstances int         `json:"num_instances"`
+	Roles        []string    `json:"roles"`
+	CPURate      SeriesStats `json:"cpu_rate"`
+	RSSBytes     SeriesStats `json:"rss_bytes"`
+	// Imbalance of CPU rates across instances, see ImbalanceTracker. It is
+	// scored from window rates, which unlike current rates are not a single
+	// noisy one-second delta. Instances without a window rate yet are left
+	// out.
+	Imbalance   *ImbalanceState `json:"imbalance,omitempty"`
+	windowRates []float64
+}
+
+func (gs GroupSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bro_group_instances{group=\"%s\"} %d\n", gs.Name, gs.NumInstances)
+	for _, series := range []struct {
+		name string
+		st   SeriesStats
+	}{
+		{"cpu_rate", gs.CPURate},
+		{"rss_bytes", gs.RSSBytes},
+	} {
+		fmt.Fprintf(&b, "bro_group_%s_sum{group=\"%s\"} %g\n", series.name, gs.Name, series.st.Sum)
+		fmt.Fprintf(&b, "bro_group_%s_mean{group=\"%s\"} %g\n", series.name, gs.Name, series.st.Mean)
+		fmt.Fprintf(&b, "bro_group_%s_min{group=\"%s\"} %g\n", series.name, gs.Name, series.st.Min)
+		fmt.Fprintf(&b, "bro_group_%s_max{group=\"%s\"} %g\n", series.name, gs.Name, series.st.Max)
+		fmt.Fprintf(&b, "bro_group_%s_spread{group=\"%s\"} %g\n", series.name, gs.Name, series.st.Spread)
+	}
+	if gs.Imbalance != nil {
+		b.WriteString(gs.Imbalance.String(gs.Name))
+	}
+	return b.String()
+}
+
+// Group aggregates every instance whose role belongs to group g.
+func (s *Summaries) Group(g RoleGroup) *GroupSummary {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	var cpuRates, windowRates, rssBytes []float64
+	var roles = make(map[string]struct{})
+	for _, rep := range s.m {
+		if !g.Matches(rep.Role) {
+			continue
+		}
+		roles[rep.Role] = struct{}{}
+		cpuRates = append(cpuRates, rep.CurrentRate)
+		if !math.IsNaN(rep.WindowRate) {
+			windowRates = append(windowRates, rep.WindowRate)
+		}
+		rssBytes = append(rssBytes, float64(rep.RSSBytes))
+	}
+	gs := &GroupSummary{
+		RoleGroup:    g,
+		NumRoles:     len(roles),
+		NumInstances: len(cpuRates),
+		Roles:        make([]string, 0, len(roles)),
+		CPURate:      newSeriesStats(cpuRates),
+		RSSBytes:     newSeriesStats(rssBytes),
+		windowRates:  windowRates,
+	}
+	for role := range roles {
+		gs.Roles = append(gs.Roles, role)
+	}
+	sort.Strings(gs.Roles)
+	if imbalance != nil {
+		gs.Imbalance = imbalance.State(g.Name, newImbalanceScore(windowRates), time.Now())
+	}
+	return gs
+}
+
+// ObserveImbalance records current imbalance of every configured group with
+// the imbalance tracker.
+func (s *Summaries) ObserveImbalance(now time.Time) {
+	if imbalance == nil {
+		return
+	}
+	for _, gs := range s.Groups() {
+		imbalance.Observe(gs.Name, newImbalanceScore(gs.windowRates), now)
+	}
+}
+
+// Groups returns aggregates for every configured role group.
+func (s *Summaries) Groups() []*GroupSummary {
+	var l = make([]*GroupSummary, len(groups))
+	for i, g := range groups {
+		l[i] = s.Group(g)
+	}
+	return l
+}
+
+func safeGroupSummary(gs *GroupSummary) *GroupSummary {
+	safe := *gs
+	safe.CPURate = gs.CPURate.safe()
+	safe.RSSBytes = gs.RSSBytes.safe()
+	if gs.Imbalance != nil {
+		safe.Imbalance = gs.Imbalance.safe()
+	}
+	return &safe
+}
+
+// GroupToJSON returns serialized aggregate of a single configured group.
+func (s *Summaries) GroupToJSON(name string) ([]byte, error) {
+	g, ok := groups.Find(name)
+	if !ok {
+		return []byte{}, errNoSuchGroup
+	}
+	return json.Marshal(safeGroupSummary(s.Group(g)))
+}
+
+// GroupsToJSON returns serialized aggregates of all configured groups.
+func (s *Summaries) GroupsToJSON() ([]byte, error) {
+	var l = s.Groups()
+	for i, gs := range l {
+		l[i] = safeGroupSummary(gs)
+	}
+	return json.Marshal(l)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRoleGroup_Matches(t *testing.T) {
+	tests := []struct {
+		name string
+		g    RoleGroup
+		role string
+		want bool
+	}{
+		{name: "worker matches worker-*", g: RoleGroup{"workers", "worker-*"}, role: "worker-1-3", want: true},
+		{name: "proxy does not match worker-*", g: RoleGroup{"workers", "worker-*"}, role: "proxy-1", want: false},
+		{name: "exact role", g: RoleGroup{"manager", "manager"}, role: "manager", want: true},
+		{name: "bad pattern never matches", g: RoleGroup{"bad", "worker-["}, role: "worker-1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.g.Matches(tt.role); got != tt.want {
+				t.Errorf("RoleGroup.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_roleGroups_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    roleGroups
+		wantErr bool
+	}{
+		{name: "two groups", values: []string{"workers=worker-*", "proxies=proxy-*"},
+			want: roleGroups{{"workers", "worker-*"}, {"proxies", "proxy-*"}}},
+		{name: "missing pattern", values: []string{"workers="}, wantErr: true},
+		{name: "missing separator", values: []string{"worker-*"}, wantErr: true},
+		{name: "malformed pattern", values: []string{"workers=worker-["}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g roleGroups
+			var err error
+			for _, v := range tt.values {
+				if err = g.Set(v); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("roleGroups.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(g, tt.want) {
+				t.Errorf("roleGroups.Set() = %v, want %v", g, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newSeriesStats(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name string
+		nums []float64
+		want SeriesStats
+	}{
+		{name: "CPU rates of four workers", nums: []float64{0.5, 0.25, 1, 0.25},
+			want: SeriesStats{Sum: 2, Mean: 0.5, Min: 0.25, Max: 1, Spread: 0.75}},
+		{name: "NaNs are skipped", nums: []float64{nan, 0.5, nan, 0.75},
+			want: SeriesStats{Sum: 1.25, Mean: 0.625, Min: 0.5, Max: 0.75, Spread: 0.25}},
+		{name: "nothing but NaNs", nums: []float64{nan},
+			want: SeriesStats{Sum: 0, Mean: nan, Min: nan, Max: nan, Spread: nan}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSeriesStats(tt.nums)
+			if !tolerance(got.Sum, tt.want.Sum, 0) || !tolerance(got.Mean, tt.want.Mean, 0) ||
+				!tolerance(got.Min, tt.want.Min, 0) || !tolerance(got.Max, tt.want.Max, 0) ||
+				!tolerance(got.Spread, tt.want.Spread, 0) {
+				t.Errorf("newSeriesStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummaries_Group(t *testing.T) {
+	s := &Summaries{m: make(map[string]*IntervalReport)}
+	for _, r := range []*IntervalReport{
+		{Role: "worker-1", PID: 100, CurrentRate: 0.5, WindowRate: 0.4, RSSBytes: 1000},
+		{Role: "worker-1", PID: 101, CurrentRate: 0.25, WindowRate: 0.4, RSSBytes: 3000},
+		{Role: "worker-2", PID: 102, CurrentRate: 0.75, WindowRate: math.NaN(), RSSBytes: 2000},
+		{Role: "proxy-1", PID: 103, CurrentRate: 0.1, WindowRate: 0.1, RSSBytes: 500},
+	} {
+		s.Insert(r)
+	}
+	gs := s.Group(RoleGroup{Name: "workers", Pattern: "worker-*"})
+	if gs.NumRoles != 2 || gs.NumInstances != 3 {
+		t.Errorf("Summaries.Group() has %d roles and %d instances, want 2 and 3",
+			gs.NumRoles, gs.NumInstances)
+	}
+	if !reflect.DeepEqual(gs.Roles, []string{"worker-1", "worker-2"}) {
+		t.Errorf("Summaries.Group().Roles = %v, want [worker-1 worker-2]", gs.Roles)
+	}
+	want := SeriesStats{Sum: 1.5, Mean: 0.5, Min: 0.25, Max: 0.75, Spread: 0.5}
+	if gs.CPURate != want {
+		t.Errorf("Summaries.Group().CPURate = %+v, want %+v", gs.CPURate, want)
+	}
+	if gs.RSSBytes.Sum != 6000 || gs.RSSBytes.Spread != 2000 {
+		t.Errorf("Summaries.Group().RSSBytes = %+v, want sum 6000 and spread 2000", gs.RSSBytes)
+	}
+	if !reflect.DeepEqual(gs.windowRates, []float64{0.4, 0.4}) {
+		t.Errorf("Summaries.Group() window rates = %v, want those of instances which have one", gs.windowRates)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 // Histogram is a structure that implements a very basic histogram function,
@@ -13,8 +16,13 @@ import (
 type Histogram struct {
 	slots  []float64
 	counts []int64
+	sum    float64
 }
 
+// defaultHistBuckets are upper bounds of buckets of histograms created with
+// NewHist, chosen to cover the entire range of CPU rates.
+var defaultHistBuckets = []float64{0.0001, 0.001, 0.01, 0.1, 0.2, 0.4, 0.8, math.Inf(+1)}
+
 // NewHist returns a new histogram structure ready for use, initialized with
 // slots that should cover our entire range of values.
 // This histogram is cumulative, which means every bucket is a count of
@@ -22,11 +30,159 @@ type Histogram struct {
 // In other words every observation falls into the last bucket, because every
 // observation is going to be less than +Inf.
 func NewHist() *Histogram {
+	return NewHistWithBuckets(defaultHistBuckets)
+}
+
+// NewHistWithBuckets returns a new cumulative histogram with buckets with the
+// given upper bounds, see ExplicitBuckets, LinearBuckets and
+// ExponentialBuckets. Bounds are sorted and deduplicated, and a +Inf bucket is
+// added unless already present.
+func NewHistWithBuckets(bounds []float64) *Histogram {
+	var slots = make([]float64, 0, len(bounds)+1)
+	var sorted = append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	for i, b := range sorted {
+		if math.IsNaN(b) || (i > 0 && b == sorted[i-1]) {
+			continue
+		}
+		slots = append(slots, b)
+	}
+	if len(slots) == 0 || !math.IsInf(slots[len(slots)-1], +1) {
+		slots = append(slots, math.Inf(+1))
+	}
 	return &Histogram{
-		slots:  []float64{0.0001, 0.001, 0.01, 0.1, 0.2, 0.4, 0.8, math.Inf(+1)},
-		counts: make([]int64, 8),
+		slots:  slots,
+		counts: make([]int64, len(slots)),
+	}
+}
+
+// ExplicitBuckets returns the given upper bounds as they are.
+func ExplicitBuckets(bounds ...float64) []float64 {
+	return append([]float64(nil), bounds...)
+}
+
+// LinearBuckets returns count upper bounds, the lowest of which is start and
+// each following one width larger than previous one.
+func LinearBuckets(start, width float64, count int) []float64 {
+	var bounds = make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start + float64(i)*width
 	}
+	return bounds
+}
+
+// ExponentialBuckets returns count upper bounds, the lowest of which is start
+// and each following one factor times larger than previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	var bounds = make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start * math.Pow(factor, float64(i))
+	}
+	return bounds
+}
 
+// parseBucketLayout parses a bucket layout given as one of:
+//
+//	explicit:b1,b2,...
+//	linear:start,width,count
+//	exponential:start,factor,count
+func parseBucketLayout(s string) ([]float64, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected kind:parameters, got %q", s)
+	}
+	var nums []float64
+	for _, f := range strings.Split(parts[1], ",") {
+		n, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad bucket parameter %q: %v", f, err)
+		}
+		nums = append(nums, n)
+	}
+	var threeParams = func() (float64, float64, int, error) {
+		if len(nums) != 3 || nums[2] < 1 || nums[2] != math.Trunc(nums[2]) {
+			return 0, 0, 0, fmt.Errorf("%s buckets need start, step and a positive whole count, got %q", parts[0], parts[1])
+		}
+		return nums[0], nums[1], int(nums[2]), nil
+	}
+	switch parts[0] {
+	case "explicit":
+		return ExplicitBuckets(nums...), nil
+	case "linear":
+		start, width, count, err := threeParams()
+		if err != nil {
+			return nil, err
+		}
+		if width <= 0 {
+			return nil, fmt.Errorf("linear buckets need positive width, got %g", width)
+		}
+		return LinearBuckets(start, width, count), nil
+	case "exponential":
+		start, factor, count, err := threeParams()
+		if err != nil {
+			return nil, err
+		}
+		if start <= 0 || factor <= 1 {
+			return nil, fmt.Errorf("exponential buckets need positive start and factor above 1, got %g and %g", start, factor)
+		}
+		return ExponentialBuckets(start, factor, count), nil
+	}
+	return nil, fmt.Errorf("unknown bucket layout %q", parts[0])
+}
+
+// histLayouts maps names of histogrammed series to their bucket layouts.
+type histLayouts map[string][]float64
+
+func (hl histLayouts) String() string {
+	var l []string
+	for name, bounds := range hl {
+		l = append(l, fmt.Sprintf("%s=%v", name, bounds))
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (hl histLayouts) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected series=layout, got %q", v)
+	}
+	if _, ok := defaultHistLayouts[parts[0]]; !ok {
+		return fmt.Errorf("unknown histogram series %q", parts[0])
+	}
+	bounds, err := parseBucketLayout(parts[1])
+	if err != nil {
+		return err
+	}
+	hl[parts[0]] = bounds
+	return nil
+}
+
+// New returns a histogram of the named series, using default layout unless
+// another is configured.
+func (hl histLayouts) New(name string) *Histogram {
+	if bounds, ok := hl[name]; ok {
+		return NewHistWithBuckets(bounds)
+	}
+	return NewHistWithBuckets(defaultHistLayouts[name])
+}
+
+// Names of histogrammed series.
+const (
+	histCPURate   = "cpu_rate"
+	histRSSGrowth = "rss_growth"
+	histIORate    = "io_rate"
+)
+
+// defaultHistLayouts are bucket layouts of every series we histogram. CPU
+// rates of busy workers cluster between 0.4 and 0.8, which is why buckets are
+// finer there. RSS growth is in bytes per second and may be negative, IO rate
+// is in bytes per second.
+var defaultHistLayouts = histLayouts{
+	histCPURate: ExplicitBuckets(0.0001, 0.001, 0.01, 0.1, 0.2, 0.3, 0.4,
+		0.5, 0.6, 0.7, 0.8, 0.9, 1),
+	histRSSGrowth: ExplicitBuckets(-16<<20, -1<<20, -64<<10, 0, 64<<10, 1<<20, 16<<20),
+	histIORate:    ExponentialBuckets(4096, 4, 10),
 }
 
 // Insert is the primary method of the histogram used to insert new observations
@@ -36,20 +192,29 @@ func (h *Histogram) Insert(n float64) {
 	for i := len(h.slots) - 1; i >= slot; i-- {
 		h.counts[i]++
 	}
+	h.sum += n
+}
+
+// Sum returns sum of all observations.
+func (h *Histogram) Sum() float64 {
+	return h.sum
 }
 
 // Map returns contents of a histogram as float64->int64 map.
 func (h *Histogram) Map() map[float64]int64 {
-	return map[float64]int64{
-		0.0001:       h.counts[0],
-		0.001:        h.counts[1],
-		0.01:         h.counts[2],
-		0.1:          h.counts[3],
-		0.2:          h.counts[4],
-		0.4:          h.counts[5],
-		0.8:          h.counts[6],
-		math.Inf(+1): h.counts[7],
+	var m = make(map[float64]int64, len(h.slots))
+	for i, b := range h.slots {
+		m[b] = h.counts[i]
+	}
+	return m
+}
+
+// bucketKey formats upper bound of a bucket the way Prometheus does.
+func bucketKey(b float64) string {
+	if math.IsInf(b, +1) {
+		return "+Inf"
 	}
+	return strconv.FormatFloat(b, 'g', -1, 64)
 }
 
 // JSONSafeMap returns contents of a histogram as string->int64 map.
@@ -57,14 +222,60 @@ func (h *Histogram) Map() map[float64]int64 {
 // instead of floating point values, in order to properly serialize to JSON
 // without creating a custom encoder.
 func (h *Histogram) JSONSafeMap() map[string]int64 {
-	return map[string]int64{
-		"0.0001": h.counts[0],
-		"0.001":  h.counts[1],
-		"0.01":   h.counts[2],
-		"0.1":    h.counts[3],
-		"0.2":    h.counts[4],
-		"0.4":    h.counts[5],
-		"0.8":    h.counts[6],
-		"+Inf":   h.counts[7],
+	var m = make(map[string]int64, len(h.slots))
+	for i, b := range h.slots {
+		m[bucketKey(b)] = h.counts[i]
+	}
+	return m
+}
+
+// NonCumulativeMap is like JSONSafeMap, except each bucket is a count of only
+// those values which fell into it and not into any lower bucket.
+func (h *Histogram) NonCumulativeMap() map[string]int64 {
+	var m = make(map[string]int64, len(h.slots))
+	var below int64
+	for i, b := range h.slots {
+		m[bucketKey(b)] = h.counts[i] - below
+		below = h.counts[i]
+	}
+	return m
+}
+
+// Count returns number of observations.
+func (h *Histogram) Count() int64 {
+	if len(h.counts) == 0 {
+		return 0
+	}
+	return h.counts[len(h.counts)-1]
+}
+
+// HistogramView is a histogram in both of its views, ready for JSON.
+type HistogramView struct {
+	Cumulative    map[string]int64 `json:"cumulative"`
+	NonCumulative map[string]int64 `json:"non_cumulative"`
+	bounds        []float64
+	sum           float64
+}
+
+// View returns a snapshot of the histogram.
+func (h *Histogram) View() HistogramView {
+	return HistogramView{
+		Cumulative:    h.JSONSafeMap(),
+		NonCumulative: h.NonCumulativeMap(),
+		bounds:        append([]float64(nil), h.slots...),
+		sum:           h.sum,
+	}
+}
+
+// String returns cumulative view of a histogram of the named series in
+// Prometheus format with given labels.
+func (hv HistogramView) String(name, labels string) string {
+	var b strings.Builder
+	for _, bound := range hv.bounds {
+		key := bucketKey(bound)
+		fmt.Fprintf(&b, "bro_%s_bucket{%s,le=\"%s\"} %d\n", name, labels, key, hv.Cumulative[key])
 	}
+	fmt.Fprintf(&b, "bro_%s_sum{%s} %g\n", name, labels, hv.sum)
+	fmt.Fprintf(&b, "bro_%s_count{%s} %d\n", name, labels, hv.Cumulative["+Inf"])
+	return b.String()
 }
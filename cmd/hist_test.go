@@ -23,6 +23,17 @@ func TestNewHist(t *testing.T) {
 	}
 }
 
+// sumOfVariates returns sum of n random variates from seed, which is what a
+// histogram they are inserted into sums up to.
+func sumOfVariates(seed int64, n int) float64 {
+	rand.Seed(seed)
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += rand.Float64()
+	}
+	return sum
+}
+
 func TestHistogram_Insert(t *testing.T) {
 	type fields struct {
 		slots  []float64
@@ -53,6 +64,7 @@ func TestHistogram_Insert(t *testing.T) {
 			want: &Histogram{
 				slots:  []float64{0.0001, 0.001, 0.01, 0.1, 0.2, 0.4, 0.8, math.Inf(+1)},
 				counts: []int64{4, 19, 117, 1026, 2001, 3966, 8017, 10000},
+				sum:    sumOfVariates(981265, 10000),
 			},
 		},
 		{name: "10K Random variates from seed 2^19 - 1",
@@ -71,6 +83,7 @@ func TestHistogram_Insert(t *testing.T) {
 			want: &Histogram{
 				slots:  []float64{0.0001, 0.001, 0.01, 0.1, 0.2, 0.4, 0.8, math.Inf(+1)},
 				counts: []int64{2, 12, 99, 1052, 2056, 4047, 8019, 10000},
+				sum:    sumOfVariates(524287, 10000),
 			},
 		},
 		{name: "10K Random variates from seed 2^31 - 1",
@@ -89,6 +102,7 @@ func TestHistogram_Insert(t *testing.T) {
 			want: &Histogram{
 				slots:  []float64{0.0001, 0.001, 0.01, 0.1, 0.2, 0.4, 0.8, math.Inf(+1)},
 				counts: []int64{1, 15, 188, 1917, 3860, 7796, 15862, 20000},
+				sum:    sumOfVariates(2147483647, 10000),
 			},
 		},
 	}
@@ -196,3 +210,100 @@ func TestHistogram_JSONSafeMap(t *testing.T) {
 		})
 	}
 }
+
+func TestNewHistWithBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds []float64
+		want   []float64
+	}{
+		{"adds +Inf", []float64{0.5, 0.1}, []float64{0.1, 0.5, math.Inf(+1)}},
+		{"keeps +Inf", []float64{1, math.Inf(+1)}, []float64{1, math.Inf(+1)}},
+		{"dedups", []float64{1, 1, 2}, []float64{1, 2, math.Inf(+1)}},
+		{"empty", nil, []float64{math.Inf(+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewHistWithBuckets(tt.bounds)
+			if !reflect.DeepEqual(got.slots, tt.want) || len(got.counts) != len(tt.want) {
+				t.Errorf("NewHistWithBuckets() = %v, want slots %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBucketLayout(t *testing.T) {
+	tests := []struct {
+		layout  string
+		want    []float64
+		wantErr bool
+	}{
+		{"explicit:0.5,0.1,1", []float64{0.5, 0.1, 1}, false},
+		{"linear:0.4,0.1,5", LinearBuckets(0.4, 0.1, 5), false},
+		{"exponential:1024,2,4", []float64{1024, 2048, 4096, 8192}, false},
+		{"linear:0,0,5", nil, true},
+		{"linear:0,1,2.5", nil, true},
+		{"exponential:0,2,4", nil, true},
+		{"exponential:1,2", nil, true},
+		{"explicit:a", nil, true},
+		{"log:1,2,3", nil, true},
+		{"0.1,0.2", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			got, err := parseBucketLayout(tt.layout)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseBucketLayout() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBucketLayout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_histLayouts(t *testing.T) {
+	hl := make(histLayouts)
+	if err := hl.Set("cpu_rate=linear:0.5,0.25,2"); err != nil {
+		t.Fatalf("histLayouts.Set() error = %v", err)
+	}
+	if err := hl.Set("latency=linear:0,1,2"); err == nil {
+		t.Errorf("histLayouts.Set() expected error for unknown series")
+	}
+	if got, want := hl.New(histCPURate).slots, []float64{0.5, 0.75, math.Inf(+1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("histLayouts.New() slots = %v, want %v", got, want)
+	}
+	if got := hl.New(histIORate).slots; len(got) != len(defaultHistLayouts[histIORate])+1 {
+		t.Errorf("histLayouts.New() slots = %v, want default layout", got)
+	}
+}
+
+func TestHistogram_NonCumulativeMap(t *testing.T) {
+	h := NewHistWithBuckets(ExplicitBuckets(-1, 0, 1))
+	for _, v := range []float64{-5, -0.5, 0, 0.5, 0.75, 3} {
+		h.Insert(v)
+	}
+	wantCumulative := map[string]int64{"-1": 1, "0": 3, "1": 5, "+Inf": 6}
+	if got := h.JSONSafeMap(); !reflect.DeepEqual(got, wantCumulative) {
+		t.Errorf("Histogram.JSONSafeMap() = %v, want %v", got, wantCumulative)
+	}
+	wantNonCumulative := map[string]int64{"-1": 1, "0": 2, "1": 2, "+Inf": 1}
+	if got := h.NonCumulativeMap(); !reflect.DeepEqual(got, wantNonCumulative) {
+		t.Errorf("Histogram.NonCumulativeMap() = %v, want %v", got, wantNonCumulative)
+	}
+	if h.Count() != 6 {
+		t.Errorf("Histogram.Count() = %d, want 6", h.Count())
+	}
+	got := h.View().String("rss_growth", `role="w"`)
+	want := `bro_rss_growth_bucket{role="w",le="-1"} 1
+bro_rss_growth_bucket{role="w",le="0"} 3
+bro_rss_growth_bucket{role="w",le="1"} 5
+bro_rss_growth_bucket{role="w",le="+Inf"} 6
+bro_rss_growth_sum{role="w"} -1.25
+bro_rss_growth_count{role="w"} 6
+`
+	if got != want {
+		t.Errorf("HistogramView.String() = %v, want %v", got, want)
+	}
+}
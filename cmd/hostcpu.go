@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostCPUInterval is how often we sample /proc/stat.
+const HostCPUInterval = time.Second
+
+// CPUStat is a single cpu line from /proc/stat, all values are in clock ticks.
+type CPUStat struct {
+	User    uint64
+	Nice    uint64
+	System  uint64
+	Idle    uint64
+	IOWait  uint64
+	IRQ     uint64
+	SoftIRQ uint64
+	Steal   uint64
+}
+
+// Total is all time accounted for in this CPU, busy or not. Guest time is not
+// included, because it is already accounted for in user time.
+func (c CPUStat) Total() uint64 {
+	return c.User + c.Nice + c.System + c.Idle + c.IOWait + c.IRQ + c.SoftIRQ + c.Steal
+}
+
+// parseProcStat extracts cpu lines from contents of /proc/stat, returning the
+// aggregate line and per-CPU lines keyed by CPU number.
+func parseProcStat(data []byte) (CPUStat, map[int]CPUStat, error) {
+	var all CPUStat
+	var perCPU = make(map[int]CPUStat)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		var c CPUStat
+		for i, v := range []*uint64{&c.User, &c.Nice, &c.System, &c.Idle,
+			&c.IOWait, &c.IRQ, &c.SoftIRQ, &c.Steal} {
+			n, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return all, nil, fmt.Errorf("bad %s line in stat: %v", fields[0], err)
+			}
+			*v = n
+		}
+		if fields[0] == "cpu" {
+			all = c
+			continue
+		}
+		cpu, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil {
+			return all, nil, fmt.Errorf("bad cpu number in stat: %v", err)
+		}
+		perCPU[cpu] = c
+	}
+	return all, perCPU, scanner.Err()
+}
+
+// CPUUtilization is the share of time a CPU spent in each state between two
+// samples of /proc/stat. Busy is everything other than idle and iowait.
+type CPUUtilization struct {
+	User    float64 `json:"user"`
+	System  float64 `json:"system"`
+	IOWait  float64 `json:"iowait"`
+	IRQ     float64 `json:"irq"`
+	SoftIRQ float64 `json:"softirq"`
+	Steal   float64 `json:"steal"`
+	Idle    float64 `json:"idle"`
+	Busy    float64 `json:"busy"`
+}
+
+func utilizationBetween(prev, cur CPUStat) CPUUtilization {
+	var total = float64(cur.Total() - prev.Total())
+	var share = func(p, c uint64) float64 {
+		return float64(c-p) / total
+	}
+	u := CPUUtilization{
+		User:    share(prev.User+prev.Nice, cur.User+cur.Nice),
+		System:  share(prev.System, cur.System),
+		IOWait:  share(prev.IOWait, cur.IOWait),
+		IRQ:     share(prev.IRQ, cur.IRQ),
+		SoftIRQ: share(prev.SoftIRQ, cur.SoftIRQ),
+		Steal:   share(prev.Steal, cur.Steal),
+		Idle:    share(prev.Idle, cur.Idle),
+	}
+	u.Busy = 1 - u.Idle - u.IOWait
+	return u
+}
+
+// meanUtilization averages utilization over several CPUs.
+func meanUtilization(l []CPUUtilization) CPUUtilization {
+	var m CPUUtilization
+	if len(l) == 0 {
+		return CPUUtilization{math.NaN(), math.NaN(), math.NaN(), math.NaN(),
+			math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+	}
+	for _, u := range l {
+		m.User += u.User
+		m.System += u.System
+		m.IOWait += u.IOWait
+		m.IRQ += u.IRQ
+		m.SoftIRQ += u.SoftIRQ
+		m.Steal += u.Steal
+		m.Idle += u.Idle
+		m.Busy += u.Busy
+	}
+	n := float64(len(l))
+	return CPUUtilization{m.User / n, m.System / n, m.IOWait / n, m.IRQ / n,
+		m.SoftIRQ / n, m.Steal / n, m.Idle / n, m.Busy / n}
+}
+
+func (u CPUUtilization) safe() CPUUtilization {
+	for _, v := range []*float64{&u.User, &u.System, &u.IOWait, &u.IRQ,
+		&u.SoftIRQ, &u.Steal, &u.Idle, &u.Busy} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return u
+}
+
+// HostCPU keeps the two most recent samples of /proc/stat, from which
+// utilization of the host and of individual CPUs is computed.
+type HostCPU struct {
+	prevAll CPUStat
+	curAll  CPUStat
+	prev    map[int]CPUStat
+	cur     map[int]CPUStat
+	samples int
+	mtx     sync.RWMutex
+}
+
+// NewHostCPU returns an empty collector, which needs at least two updates
+// before it has anything to say.
+func NewHostCPU() *HostCPU {
+	return &HostCPU{
+		prev: make(map[int]CPUStat),
+		cur:  make(map[int]CPUStat),
+	}
+}
+
+// Update adds a new sample from contents of /proc/stat.
+func (h *HostCPU) Update(data []byte) error {
+	all, perCPU, err := parseProcStat(data)
+	if err != nil {
+		return err
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.prevAll, h.curAll = h.curAll, all
+	h.prev, h.cur = h.cur, perCPU
+	h.samples++
+	return nil
+}
+
+// Collect reads /proc/stat and adds it as a new sample.
+func (h *HostCPU) Collect() error {
+	data, err := ReadFileNoStat(hostFS.ProcPath("stat"))
+	if err != nil {
+		return err
+	}
+	return h.Update(data)
+}
+
+// Host returns utilization of the host as a whole.
+func (h *HostCPU) Host() CPUUtilization {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	if h.samples < 2 {
+		return meanUtilization(nil)
+	}
+	return utilizationBetween(h.prevAll, h.curAll)
+}
+
+// PerCPU returns utilization of each CPU, keyed by CPU number.
+func (h *HostCPU) PerCPU() map[int]CPUUtilization {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	var m = make(map[int]CPUUtilization)
+	if h.samples < 2 {
+		return m
+	}
+	for cpu, cur := range h.cur {
+		if prev, ok := h.prev[cpu]; ok {
+			m[cpu] = utilizationBetween(prev, cur)
+		}
+	}
+	return m
+}
+
+// Of returns mean utilization of the given CPUs.
+func (h *HostCPU) Of(cpus []int) CPUUtilization {
+	var perCPU = h.PerCPU()
+	var l []CPUUtilization
+	for _, cpu := range cpus {
+		if u, ok := perCPU[cpu]; ok {
+			l = append(l, u)
+		}
+	}
+	return meanUtilization(l)
+}
+
+// NumCPUs returns number of CPUs present in the most recent sample.
+func (h *HostCPU) NumCPUs() int {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return len(h.cur)
+}
+
+// ToJSON returns serialized utilization of host and of each of its CPUs.
+func (h *HostCPU) ToJSON() ([]byte, error) {
+	var perCPU = h.PerCPU()
+	var safePerCPU = make(map[string]CPUUtilization, len(perCPU))
+	for cpu, u := range perCPU {
+		safePerCPU[strconv.Itoa(cpu)] = u.safe()
+	}
+	return json.Marshal(struct {
+		NumCPUs int                       `json:"num_cpus"`
+		Host    CPUUtilization            `json:"host"`
+		PerCPU  map[string]CPUUtilization `json:"per_cpu"`
+	}{h.NumCPUs(), h.Host().safe(), safePerCPU})
+}
+
+func (h *HostCPU) String() string {
+	var b strings.Builder
+	u := h.Host()
+	fmt.Fprintf(&b, "bro_host_cpu_busy{cpu=\"all\"} %g\n", u.Busy)
+	fmt.Fprintf(&b, "bro_host_cpu_iowait{cpu=\"all\"} %g\n", u.IOWait)
+	fmt.Fprintf(&b, "bro_host_cpu_steal{cpu=\"all\"} %g\n", u.Steal)
+	for cpu, u := range h.PerCPU() {
+		fmt.Fprintf(&b, "bro_host_cpu_busy{cpu=\"%d\"} %g\n", cpu, u.Busy)
+		fmt.Fprintf(&b, "bro_host_cpu_steal{cpu=\"%d\"} %g\n", cpu, u.Steal)
+	}
+	return b.String()
+}
+
+// startHostCPUCollector samples /proc/stat until context is cancelled.
+func startHostCPUCollector(ctx context.Context, h *HostCPU) {
+	tick := time.NewTicker(HostCPUInterval)
+	defer tick.Stop()
+	for {
+		if err := h.Collect(); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// parseCPUList parses a list of CPUs in the format used by the kernel, for
+// example in Cpus_allowed_list, such as 0-3,8,10-11.
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad cpu list %q: %v", s, err)
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("bad cpu list %q: %v", s, err)
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// statusField returns value of a single field from /proc/<pid>/status.
+func statusField(data []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, name+":") {
+			return strings.TrimSpace(strings.TrimPrefix(line, name+":")), true
+		}
+	}
+	return "", false
+}
+
+// HostCPUContext puts CPU rate of a process in context of the host it runs on.
+// Rates computed from CPUTimes are fractions of a single core, which does not
+// say much without knowing how many cores the process may use, and how busy
+// those cores are overall. High steal on allowed CPUs means we are slowed
+// down by a noisy neighbor rather than our own code.
+type HostCPUContext struct {
+	AllowedCPUs    string         `json:"allowed_cpus"`
+	NumAllowed     int            `json:"num_allowed"`
+	ShareOfAllowed float64        `json:"share_of_allowed"`
+	Allowed        CPUUtilization `json:"allowed_utilization"`
+	Host           CPUUtilization `json:"host_utilization"`
+}
+
+func newHostCPUContext(h *HostCPU, allowedList string, rate float64) *HostCPUContext {
+	cpus, err := parseCPUList(allowedList)
+	if err != nil {
+		handleErr(err, false)
+	}
+	c := &HostCPUContext{
+		AllowedCPUs:    allowedList,
+		NumAllowed:     len(cpus),
+		ShareOfAllowed: rate / float64(len(cpus)),
+		Allowed:        h.Of(cpus),
+		Host:           h.Host(),
+	}
+	return c
+}
+
+func (c HostCPUContext) safe() *HostCPUContext {
+	if math.IsNaN(c.ShareOfAllowed) || math.IsInf(c.ShareOfAllowed, 0) {
+		c.ShareOfAllowed = -1
+	}
+	c.Allowed = c.Allowed.safe()
+	c.Host = c.Host.safe()
+	return &c
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const procStatSample1 = `cpu  1000 0 500 8000 100 0 0 400 0 0
+cpu0 600 0 300 3900 50 0 0 150 0 0
+cpu1 400 0 200 4100 50 0 0 250 0 0
+intr 123456 0 0
+ctxt 987654
+btime 1551398400
+`
+
+const procStatSample2 = `cpu  1200 0 600 8100 100 0 0 600 0 0
+cpu0 790 0 300 3900 50 0 0 160 0 0
+cpu1 410 0 300 4200 50 0 0 440 0 0
+intr 123999 0 0
+ctxt 999999
+btime 1551398400
+`
+
+func Test_parseProcStat(t *testing.T) {
+	all, perCPU, err := parseProcStat([]byte(procStatSample1))
+	if err != nil {
+		t.Fatalf("parseProcStat() error = %v", err)
+	}
+	want := CPUStat{User: 1000, System: 500, Idle: 8000, IOWait: 100, Steal: 400}
+	if all != want {
+		t.Errorf("parseProcStat() = %+v, want %+v", all, want)
+	}
+	if len(perCPU) != 2 || perCPU[1].Steal != 250 {
+		t.Errorf("parseProcStat() per CPU = %+v, want two CPUs, cpu1 with 250 steal ticks", perCPU)
+	}
+	if _, _, err := parseProcStat([]byte("cpu0 a b c d e f g h\n")); err == nil {
+		t.Errorf("parseProcStat() expected error for malformed line")
+	}
+}
+
+func TestHostCPU_Update(t *testing.T) {
+	h := NewHostCPU()
+	if err := h.Update([]byte(procStatSample1)); err != nil {
+		t.Fatalf("HostCPU.Update() error = %v", err)
+	}
+	if got := h.PerCPU(); len(got) != 0 {
+		t.Errorf("HostCPU.PerCPU() = %v after one sample, want nothing", got)
+	}
+	if err := h.Update([]byte(procStatSample2)); err != nil {
+		t.Fatalf("HostCPU.Update() error = %v", err)
+	}
+	perCPU := h.PerCPU()
+	// cpu0 spent all 200 ticks busy, 190 of which in user mode.
+	if got := perCPU[0]; !tolerance(got.Busy, 1, 1e-9) || !tolerance(got.User, 0.95, 1e-9) {
+		t.Errorf("HostCPU.PerCPU()[0] = %+v, want busy 1 and user 0.95", got)
+	}
+	// cpu1 spent 190 of 400 ticks stolen by the hypervisor.
+	if got := perCPU[1]; !tolerance(got.Steal, 0.475, 1e-9) || !tolerance(got.Idle, 0.25, 1e-9) {
+		t.Errorf("HostCPU.PerCPU()[1] = %+v, want steal 0.475 and idle 0.25", got)
+	}
+	if got := h.Host(); !tolerance(got.Steal, 1.0/3, 1e-9) {
+		t.Errorf("HostCPU.Host() = %+v, want steal 1/3", got)
+	}
+	if got := h.Of([]int{0, 1}); !tolerance(got.Busy, 0.875, 1e-9) {
+		t.Errorf("HostCPU.Of() = %+v, want busy 0.875", got)
+	}
+}
+
+func Test_parseCPUList(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []int
+		wantErr bool
+	}{
+		{name: "ranges and singles", s: "0-3,8,10-11\n", want: []int{0, 1, 2, 3, 8, 10, 11}},
+		{name: "single cpu", s: "5", want: []int{5}},
+		{name: "empty", s: "", want: nil},
+		{name: "garbage", s: "0-x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCPUList(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCPUList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCPUList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_statusField(t *testing.T) {
+	data := []byte("Name:\tzeek\nCpus_allowed:\tf\nCpus_allowed_list:\t0-3\nMems_allowed_list:\t0\n")
+	if got, ok := statusField(data, "Cpus_allowed_list"); !ok || got != "0-3" {
+		t.Errorf("statusField() = %q, %v, want \"0-3\", true", got, ok)
+	}
+	if _, ok := statusField(data, "Cpus_allowed_lis"); ok {
+		t.Errorf("statusField() must not match on a prefix of field name")
+	}
+}
+
+func Test_newHostCPUContext(t *testing.T) {
+	h := NewHostCPU()
+	h.Update([]byte(procStatSample1))
+	h.Update([]byte(procStatSample2))
+	c := newHostCPUContext(h, "0-1", 1.5)
+	if c.NumAllowed != 2 || c.ShareOfAllowed != 0.75 {
+		t.Errorf("newHostCPUContext() = %+v, want 2 allowed CPUs and share 0.75", c)
+	}
+	if !tolerance(c.Allowed.Steal, (0.05+0.475)/2, 1e-9) {
+		t.Errorf("newHostCPUContext().Allowed.Steal = %v, want %v", c.Allowed.Steal, (0.05+0.475)/2)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Default mount points of procfs, sysfs and root filesystem of the host we
+// monitor, which is where they are unless we run in a container.
+const (
+	defaultProcfsRoot = "/proc"
+	defaultSysfsRoot  = "/sys"
+	defaultHostRoot   = "/"
+)
+
+// HostFS is where we reach procfs, sysfs and root filesystem of the host. When
+// we run in a sidecar container, these are mounted from the host, such as at
+// /host/proc, /host/sys and /host, and every path we read has to go through
+// them.
+type HostFS struct {
+	Proc string
+	Sys  string
+	Root string
+}
+
+// hostFS is used for all access to procfs and sysfs, its roots are given on
+// command line.
+var hostFS = HostFS{Proc: defaultProcfsRoot, Sys: defaultSysfsRoot, Root: defaultHostRoot}
+
+// ProcPath returns path of a file under procfs, such as ProcPath("stat").
+func (fs HostFS) ProcPath(elem ...string) string {
+	return filepath.Join(append([]string{fs.Proc}, elem...)...)
+}
+
+// PIDPath returns path of a file of process with given PID under procfs.
+func (fs HostFS) PIDPath(pid int, name string) string {
+	return fs.ProcPath(strconv.Itoa(pid), name)
+}
+
+// SysPath returns path of a file under sysfs, such as SysPath("class/net").
+func (fs HostFS) SysPath(elem ...string) string {
+	return filepath.Join(append([]string{fs.Sys}, elem...)...)
+}
+
+// HostPath returns where a path on the host is visible to us.
+func (fs HostFS) HostPath(path string) string {
+	return filepath.Join(fs.Root, path)
+}
+
+// hostView returns path as the host sees it, given a path as we see it, which
+// is only possible for paths under host root.
+func (fs HostFS) hostView(path string) (string, bool) {
+	root := filepath.Clean(fs.Root)
+	if root == "/" {
+		return path, true
+	}
+	if rel := strings.TrimPrefix(path, root+"/"); rel != path {
+		return "/" + rel, true
+	}
+	return "", false
+}
+
+// exeMatcher tells whether a process is running a given executable. Link to
+// executable of a process reads as a path in the process' own mount
+// namespace, which for processes on the host is a path on the host, while the
+// executable we are given may be a path as we see it, under host root. When
+// neither matches, because the process runs in yet another mount namespace,
+// we compare the files themselves.
+type exeMatcher struct {
+	paths []string
+	base  string
+	info  os.FileInfo
+}
+
+func newExeMatcher(fs HostFS, target string) *exeMatcher {
+	m := &exeMatcher{paths: []string{target}, base: filepath.Base(target)}
+	if host, ok := fs.hostView(target); ok && host != target {
+		m.paths = append(m.paths, host)
+	}
+	var err error
+	if m.info, err = os.Stat(target); err != nil {
+		m.info, _ = os.Stat(fs.HostPath(target))
+	}
+	return m
+}
+
+// Matches returns true if process with given PID runs the executable.
+func (m *exeMatcher) Matches(fs HostFS, pid int) bool {
+	lnk := fs.PIDPath(pid, "exe")
+	exe, err := os.Readlink(lnk)
+	if err != nil {
+		return false
+	}
+	for _, p := range m.paths {
+		if exe == p {
+			return true
+		}
+	}
+	// Stat of every process on the host would be too much, so only those
+	// with the same name get this far.
+	if m.info == nil || filepath.Base(exe) != m.base {
+		return false
+	}
+	fileInfo, err := os.Stat(lnk)
+	return err == nil && os.SameFile(fileInfo, m.info)
+}
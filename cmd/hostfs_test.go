@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostFS_paths(t *testing.T) {
+	fs := HostFS{Proc: "/host/proc", Sys: "/host/sys", Root: "/host"}
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"procfs file", fs.ProcPath("stat"), "/host/proc/stat"},
+		{"process file", fs.PIDPath(42, "cmdline"), "/host/proc/42/cmdline"},
+		{"sysfs file", fs.SysPath("class", "net"), "/host/sys/class/net"},
+		{"host file", fs.HostPath("/opt/zeek/bin/zeek"), "/host/opt/zeek/bin/zeek"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("HostFS = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+	if got, ok := fs.hostView("/host/opt/zeek/bin/zeek"); !ok || got != "/opt/zeek/bin/zeek" {
+		t.Errorf("HostFS.hostView() = %v, %v, want path on the host", got, ok)
+	}
+	if _, ok := fs.hostView("/hostile/zeek"); ok {
+		t.Errorf("HostFS.hostView() translated a path outside host root")
+	}
+}
+
+func TestExeMatcher_Matches(t *testing.T) {
+	dir := t.TempDir()
+	fs := HostFS{Proc: filepath.Join(dir, "proc"), Sys: filepath.Join(dir, "sys"), Root: filepath.Join(dir, "host")}
+	writeFixture(t, dir, map[string]string{
+		"host/opt/zeek/bin/zeek": "zeek",
+		"host/usr/bin/zeek":      "another zeek",
+	})
+	// Zeek of another container, which is the same file under another path.
+	if err := os.MkdirAll(filepath.Join(dir, "container/bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "host/opt/zeek/bin/zeek"), filepath.Join(dir, "container/bin/zeek")); err != nil {
+		t.Fatal(err)
+	}
+	for pid, exe := range map[int]string{
+		100: "/opt/zeek/bin/zeek",
+		101: filepath.Join(dir, "container/bin/zeek"),
+		102: filepath.Join(dir, "host/usr/bin/zeek"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(fs.PIDPath(pid, "exe")), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(exe, fs.PIDPath(pid, "exe")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		target string
+		pid    int
+		want   bool
+	}{
+		{"host path on the host", "/opt/zeek/bin/zeek", 100, true},
+		{"our path on the host", filepath.Join(dir, "host/opt/zeek/bin/zeek"), 100, true},
+		{"host path in a container", "/opt/zeek/bin/zeek", 101, true},
+		{"our path in a container", filepath.Join(dir, "host/opt/zeek/bin/zeek"), 101, true},
+		{"another executable", "/opt/zeek/bin/zeek", 102, false},
+		{"no such process", "/opt/zeek/bin/zeek", 103, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newExeMatcher(fs, tt.target).Matches(fs, tt.pid); got != tt.want {
+				t.Errorf("exeMatcher.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -18,7 +19,139 @@ func prometheusMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	for _, l := range data {
 		fmt.Fprint(w, l)
 	}
-	
+	for _, rs := range metricsReport.Roles() {
+		fmt.Fprint(w, rs)
+	}
+	for _, gs := range metricsReport.Groups() {
+		fmt.Fprint(w, gs)
+	}
+	if hostCPU != nil {
+		fmt.Fprint(w, hostCPU)
+	}
+	if systemPressure != nil {
+		fmt.Fprint(w, systemPressure)
+	}
+	if netIfs != nil {
+		fmt.Fprint(w, netIfs)
+	}
+	if zeekReporter != nil {
+		fmt.Fprint(w, zeekReporter)
+	}
+	if telemetryScraper != nil {
+		fmt.Fprint(w, telemetryScraper)
+	}
+	if suricata != nil {
+		fmt.Fprint(w, suricata)
+	}
+	for _, sc := range metricsReport.SharedCores() {
+		fmt.Fprintf(w, "bro_shared_core_instances{cpu=\"%d\"} %d\n", sc.CPU, len(sc.Instances))
+	}
+}
+
+// affinityInfoHandler serves /info/affinity with CPUs which are unexpectedly
+// shared between monitored instances.
+func affinityInfoHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := metricsReport.SharedCoresToJSON()
+	if err != nil {
+		handleErr(err, false)
+		http.Error(w,
+			http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+		)
+		return
+	}
+	fmt.Fprint(w, string(data))
+}
+
+// pressureInfoHandler serves /info/pressure with system-wide pressure stall
+// information.
+func pressureInfoHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := systemPressure.ToJSON()
+	if err != nil {
+		handleErr(err, false)
+		http.Error(w,
+			http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+		)
+		return
+	}
+	fmt.Fprint(w, string(data))
+}
+
+// interfacesInfoHandler serves /info/interfaces with packet and drop rates of
+// capture interfaces next to CPU use of the workers reading from them.
+func interfacesInfoHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := metricsReport.InterfacesToJSON(netIfs, capture)
+	if err != nil {
+		handleErr(err, false)
+		http.Error(w,
+			http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+		)
+		return
+	}
+	fmt.Fprint(w, string(data))
+}
+
+// suricataInfoHandler serves /info/suricata/threads with counters of each
+// Suricata thread, as well as their totals.
+func suricataInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if suricata == nil {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := suricata.ToJSON()
+	if err != nil {
+		handleErr(err, false)
+		if err == errNoInfoForRole {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w,
+				http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+			)
+		}
+		return
+	}
+	fmt.Fprint(w, string(data))
+}
+
+// hostInfoHandler serves /info/host with utilization of the host's CPUs.
+func hostInfoHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := hostCPU.ToJSON()
+	if err != nil {
+		handleErr(err, false)
+		http.Error(w,
+			http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+		)
+		return
+	}
+	fmt.Fprint(w, string(data))
+}
+
+// groupsInfoHandler serves /info/groups, which lists aggregates of all
+// configured role groups, and /info/groups/<name> for a single group.
+func groupsInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	var err error
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/info/groups"), "/")
+	if name == "" {
+		data, err = metricsReport.GroupsToJSON()
+	} else {
+		data, err = metricsReport.GroupToJSON(name)
+	}
+	if err != nil {
+		handleErr(
+			fmt.Errorf(
+				"failed getting metrics for group %s with: %s", name, err),
+			false,
+		)
+		if err == errNoSuchGroup {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w,
+				http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+			)
+		}
+		return
+	}
+	fmt.Fprint(w, string(data))
 }
 
 func allInfoHandler(w http.ResponseWriter, r *http.Request) {
@@ -33,16 +166,43 @@ func allInfoHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(data))
 }
 
+// roleInfoHandler serves /info/<role>, which is an aggregate of all instances
+// of the role, /info/<role>/<pid>, which is a single instance, and
+// /info/<role>/reporter, which is what the role wrote to Zeek's reporter.log.
+// Roles of Suricata threads, which are not processes, are served with their
+// counters.
 func roleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	var err error
 	role := strings.TrimPrefix(r.URL.Path, "/info/")
-	data, err := metricsReport.RoleToJSON(role)
+	if strings.HasSuffix(role, "/reporter") {
+		role = strings.TrimSuffix(role, "/reporter")
+		if zeekReporter == nil {
+			err = errNoZeekLogs
+		} else {
+			data, err = zeekReporter.ToJSON(role)
+		}
+	} else if parts := strings.SplitN(role, "/", 2); len(parts) == 2 {
+		var pid int
+		role = parts[0]
+		if pid, err = strconv.Atoi(parts[1]); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data, err = metricsReport.InstanceToJSON(role, pid)
+	} else {
+		data, err = metricsReport.RoleToJSON(role)
+		if err == errNoInfoForRole && suricata != nil {
+			data, err = suricata.ThreadToJSON(role)
+		}
+	}
 	if err != nil {
 		handleErr(
 			fmt.Errorf(
 				"failed getting metrics for %s with: %s", role, err),
 			false,
 		)
-		if err == errNoInfoForRole {
+		if err == errNoInfoForRole || err == errNoZeekLogs {
 			http.NotFound(w, r)
 		} else {
 			http.Error(w,
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImbalanceScore describes how unevenly CPU load is spread across members of a
+// role group. When RSS hashing on the capture NIC misbehaves, one worker ends
+// up pegged while others sit idle, and all three figures go up together.
+// CV - coefficient of variation, stddev relative to mean.
+// MaxMeanRatio - busiest member relative to mean, 1 means perfectly even.
+// Gini - Gini coefficient, 0 means perfectly even.
+type ImbalanceScore struct {
+	CV           float64 `json:"coefficient_of_variation"`
+	MaxMeanRatio float64 `json:"max_mean_ratio"`
+	Gini         float64 `json:"gini"`
+}
+
+func newImbalanceScore(rates []float64) ImbalanceScore {
+	return ImbalanceScore{
+		CV:           coefficientOfVariation(rates),
+		MaxMeanRatio: maxMeanRatio(rates),
+		Gini:         gini(rates),
+	}
+}
+
+func (sc ImbalanceScore) safe() ImbalanceScore {
+	for _, v := range []*float64{&sc.CV, &sc.MaxMeanRatio, &sc.Gini} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return sc
+}
+
+// ImbalanceState is the imbalance of a group right now, along with average
+// imbalance over the tracking window. A group is in Sustained state once its
+// coefficient of variation has stayed above threshold for an entire window,
+// which keeps short bursts on a single worker from being flagged.
+type ImbalanceState struct {
+	Current     ImbalanceScore `json:"current"`
+	Window      time.Duration  `json:"window"`
+	WindowMean  ImbalanceScore `json:"window_mean"`
+	Threshold   float64        `json:"threshold"`
+	Sustained   bool           `json:"sustained"`
+	SkewedSince *time.Time     `json:"skewed_since,omitempty"`
+}
+
+func (st ImbalanceState) safe() *ImbalanceState {
+	st.Current = st.Current.safe()
+	st.WindowMean = st.WindowMean.safe()
+	return &st
+}
+
+func (st ImbalanceState) String(group string) string {
+	var b strings.Builder
+	var sustained int
+	if st.Sustained {
+		sustained = 1
+	}
+	fmt.Fprintf(&b, "bro_group_imbalance_cv{group=\"%s\"} %g\n", group, st.Current.CV)
+	fmt.Fprintf(&b, "bro_group_imbalance_max_mean_ratio{group=\"%s\"} %g\n", group, st.Current.MaxMeanRatio)
+	fmt.Fprintf(&b, "bro_group_imbalance_gini{group=\"%s\"} %g\n", group, st.Current.Gini)
+	fmt.Fprintf(&b, "bro_group_imbalance_window_cv{group=\"%s\"} %g\n", group, st.WindowMean.CV)
+	fmt.Fprintf(&b, "bro_group_imbalance_sustained{group=\"%s\"} %d\n", group, sustained)
+	return b.String()
+}
+
+type timedScore struct {
+	ts    time.Time
+	score ImbalanceScore
+}
+
+// ImbalanceTracker keeps a history of imbalance scores for each role group,
+// going back as far as the window. Scores are observed on every reporting
+// interval, see startIntervalReport.
+type ImbalanceTracker struct {
+	window      time.Duration
+	threshold   float64
+	history     map[string][]timedScore
+	skewedSince map[string]time.Time
+	mtx         sync.Mutex
+}
+
+// NewImbalanceTracker returns a tracker which flags a group once its
+// coefficient of variation stays above threshold for at least window.
+func NewImbalanceTracker(window time.Duration, threshold float64) *ImbalanceTracker {
+	return &ImbalanceTracker{
+		window:      window,
+		threshold:   threshold,
+		history:     make(map[string][]timedScore),
+		skewedSince: make(map[string]time.Time),
+	}
+}
+
+// Observe records score of a group at time now, dropping anything older than
+// the window.
+func (t *ImbalanceTracker) Observe(group string, score ImbalanceScore, now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var h = append(t.history[group], timedScore{ts: now, score: score})
+	var cutoff = now.Add(-t.window)
+	var i int
+	for i < len(h) && h[i].ts.Before(cutoff) {
+		i++
+	}
+	t.history[group] = h[i:]
+
+	// A NaN score, which we get with fewer than two members, neither starts
+	// nor ends a skewed period.
+	if math.IsNaN(score.CV) {
+		return
+	}
+	if score.CV > t.threshold {
+		if _, ok := t.skewedSince[group]; !ok {
+			t.skewedSince[group] = now
+		}
+	} else {
+		delete(t.skewedSince, group)
+	}
+}
+
+// State returns imbalance state of a group as of time now, with current being
+// the most recent score.
+func (t *ImbalanceTracker) State(group string, current ImbalanceScore, now time.Time) *ImbalanceState {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var cvs, ratios, ginis []float64
+	for _, ts := range t.history[group] {
+		cvs = append(cvs, ts.score.CV)
+		ratios = append(ratios, ts.score.MaxMeanRatio)
+		ginis = append(ginis, ts.score.Gini)
+	}
+	st := &ImbalanceState{
+		Current: current,
+		Window:  t.window,
+		WindowMean: ImbalanceScore{
+			CV:           avg(cvs),
+			MaxMeanRatio: avg(ratios),
+			Gini:         avg(ginis),
+		},
+		Threshold: t.threshold,
+	}
+	if since, ok := t.skewedSince[group]; ok {
+		st.SkewedSince = &since
+		st.Sustained = now.Sub(since) >= t.window
+	}
+	return st
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImbalanceTracker_State(t *testing.T) {
+	var start = time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	var skewed = ImbalanceScore{CV: 1.2, MaxMeanRatio: 3, Gini: 0.6}
+	var even = ImbalanceScore{CV: 0.1, MaxMeanRatio: 1.1, Gini: 0.05}
+	tests := []struct {
+		name          string
+		scores        []ImbalanceScore
+		wantSustained bool
+		wantSkewed    bool
+		wantWindowCV  float64
+	}{
+		{name: "skewed for less than a window",
+			scores:        []ImbalanceScore{even, skewed, skewed},
+			wantSustained: false, wantSkewed: true, wantWindowCV: 2.5 / 3,
+		},
+		{name: "skewed for an entire window",
+			scores:        []ImbalanceScore{skewed, skewed, skewed, skewed, skewed, skewed, skewed},
+			wantSustained: true, wantSkewed: true, wantWindowCV: 1.2,
+		},
+		{name: "skew interrupted by even load",
+			scores:        []ImbalanceScore{skewed, skewed, skewed, skewed, skewed, even, skewed},
+			wantSustained: false, wantSkewed: true, wantWindowCV: (1.2*4 + 0.1) / 5,
+		},
+		{name: "even load",
+			scores:        []ImbalanceScore{even, even},
+			wantSustained: false, wantSkewed: false, wantWindowCV: 0.1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One observation a minute, with a window of four minutes.
+			tr := NewImbalanceTracker(4*time.Minute, 0.5)
+			var now time.Time
+			for i, sc := range tt.scores {
+				now = start.Add(time.Duration(i) * time.Minute)
+				tr.Observe("workers", sc, now)
+			}
+			st := tr.State("workers", tt.scores[len(tt.scores)-1], now)
+			if st.Sustained != tt.wantSustained {
+				t.Errorf("ImbalanceTracker.State().Sustained = %v, want %v", st.Sustained, tt.wantSustained)
+			}
+			if (st.SkewedSince != nil) != tt.wantSkewed {
+				t.Errorf("ImbalanceTracker.State().SkewedSince = %v, want skewed %v", st.SkewedSince, tt.wantSkewed)
+			}
+			if !tolerance(st.WindowMean.CV, tt.wantWindowCV, 0.000000001) {
+				t.Errorf("ImbalanceTracker.State().WindowMean.CV = %v, want %v", st.WindowMean.CV, tt.wantWindowCV)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 )
 
@@ -29,20 +30,66 @@ func main() {
 		}
 	}()
 
+	if err := checkCgroupRoot(cgroupRoot); err != nil {
+		log.Printf("Cgroup stats are not available: %v", err)
+		cgroupRoot = ""
+	}
 	if !NewSummariesSingleton() {
 		panic("failed to initialize Summaries structure")
 	}
+	imbalance = NewImbalanceTracker(imbalanceWindow, imbalanceThreshold)
+	quantiles = NewQuantileTracker(quantileWindows)
+	anomalies = NewAnomalyTracker(anomalyWindow, anomalyDays, anomalyThreshold)
+	memoryTrends = NewMemoryTrendTracker(trendHorizons)
+	hostCPU = NewHostCPU()
+	go startHostCPUCollector(ctx, hostCPU)
+	systemPressure = NewSystemPressure(hostFS.ProcPath("pressure"))
+	go startPressureCollector(ctx, systemPressure)
+	netIfs = NewInterfaceCollector(hostFS.SysPath("class", "net"))
+	go startInterfaceCollector(ctx, netIfs, capture)
+	if zeekLogDir != "" {
+		zeekLogs = NewZeekLogs(zeekLogDir)
+		go startZeekLogCollector(ctx, zeekLogs)
+		zeekReporter = NewZeekReporter(filepath.Join(zeekLogDir, "reporter.log"), reporterKeep)
+		go startZeekReporterCollector(ctx, zeekReporter)
+	}
+	telemetryScraper = NewTelemetryScraper(telemetry, TelemetryTimeout)
+	go startTelemetryScraper(ctx, telemetryScraper)
+	if suricataExe != "" {
+		suricata = NewSuricata(suricataSocket)
+		go startSuricataCollector(ctx, suricata)
+	}
+	if useTaskstats {
+		var err error
+		if taskstats, err = NewTaskstatsClient(); err != nil {
+			log.Printf("Delay accounting is not available: %v", err)
+			taskstats = nil
+		} else {
+			defer taskstats.Close()
+		}
+	}
 	intervalReportChan := make(chan *IntervalReport)
 	go startMonitors(
 		ctx,
 		intervalReportChan,
 		func() []*ProcInfo {
-			return findProcsByName(exeLocation)
+			procs := findProcsByName(exeLocation)
+			if suricataExe != "" {
+				procs = append(procs, findSuricataProcs(suricataExe)...)
+			}
+			return procs
 		})
 	go startIntervalReport(intervalReportChan)
 
 	http.HandleFunc("/info", allInfoHandler)
 	http.HandleFunc("/info/", roleInfoHandler) // children of /info route
+	http.HandleFunc("/info/groups", groupsInfoHandler)
+	http.HandleFunc("/info/groups/", groupsInfoHandler)
+	http.HandleFunc("/info/host", hostInfoHandler)
+	http.HandleFunc("/info/affinity", affinityInfoHandler)
+	http.HandleFunc("/info/pressure", pressureInfoHandler)
+	http.HandleFunc("/info/interfaces", interfacesInfoHandler)
+	http.HandleFunc("/info/suricata/threads", suricataInfoHandler)
 	http.HandleFunc("/metrics", prometheusMetricsHandler) // prometheus output
 
 	log.Printf("Server starting on %s:%d\n", hostname, port)
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PSSInterval is how often proportional set size of a process is read. The
+// kernel walks every mapping of the process to produce smaps_rollup, which is
+// too costly to do every second for a process with gigabytes of state.
+const PSSInterval = time.Minute
+
+// trendPoints is how many points a trend over any horizon is fitted to. Points
+// are taken horizon/trendPoints apart, which keeps cost of the fit the same
+// whether the horizon is hours or days.
+const trendPoints = 120
+
+// minTrendPoints is how many points a trend needs before it is fitted.
+const minTrendPoints = 10
+
+// Names of memory series we fit trends to.
+const (
+	trendRSSBytes = "rss_bytes"
+	trendPSSBytes = "pss_bytes"
+)
+
+// parsePSS returns proportional set size in bytes from contents of
+// /proc/<pid>/smaps_rollup.
+func parsePSS(data []byte) (int, error) {
+	v, ok := statusField(data, "Pss")
+	if !ok {
+		return -1, fmt.Errorf("no Pss in smaps_rollup")
+	}
+	kb, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(v, "kB")))
+	if err != nil {
+		return -1, fmt.Errorf("bad Pss in smaps_rollup: %v", err)
+	}
+	return kb * 1024, nil
+}
+
+// theilSen returns median of slopes between every pair of points, in units per
+// second, along with value of the fitted line at time at. Unlike least
+// squares, a few outliers, say a burst of allocations which is freed again,
+// do not tilt the line.
+func theilSen(points []timedSample, at time.Time) (slope, fitted float64) {
+	if len(points) < 2 {
+		return math.NaN(), math.NaN()
+	}
+	var slopes = make([]float64, 0, len(points)*(len(points)-1)/2)
+	for i := range points {
+		for j := i + 1; j < len(points); j++ {
+			dt := points[j].at.Sub(points[i].at).Seconds()
+			if dt > 0 {
+				slopes = append(slopes, (points[j].v-points[i].v)/dt)
+			}
+		}
+	}
+	slope = median(slopes)
+	var intercepts = make([]float64, len(points))
+	for i, p := range points {
+		intercepts[i] = p.v - slope*p.at.Sub(at).Seconds()
+	}
+	return slope, median(intercepts)
+}
+
+// MemoryTrend is growth of a memory series fitted over a horizon. TimeToLimit
+// is seconds until the fitted line reaches the limit, -1 when there is no
+// limit or memory is not growing. Until there are minTrendPoints points no
+// line is fitted, fitted figures are NaN in Prometheus format and left out of
+// JSON, since growth is signed and -1 would be a real slope.
+type MemoryTrend struct {
+	Horizon      time.Duration `json:"horizon"`
+	Points       int           `json:"points"`
+	BytesPerHour float64       `json:"bytes_per_hour"`
+	Fitted       float64       `json:"fitted_bytes"`
+	Limit        int64         `json:"limit_bytes"`
+	TimeToLimit  float64       `json:"seconds_to_limit"`
+}
+
+// fitted tells whether there were enough points to fit a line.
+func (mt MemoryTrend) fitted() bool {
+	return mt.Points >= minTrendPoints && !math.IsNaN(mt.BytesPerHour)
+}
+
+// MarshalJSON leaves out fitted figures of a trend which is not fitted yet.
+func (mt MemoryTrend) MarshalJSON() ([]byte, error) {
+	type fit struct {
+		BytesPerHour float64 `json:"bytes_per_hour"`
+		Fitted       float64 `json:"fitted_bytes"`
+		TimeToLimit  float64 `json:"seconds_to_limit"`
+	}
+	var f *fit
+	if mt.fitted() {
+		f = &fit{mt.BytesPerHour, mt.Fitted, mt.TimeToLimit}
+	}
+	return json.Marshal(struct {
+		Horizon time.Duration `json:"horizon"`
+		Points  int           `json:"points"`
+		Limit   int64         `json:"limit_bytes"`
+		*fit
+	}{mt.Horizon, mt.Points, mt.Limit, f})
+}
+
+// applyLimit projects when the trend reaches limit, given how many bytes are
+// left before it does.
+func (mt *MemoryTrend) applyLimit(limit int64, headroom float64) {
+	mt.Limit = limit
+	switch {
+	case math.IsNaN(mt.BytesPerHour):
+		mt.TimeToLimit = math.NaN()
+	case limit < 0 || mt.BytesPerHour <= 0:
+		mt.TimeToLimit = -1
+	case headroom <= 0:
+		mt.TimeToLimit = 0
+	default:
+		mt.TimeToLimit = headroom / mt.BytesPerHour * 3600
+	}
+}
+
+// String returns trend in Prometheus format with given labels.
+func (mt MemoryTrend) String(labels string) string {
+	var b strings.Builder
+	labels += fmt.Sprintf(",horizon=\"%s\"", mt.Horizon)
+	fmt.Fprintf(&b, "bro_role_memory_growth_bytes_per_hour{%s} %g\n", labels, mt.BytesPerHour)
+	fmt.Fprintf(&b, "bro_role_memory_seconds_to_limit{%s} %g\n", labels, mt.TimeToLimit)
+	return b.String()
+}
+
+// trendSeries keeps points of a series going back as far as the horizon, taken
+// no closer than step apart.
+type trendSeries struct {
+	horizon time.Duration
+	step    time.Duration
+	points  []timedSample
+}
+
+func newTrendSeries(horizon time.Duration) *trendSeries {
+	return &trendSeries{horizon: horizon, step: horizon / trendPoints}
+}
+
+func (ts *trendSeries) Insert(v float64, now time.Time) {
+	if n := len(ts.points); n > 0 && now.Sub(ts.points[n-1].at) < ts.step {
+		return
+	}
+	ts.points = append(ts.points, timedSample{at: now, v: v})
+	var cutoff = now.Add(-ts.horizon)
+	var i int
+	for i < len(ts.points) && ts.points[i].at.Before(cutoff) {
+		i++
+	}
+	ts.points = ts.points[i:]
+}
+
+// Trend fits a line to points as of now, leaving limit to be applied.
+func (ts *trendSeries) Trend(now time.Time) MemoryTrend {
+	mt := MemoryTrend{
+		Horizon:      ts.horizon,
+		Points:       len(ts.points),
+		BytesPerHour: math.NaN(),
+		Fitted:       math.NaN(),
+		Limit:        -1,
+		TimeToLimit:  math.NaN(),
+	}
+	if len(ts.points) >= minTrendPoints {
+		slope, fitted := theilSen(ts.points, now)
+		mt.BytesPerHour, mt.Fitted = slope*3600, fitted
+	}
+	return mt
+}
+
+// MemoryTrendTracker fits trends to RSS and PSS of every role over each of the
+// horizons. Roles are observed on every reporting interval, see
+// startIntervalReport.
+type MemoryTrendTracker struct {
+	horizons durations
+	series   map[string]map[string][]*trendSeries
+	mtx      sync.Mutex
+}
+
+// NewMemoryTrendTracker returns a tracker fitting trends over horizons.
+func NewMemoryTrendTracker(horizons durations) *MemoryTrendTracker {
+	return &MemoryTrendTracker{
+		horizons: horizons,
+		series:   make(map[string]map[string][]*trendSeries),
+	}
+}
+
+// Observe adds value of a series of role observed at time now.
+func (t *MemoryTrendTracker) Observe(role, series string, v float64, now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bySeries, ok := t.series[role]
+	if !ok {
+		bySeries = make(map[string][]*trendSeries)
+		t.series[role] = bySeries
+	}
+	l, ok := bySeries[series]
+	if !ok {
+		for _, h := range t.horizons {
+			l = append(l, newTrendSeries(h))
+		}
+		bySeries[series] = l
+	}
+	for _, ts := range l {
+		ts.Insert(v, now)
+	}
+}
+
+// Trends returns trends of every series of a role over each horizon as of now,
+// nil if nothing was observed for the role. Limit is not applied yet.
+func (t *MemoryTrendTracker) Trends(role string, now time.Time) map[string][]MemoryTrend {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bySeries, ok := t.series[role]
+	if !ok {
+		return nil
+	}
+	var m = make(map[string][]MemoryTrend, len(bySeries))
+	for series, l := range bySeries {
+		for _, ts := range l {
+			m[series] = append(m[series], ts.Trend(now))
+		}
+	}
+	return m
+}
+
+// memoryLimit returns memory limit of a role along with how many bytes it has
+// left before reaching it, given fitted use of the role. A configured ceiling
+// applies to the role itself, while memory.max applies to everything in the
+// cgroup, so headroom is whatever the cgroup has left. With instances in
+// several cgroups, the one with least headroom counts. Limit is -1 if there
+// is none.
+func memoryLimit(reps []*IntervalReport, ceiling int64, fitted float64) (int64, float64) {
+	if ceiling > 0 {
+		return ceiling, float64(ceiling) - fitted
+	}
+	var limit int64 = -1
+	var headroom = math.Inf(1)
+	for _, rep := range reps {
+		if rep.Cgroup == nil || rep.Cgroup.MemoryMax < 0 {
+			continue
+		}
+		if left := float64(rep.Cgroup.MemoryMax) - float64(rep.Cgroup.MemoryCurrent); left < headroom {
+			limit, headroom = rep.Cgroup.MemoryMax, left
+		}
+	}
+	return limit, headroom
+}
+
+// applyMemoryLimits projects time to limit of every trend.
+func applyMemoryLimits(m map[string][]MemoryTrend, reps []*IntervalReport, ceiling int64) map[string][]MemoryTrend {
+	if m == nil {
+		return nil
+	}
+	for _, l := range m {
+		for i := range l {
+			limit, headroom := memoryLimit(reps, ceiling, l[i].Fitted)
+			l[i].applyLimit(limit, headroom)
+		}
+	}
+	return m
+}
+
+// memoryTrendsString returns trends of a role in Prometheus format.
+func memoryTrendsString(role string, m map[string][]MemoryTrend) string {
+	var b strings.Builder
+	var names []string
+	for series := range m {
+		names = append(names, series)
+	}
+	sort.Strings(names)
+	for _, series := range names {
+		for _, mt := range m[series] {
+			b.WriteString(mt.String(fmt.Sprintf("role=\"%s\",series=\"%s\"", role, series)))
+		}
+	}
+	return b.String()
+}
+
+// ObserveMemoryTrends adds current RSS and PSS of every role to the memory
+// trend tracker. Memory of a role is the sum over its instances, the same as
+// in RoleSummary. PSS is only added once every instance has it.
+func (s *Summaries) ObserveMemoryTrends(now time.Time) {
+	if memoryTrends == nil {
+		return
+	}
+	var rss, pss = make(map[string]int), make(map[string]int)
+	var noPSS = make(map[string]bool)
+	s.mtx.RLock()
+	for _, rep := range s.m {
+		rss[rep.Role] += rep.RSSBytes
+		pss[rep.Role] += rep.PSSBytes
+		if rep.PSSBytes < 0 {
+			noPSS[rep.Role] = true
+		}
+	}
+	s.mtx.RUnlock()
+	for role, v := range rss {
+		memoryTrends.Observe(role, trendRSSBytes, float64(v), now)
+		if !noPSS[role] {
+			memoryTrends.Observe(role, trendPSSBytes, float64(pss[role]), now)
+		}
+	}
+}
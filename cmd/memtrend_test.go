@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parsePSS(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "smaps_rollup",
+			data: "55d4c2a00000-7ffd3a5fe000 ---p 00000000 00:00 0                          [rollup]\n" +
+				"Rss:              884960 kB\nPss:              801234 kB\nPss_Anon:         790000 kB\n",
+			want: 801234 * 1024,
+		},
+		{name: "no Pss", data: "Rss: 884960 kB\n", want: -1, wantErr: true},
+		{name: "bad Pss", data: "Pss: lots kB\n", want: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePSS([]byte(tt.data))
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parsePSS() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_theilSen(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	// A megabyte an hour, with a few spikes of freed-again allocations which
+	// would tilt a least squares fit.
+	var points []timedSample
+	for i := 0; i < 24; i++ {
+		v := float64(100<<20 + i<<20)
+		if i%7 == 3 {
+			v += 500 << 20
+		}
+		points = append(points, timedSample{at: start.Add(time.Duration(i) * time.Hour), v: v})
+	}
+	end := start.Add(23 * time.Hour)
+	slope, fitted := theilSen(points, end)
+	if !tolerance(slope*3600, 1<<20, 1e-6) || !tolerance(fitted, 123<<20, 1e-3) {
+		t.Errorf("theilSen() = %v bytes/hour, %v fitted, want %v and %v", slope*3600, fitted, 1<<20, 123<<20)
+	}
+	if slope, _ := theilSen(points[:1], end); !math.IsNaN(slope) {
+		t.Errorf("theilSen() = %v for a single point, want NaN", slope)
+	}
+}
+
+func TestMemoryTrend_applyLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		growth   float64
+		limit    int64
+		headroom float64
+		want     float64
+	}{
+		{"no limit", 1 << 20, -1, math.Inf(1), -1},
+		{"shrinking", -1 << 20, 1 << 30, 1 << 29, -1},
+		{"ten hours left", 1 << 20, 1 << 30, 10 << 20, 36000},
+		{"over limit", 1 << 20, 1 << 30, -1, 0},
+		{"not fitted yet", math.NaN(), 1 << 30, 1 << 29, math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := MemoryTrend{BytesPerHour: tt.growth}
+			mt.applyLimit(tt.limit, tt.headroom)
+			if !tolerance(mt.TimeToLimit, tt.want, 1e-9) || mt.Limit != tt.limit {
+				t.Errorf("MemoryTrend.applyLimit() = %+v, want %v seconds", mt, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryTrend_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		mt   MemoryTrend
+		want string
+	}{
+		{"not fitted", MemoryTrend{Horizon: time.Hour, Points: 3, BytesPerHour: math.NaN(),
+			Fitted: math.NaN(), Limit: -1, TimeToLimit: math.NaN()},
+			`{"horizon":3600000000000,"points":3,"limit_bytes":-1}`},
+		{"shrinking", MemoryTrend{Horizon: time.Hour, Points: minTrendPoints, BytesPerHour: -1,
+			Fitted: 1024, Limit: -1, TimeToLimit: -1},
+			`{"horizon":3600000000000,"points":10,"limit_bytes":-1,"bytes_per_hour":-1,"fitted_bytes":1024,"seconds_to_limit":-1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.mt)
+			if err != nil || string(data) != tt.want {
+				t.Errorf("MemoryTrend.MarshalJSON() = %s, %v, want %s", data, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_memoryLimit(t *testing.T) {
+	reps := []*IntervalReport{
+		{Cgroup: &CgroupStats{MemoryMax: -1}},
+		{Cgroup: &CgroupStats{MemoryMax: 8 << 30, MemoryCurrent: 6 << 30}},
+		{Cgroup: &CgroupStats{MemoryMax: 4 << 30, MemoryCurrent: 1 << 30}},
+		{},
+	}
+	if limit, headroom := memoryLimit(reps, 0, 1<<30); limit != 8<<30 || headroom != 2<<30 {
+		t.Errorf("memoryLimit() = %v, %v, want cgroup with least headroom", limit, headroom)
+	}
+	if limit, headroom := memoryLimit(reps, 3<<30, 1<<30); limit != 3<<30 || headroom != 2<<30 {
+		t.Errorf("memoryLimit() = %v, %v, want ceiling less fitted use", limit, headroom)
+	}
+	if limit, _ := memoryLimit(reps[:1], 0, 1<<30); limit != -1 {
+		t.Errorf("memoryLimit() = %v, want -1 without limits", limit)
+	}
+}
+
+func TestSummaries_ObserveMemoryTrends(t *testing.T) {
+	defer func(mt *MemoryTrendTracker) { memoryTrends = mt }(memoryTrends)
+	memoryTrends = NewMemoryTrendTracker(durations{time.Hour, 24 * time.Hour})
+	s := &Summaries{m: map[string]*IntervalReport{
+		"worker-1/1": {Role: "worker-1", PID: 1, PSSBytes: -1},
+		"worker-1/2": {Role: "worker-1", PID: 2, PSSBytes: 1 << 20},
+	}}
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	var now time.Time
+	// Five seconds apart for a day, growing by 36 bytes a second.
+	for i := 0; i < 17280; i++ {
+		now = start.Add(time.Duration(i) * 5 * time.Second)
+		s.m["worker-1/1"].RSSBytes = 1<<30 + i*180
+		s.m["worker-1/2"].RSSBytes = 1 << 30
+		s.ObserveMemoryTrends(now)
+	}
+	got := applyMemoryLimits(memoryTrends.Trends("worker-1", now), nil, 1<<32)
+	rss := got[trendRSSBytes]
+	if len(rss) != 2 || rss[0].Points > trendPoints+1 || rss[1].Points != trendPoints {
+		t.Fatalf("MemoryTrendTracker.Trends() = %+v, want at most %d points per horizon", rss, trendPoints+1)
+	}
+	for _, mt := range rss {
+		if !tolerance(mt.BytesPerHour, 36*3600, 1e-6) {
+			t.Errorf("MemoryTrendTracker.Trends() %s = %v bytes/hour, want %v", mt.Horizon, mt.BytesPerHour, 36*3600)
+		}
+	}
+	wantLeft := float64(1<<32) - rss[1].Fitted
+	if !tolerance(rss[1].TimeToLimit, wantLeft/36, 1) {
+		t.Errorf("MemoryTrendTracker.Trends() TimeToLimit = %v, want %v", rss[1].TimeToLimit, wantLeft/36)
+	}
+	if _, ok := got[trendPSSBytes]; ok {
+		t.Errorf("MemoryTrendTracker.Trends() has pss_bytes while an instance has none")
+	}
+	if memoryTrends.Trends("manager", now) != nil {
+		t.Errorf("MemoryTrendTracker.Trends() is not nil for unknown role")
+	}
+	out := memoryTrendsString("worker-1", got)
+	want := `bro_role_memory_growth_bytes_per_hour{role="worker-1",series="rss_bytes",horizon="24h0m0s"} 129600`
+	if !strings.Contains(out, want) {
+		t.Errorf("memoryTrendsString() is missing %s:\n%s", want, out)
+	}
+}
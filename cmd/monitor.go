@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
-	"errors"
 	"log"
 	"math"
 	"os"
+	"sort"
 	"time"
 )
 
@@ -25,125 +25,159 @@ const ProcRefreshInterval = 4 * time.Second
 // there is only ever a single instance of the startMonitors in existence. If
 // this design changes in the future, locking may become necessary to protect
 // concurrent modifications to maps.
+// All maps are keyed by instance, that is role and PID, because more than one
+// process may share a role.
 type MonitoredProcesses struct {
-	transient  map[string]struct{}
-	persistent map[string]int
-	notSeen    map[string]int
-	channels   map[string]chan *ProcInfo
+	transient  map[InstanceKey]struct{}
+	persistent map[InstanceKey]struct{}
+	notSeen    map[InstanceKey]int
+	channels   map[InstanceKey]chan *ProcInfo
 }
 
 // NewTransient creates a new transient map.
-func (mp *MonitoredProcesses) NewTransient() map[string]struct{} {
-	mp.transient = make(map[string]struct{})
+func (mp *MonitoredProcesses) NewTransient() map[InstanceKey]struct{} {
+	mp.transient = make(map[InstanceKey]struct{})
 	return mp.transient
 }
 
-// InsertIntoTransient registers a role by making sure a key with role's name
-// is present. This is used for the purposes of comparison between what we
-// believe is current and what is actually current in the process table on the
-// system.
-func (mp *MonitoredProcesses) InsertIntoTransient(role string) {
-	mp.transient[role] = struct{}{}
+// InsertIntoTransient registers an instance by making sure its key is present.
+// This is used for the purposes of comparison between what we believe is
+// current and what is actually current in the process table on the system.
+func (mp *MonitoredProcesses) InsertIntoTransient(k InstanceKey) {
+	mp.transient[k] = struct{}{}
 }
 
 // RegisterNewProcess creates and initializes all the necessary pieces before
 // we can start a new monitor thread.
-func (mp *MonitoredProcesses) RegisterNewProcess(role string, pid int) {
-	mp.persistent[role] = pid
-	mp.notSeen[role] = 0
-	mp.channels[role] = make(chan *ProcInfo)
+func (mp *MonitoredProcesses) RegisterNewProcess(k InstanceKey) {
+	mp.persistent[k] = struct{}{}
+	mp.notSeen[k] = 0
+	mp.channels[k] = make(chan *ProcInfo)
 }
 
-func (mp *MonitoredProcesses) pidOf(role string) (int, bool) {
-	if pid, ok := mp.persistent[role]; ok {
-		return pid, true
+// IsMonitored reports whether or not we already have a monitor thread for the
+// given instance.
+func (mp *MonitoredProcesses) IsMonitored(k InstanceKey) bool {
+	_, ok := mp.persistent[k]
+	return ok
+}
+
+// ReplaceableInstance returns a previously monitored instance of the given role
+// which is no longer present in the process table, if there is one. A new
+// instance of a role only counts as a restart when it takes the place of such
+// an instance. If a role simply gained an instance, nothing was restarted.
+// When more than one instance is replaceable, the one with the lowest PID is
+// picked, which keeps the choice stable from one scan to the next.
+func (mp *MonitoredProcesses) ReplaceableInstance(role string) (InstanceKey, bool) {
+	var candidates []InstanceKey
+	for k := range mp.persistent {
+		if k.Role == role && !mp.InTransient(k) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return InstanceKey{}, false
 	}
-	return -1, false
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].PID < candidates[j].PID
+	})
+	return candidates[0], true
 }
 
-// PidChanged reports whether or not a PID changed for process being monitored.
-// If a process if not already known an error is returned with false, otherwise
-// nil error and true are returned.
-func (mp *MonitoredProcesses) PidChanged(role string, pid int) (bool, error) {
-	if lastKnownPid, ok := mp.pidOf(role); ok {
-		return pid != lastKnownPid, nil
+// ExecedInstance returns a previously monitored instance which is the same
+// process as k, that is same PID and start time, but which was running a
+// different executable. Such a process called exec(2) in place, and while it
+// is not a restart, it is not the same program anymore either.
+func (mp *MonitoredProcesses) ExecedInstance(k InstanceKey) (InstanceKey, bool) {
+	for old := range mp.persistent {
+		if old.PID == k.PID && old.Starttime == k.Starttime &&
+			old.ExeInode != k.ExeInode && !mp.InTransient(old) {
+			return old, true
+		}
 	}
-	return false, errors.New("this role not seen previously")
+	return InstanceKey{}, false
 }
 
-// UpdatePid replaces PID for a given process with another value.
-func (mp *MonitoredProcesses) UpdatePid(role string, pid int) {
-	mp.persistent[role] = pid
+// Replace hands over the monitor thread of instance old to instance new. The
+// channel is moved rather than recreated, so that the goroutine on the other
+// end keeps its state, including count of restarts.
+func (mp *MonitoredProcesses) Replace(old, new InstanceKey) {
+	mp.persistent[new] = struct{}{}
+	mp.notSeen[new] = 0
+	mp.channels[new] = mp.channels[old]
+	delete(mp.channels, old)
+	delete(mp.persistent, old)
+	delete(mp.notSeen, old)
 }
 
 // ResetNotSeen resets to zero a counter value tracking number of times
 // a process known to have existed at some point is no longer seen.
-func (mp *MonitoredProcesses) ResetNotSeen(role string) {
-	mp.notSeen[role] = 0
+func (mp *MonitoredProcesses) ResetNotSeen(k InstanceKey) {
+	mp.notSeen[k] = 0
 }
 
 // IncrNotSeen increments by one count of times a process known to have existed
 // at some point, which no longer appears to exist.
-func (mp *MonitoredProcesses) IncrNotSeen(role string) {
-	if _, ok := mp.notSeen[role]; !ok {
-		mp.notSeen[role] = 1
+func (mp *MonitoredProcesses) IncrNotSeen(k InstanceKey) {
+	if _, ok := mp.notSeen[k]; !ok {
+		mp.notSeen[k] = 1
 		return
 	}
-	mp.notSeen[role]++
+	mp.notSeen[k]++
 }
 
-func (mp *MonitoredProcesses) closeChannels(role string) {
-	close(mp.channels[role])
-	mp.channels[role] = nil
+func (mp *MonitoredProcesses) closeChannels(k InstanceKey) {
+	close(mp.channels[k])
+	mp.channels[k] = nil
 }
 
 // RemoveMonitored is effectively a reverse of RegisterNewProcess. It removes
 // structures in memory associated with a process which we no longer want to
 // monitor.
-func (mp *MonitoredProcesses) RemoveMonitored(role string) {
-	mp.closeChannels(role)
-	delete(mp.channels, role)
-	delete(mp.persistent, role)
-	delete(mp.notSeen, role)
+func (mp *MonitoredProcesses) RemoveMonitored(k InstanceKey) {
+	mp.closeChannels(k)
+	delete(mp.channels, k)
+	delete(mp.persistent, k)
+	delete(mp.notSeen, k)
 	// We do not do anything about the transient map because it is recreated
 	// frequently unlike the other two maps.
 }
 
 // NotSeenCount returns count of times a process known to have existed
 // previously was not seen.
-func (mp *MonitoredProcesses) NotSeenCount(role string) int {
-	if count, ok := mp.notSeen[role]; ok {
+func (mp *MonitoredProcesses) NotSeenCount(k InstanceKey) int {
+	if count, ok := mp.notSeen[k]; ok {
 		return count
 	}
-	mp.notSeen[role] = 0 // If for some reason it is not in map, add to map.
+	mp.notSeen[k] = 0 // If for some reason it is not in map, add to map.
 	return 0
 }
 
 // NotSeen returns the map of processes and their "not seen" counts.
-func (mp *MonitoredProcesses) NotSeen() map[string]int {
+func (mp *MonitoredProcesses) NotSeen() map[InstanceKey]int {
 	return mp.notSeen
 }
 
 // NotSeenFewerThan just returns a boolean value resulting from a comparison
 // of number of times not seen and value passed in via the count argument.
-func (mp *MonitoredProcesses) NotSeenFewerThan(role string, count int) bool {
-	return mp.notSeen[role] < count
+func (mp *MonitoredProcesses) NotSeenFewerThan(k InstanceKey, count int) bool {
+	return mp.notSeen[k] < count
 }
 
-// Persistent retuns the persistent map of role->PIDs.
-func (mp *MonitoredProcesses) Persistent() map[string]int {
+// Persistent retuns the persistent set of monitored instances.
+func (mp *MonitoredProcesses) Persistent() map[InstanceKey]struct{} {
 	return mp.persistent
 }
 
-// Transient retuns the transient map of role->struct{}(s).
-func (mp *MonitoredProcesses) Transient() map[string]struct{} {
+// Transient retuns the transient set of instances seen during last scan.
+func (mp *MonitoredProcesses) Transient() map[InstanceKey]struct{} {
 	return mp.transient
 }
 
-// InTransient does a membership check, reporting whether or not a role is
-// present in the transient map.
-func (mp *MonitoredProcesses) InTransient(role string) bool {
-	_, ok := mp.transient[role]
+// InTransient does a membership check, reporting whether or not an instance
+// is present in the transient map.
+func (mp *MonitoredProcesses) InTransient(k InstanceKey) bool {
+	_, ok := mp.transient[k]
 	return ok
 }
 
@@ -152,24 +186,26 @@ func (mp *MonitoredProcesses) InTransient(role string) bool {
 // creating all required maps.
 func NewMonitoredProcesses() *MonitoredProcesses {
 	return &MonitoredProcesses{
-		transient:  make(map[string]struct{}),
-		persistent: make(map[string]int),
-		notSeen:    make(map[string]int),
-		channels:   make(map[string]chan *ProcInfo),
+		transient:  make(map[InstanceKey]struct{}),
+		persistent: make(map[InstanceKey]struct{}),
+		notSeen:    make(map[InstanceKey]int),
+		channels:   make(map[InstanceKey]chan *ProcInfo),
 	}
 }
 
 // startMonitors periodically scans the process table by reading through /proc
 // and picks out only those processes that we are interested in. These processes
-// are then added to a map of process roles to PIDs, where a role is something
-// like logger, or manager or worker-XX, etc. For each entry in this map, a
-// channel is created in a corresponding map. Also, a goroutine is started for
+// are then added to a map of instances, where an instance is a role, something
+// like logger, or manager or worker-XX, etc. along with the process' PID. For
+// each entry in this map, a channel is created in a corresponding map. Also, a goroutine is started for
 // every process, and the channel for the given process is passed to this
 // goroutine, establishing a one-way communication mechanism. This channel is in
 // essence an updates channel. Initially, information about each process that we
 // intend to monitor is passed to the goroutine on the other end of the channel,
 // and then subsequently, any time this process is restarted, new information
-// about the process is passed to goroutine responsible for this process.
+// about the process is passed to goroutine responsible for this process. A
+// process is considered restarted when an instance of some role disappears and
+// a new instance of the same role appears.
 // As a side-effect of these periodic checks, if we detect at some point a
 // process that is not already in the map, we begin to track this process and
 // create a new monitor goroutine for it.
@@ -189,50 +225,72 @@ func startMonitors(
 			// is expected to be transient and its contents are only good for a
 			// single iteration of this loop.
 			mp.NewTransient()
-			for _, p := range processes() {
-				mp.InsertIntoTransient(p.Role)
-				if changed, err := mp.PidChanged(p.Role, p.PID); err == nil {
-					if changed {
-						if pid, ok := mp.pidOf(p.Role); ok {
-							log.Printf("PID for process %s changed from %d to %d",
-								p.Role, pid, p.PID)
-						}
-						mp.UpdatePid(p.Role, p.PID)
-						p.PIDChaged = true
-						mp.channels[p.Role] <- p
+			procs := processes()
+			// Every process must be in the transient map before we look for
+			// replaceable instances, otherwise an instance which is alive but
+			// not yet visited would look like it went away.
+			for _, p := range procs {
+				mp.InsertIntoTransient(p.Key())
+			}
+			for _, p := range procs {
+				k := p.Key()
+				if mp.IsMonitored(k) {
+					continue
+				}
+				if old, ok := mp.ExecedInstance(k); ok {
+					log.Printf("Process %s with PID %d exec'd in place, inode %d -> %d",
+						p.Role, p.PID, old.ExeInode, k.ExeInode)
+					mp.Replace(old, k)
+					p.Execed = true
+					mp.channels[k] <- p
+					continue
+				}
+				if old, ok := mp.ReplaceableInstance(p.Role); ok {
+					// An instance of this role went away and a new one showed
+					// up in its place, which is what a restart looks like.
+					// This includes a new process which happens to have been
+					// given the PID of the one it replaces.
+					if old.PID == p.PID {
+						log.Printf("PID %d for process %s was reused by a new process",
+							p.PID, p.Role)
+					} else {
+						log.Printf("PID for process %s changed from %d to %d",
+							p.Role, old.PID, p.PID)
 					}
-				} else { // This process' Role is not already in the map
-					// Register a new process if this is a process which we have
-					// never seen before and do not already have a monitor
-					// thread for its role. If this is a new process ID for a
-					// previously seen role, we will already have these, and
-					// instead we just update the process ID above.
-					mp.RegisterNewProcess(p.Role, p.PID)
-
-					// Start a new monitor thread for role we have not yet seen,
-					// or have seen before but removed because it was not seen
-					// for a number of intervals.
-					go monitor(mp.channels[p.Role], repChan)
-					mp.channels[p.Role] <- p
-					log.Printf("Added %s with PID %d to map", p.Role, p.PID)
+					mp.Replace(old, k)
+					p.PIDChaged = true
+					mp.channels[k] <- p
+					continue
 				}
+				// Register a new process if this is an instance which we have
+				// never seen before and which does not replace an instance we
+				// already have a monitor thread for.
+				mp.RegisterNewProcess(k)
+
+				// Start a new monitor thread for instance we have not yet seen,
+				// or have seen before but removed because it was not seen
+				// for a number of intervals.
+				go monitor(mp.channels[k], repChan)
+				mp.channels[k] <- p
+				log.Printf("Added %s with PID %d to map", p.Role, p.PID)
 			}
 
-			for role, pid := range mp.Persistent() {
-				if !mp.InTransient(role) {
-					if mp.NotSeenFewerThan(role, MaxNotSeenIntervals) {
-						mp.IncrNotSeen(role)
-						log.Printf("PID %d for process %s no longer seen", pid, role)
+			for k := range mp.Persistent() {
+				if !mp.InTransient(k) {
+					if mp.NotSeenFewerThan(k, MaxNotSeenIntervals) {
+						mp.IncrNotSeen(k)
+						log.Printf("PID %d for process %s no longer seen", k.PID, k.Role)
 						continue
 					}
 					// We need to notify corresponding goroutine that it needs
 					// to shutdown! After telling relevant goroutine to stop,
-					// remove the no longer existing role from the current map.
-					log.Printf("Removing %s from list of monitored processes", role)
+					// remove the no longer existing instance from the current
+					// map.
+					log.Printf("Removing %s from list of monitored processes", k)
 					// RemoveMonitored(...) signals associated goroutine to
 					// stop and return, otherwise we are going to have leaking
 					// goroutines.
-					mp.RemoveMonitored(role)
+					mp.RemoveMonitored(k)
 					// Do not attempt to send on the channel for the process
 					// after calling RemoveMonitored(...) here to prevent a
 					// send on closed channel panic.
@@ -241,8 +299,8 @@ func startMonitors(
 					// seen again, reset the count to make sure that next time
 					// process is not seen again, we again start counting from
 					// a zero counter.
-					if mp.NotSeenCount(role) > 0 {
-						mp.ResetNotSeen(role)
+					if mp.NotSeenCount(k) > 0 {
+						mp.ResetNotSeen(k)
 					}
 				}
 			}
@@ -255,25 +313,76 @@ func startMonitors(
 
 func monitor(p <-chan *ProcInfo, r chan<- *IntervalReport) {
 	var counter uint64
-	var histogram = NewHist()
+	var histogram = histograms.New(histCPURate)
+	// legacyHistogram keeps rate_histogram in the buckets it always had, so
+	// that configuring histograms does not change it under its consumers.
+	var legacyHistogram = NewHist()
+	var rssGrowth = histograms.New(histRSSGrowth)
+	var ioRate = histograms.New(histIORate)
+	var prevRSS = -1
+	var prevRSSAt time.Time
+	var prevIO *ProcIO
+	var prevIOAt time.Time
 	var initTimestamp = time.Now()
 	var lifetimeRate float64
 	var osPageSize = os.Getpagesize()
 	var newPIDCounter uint64
+	var execCounter uint64
 	var times CPUTimes
+	var schedstat SchedstatCounter
+	var affinity = NewAffinityTracker()
+	var cgroup *CgroupStats
+	var warnedLimits = make(map[string]struct{})
 	var watching *ProcInfo
-	var window = windowSize
-	var samples = make([]float64, window)
+	var samples = NewSampleSeries(longest(statsWindows))
+	var lifetimeSamples = NewSampleSeries(statsWindows[0])
+	var pss = -1
+	var prevDelays *TaskDelays
+	var prevDelaysAt time.Time
+	var delaysErr string
+	var pssAt time.Time
+	var rateEWMA = newRateAverages()
 
 	for {
 		select {
-		case watching = <-p:
+		case next := <-p:
+			// Whatever we were watching until now is either replaced or gone,
+			// and its report must not linger next to the one for its
+			// replacement.
+			if watching != nil {
+				r <- &IntervalReport{
+					PID:     watching.PID,
+					Role:    watching.Role,
+					Retired: true,
+				}
+			}
+			watching = next
 			if watching != nil {
 				if counter > 0 && watching.PIDChaged {
 					newPIDCounter++
+					// CPU times of the new process have nothing to do with
+					// those of the process it replaced.
+					times.Reset()
+					schedstat.Reset()
+					affinity.Reset()
+					// Nor do statistics over windows and moving averages,
+					// which would otherwise mix samples of both for as long
+					// as the longest window, or the 15 minute average.
+					samples.Reset()
+					lifetimeSamples.Reset()
+					rateEWMA = newRateAverages()
+					prevRSS, prevIO = -1, nil
+					pss, pssAt = -1, time.Time{}
+					prevDelays = nil
 					log.Printf(
 						"Resume monitor for %s with new PID: %d *ProcInfo: %p",
 						watching.Role, watching.PID, watching)
+				} else if counter > 0 && watching.Execed {
+					// Same process, so CPU times carry on where they were.
+					execCounter++
+					log.Printf(
+						"Resume monitor for %s after exec with PID: %d *ProcInfo: %p",
+						watching.Role, watching.PID, watching)
 				} else {
 					log.Printf("Start monitor for %s with PID: %d *ProcInfo: %p",
 						watching.Role, watching.PID, watching)
@@ -289,55 +398,177 @@ func monitor(p <-chan *ProcInfo, r chan<- *IntervalReport) {
 		default:
 			var s ProcStat
 			var ok bool
-			if s, ok = watching.Stat(); ok {
+			// If the PID we are watching now belongs to some other process,
+			// we treat it exactly like a process which went away, because
+			// CPU time of a stranger must never be attributed to our role.
+			if s, ok = watching.Stat(); ok && !watching.IsSameProcess(s) {
+				s, ok = ProcStat{}, false
+			}
+			if ok {
 				lifetimeRate = float64(s.OnCPUTimeTotal()) / float64(watching.ProcAgeAsTicks())
-				samples[counter%window] = lifetimeRate
+				lifetimeSamples.Add(time.Now(), lifetimeRate)
 
-				// If we don't have any value for previous runtime, this is the
-				// first time we gather stats. In this case we set both current
-				// and previous values to the sample we just collected.
-				// If previous value is larger than current value, we are no
-				// longer looking at same process. We behave just like we would
-				// on first run, setting both previous and current values to the
-				// sample we just collected.
-				if times.PrevRunTime > times.CurrentRunTime ||
-					times.PrevRunTime == 0 {
-					times.PrevOnCPUTime = s.OnCPUTimeTotal()
-					times.CurrentOnCPUTime = s.OnCPUTimeTotal()
-					times.PrevRunTime = watching.ProcAgeAsTicks()
-					times.CurrentRunTime = watching.ProcAgeAsTicks()
-				} else {
-					times.PrevOnCPUTime = times.CurrentOnCPUTime
-					times.PrevRunTime = times.CurrentRunTime
-					times.CurrentOnCPUTime = s.OnCPUTimeTotal()
-					times.CurrentRunTime = watching.ProcAgeAsTicks()
+				// On first run, after a restart, or when we fall back from
+				// schedstat to ticks, both previous and current values are
+				// set to the sample we just collected, see CPUTimes.Update.
+				onCPU, runTime, source := cpuTimes(watching, s, preciseCPU, &schedstat)
+				if times.Update(onCPU, runTime, source) {
 					histogram.Insert(times.Delta())
+					legacyHistogram.Insert(times.Delta())
 				}
+				// The very first sample of a process has no rate yet, but its
+				// CPU times already start a window.
+				now := time.Now()
+				samples.AddTimes(now, times.Delta(), times.CurrentOnCPUTime, times.CurrentRunTime, times.Source)
+				rateEWMA.Update(times.Delta(), now)
+				// Samples may be further apart than a second, so growth is
+				// per second actually elapsed, the same as IO rate.
+				rss := s.RSS * osPageSize
+				if elapsed := now.Sub(prevRSSAt).Seconds(); prevRSS >= 0 && elapsed > 0 {
+					rssGrowth.Insert(float64(rss-prevRSS) / elapsed)
+				}
+				prevRSS, prevRSSAt = rss, now
 			} else {
-				samples[counter%window] = math.NaN()
+				samples.Add(time.Now(), math.NaN())
+				lifetimeSamples.Add(time.Now(), math.NaN())
 				times.Reset()
+				schedstat.Reset()
+				prevRSS, prevIO = -1, nil
+				pss, pssAt = -1, time.Time{}
+				prevDelays = nil
+			}
+			var ioUsage *IOUsage
+			if ok {
+				if cur, err := watching.IO(); err == nil {
+					now := time.Now()
+					ioUsage = newIOUsage(prevIO, cur, now.Sub(prevIOAt))
+					if prevIO != nil && !math.IsNaN(ioUsage.Rate()) {
+						ioRate.Insert(ioUsage.Rate())
+					}
+					prevIO, prevIOAt = &cur, now
+				}
+			}
+			if ok && time.Since(pssAt) >= PSSInterval {
+				var err error
+				if pss, err = watching.PSS(); err != nil {
+					pss = -1
+				}
+				pssAt = time.Now()
+			}
+			var delays *TaskDelays
+			if ok && taskstats != nil {
+				if cur, err := taskstats.Delays(watching.PID); err == nil {
+					now := time.Now()
+					cur.updateSince(prevDelays, now.Sub(prevDelaysAt))
+					delays, prevDelays, prevDelaysAt = cur, cur, now
+					delaysErr = ""
+				} else if err.Error() != delaysErr {
+					// The same failure every second tells nothing new.
+					handleErr(err, false)
+					delaysErr = err.Error()
+				}
 			}
 			counter++
-			if counter >= window {
-				r <- &IntervalReport{
-					PID:             watching.PID,
-					Role:            watching.Role,
-					InitTimestamp:   initTimestamp,
-					Timestamp:       time.Now(),
-					Age:             watching.ProcAgeAsDuration(),
-					WindowRate:      avg(samples),
-					StandardDev:     stddev(samples),
-					LifetimeRate:    lifetimeRate,
-					CurrentRate:     times.Delta(),
-					RateHistogram:   histogram.JSONSafeMap(),
-					TimesRestated:   newPIDCounter,
-					VirtMemoryBytes: s.VSize,
-					RSSBytes:        s.RSS * osPageSize,
+			var hostContext *HostCPUContext
+			var affinityReport *AffinityReport
+			if hostCPU != nil && ok {
+				if allowed, err := watching.AllowedCPUList(); err == nil {
+					hostContext = newHostCPUContext(hostCPU, allowed, times.Delta())
+				}
+			}
+			if ok {
+				if threads, err := watching.Threads(); err == nil {
+					affinityReport = affinity.Sample(threads, pinning[watching.Role])
 				}
-				// fmt.Printf("counter: %d | avg: %f\n", counter, avg(samples))
+			}
+			var iface *InterfaceStats
+			if name, ok := capture[watching.Role]; ok && netIfs != nil {
+				iface, _ = netIfs.Of(name)
+			}
+			var suricataTotals *SuricataCounters
+			if suricata != nil && watching.Role == suricataRole {
+				suricataTotals = suricata.Totals()
+			}
+			var reporterLevels map[string]uint64
+			if zeekReporter != nil {
+				reporterLevels = zeekReporter.Levels(watching.Role)
+			}
+			var resources *ResourceUsage
+			var sockets *SocketReport
+			if ok {
+				var err error
+				var socketInodes []uint64
+				if resources, socketInodes, err = watching.ResourceUsage(s, limitWarnThreshold); err == nil {
+					if sockets, err = watching.Sockets(socketInodes); err != nil {
+						handleErr(err, false)
+					}
+					// Log each warning once, not every second for as
+					// long as it persists.
+					var current = make(map[string]struct{})
+					for _, w := range resources.Warnings {
+						current[w.Limit] = struct{}{}
+						if _, warned := warnedLimits[w.Limit]; !warned {
+							log.Printf("%s with PID %d: %s", watching.Role, watching.PID, w.Message)
+						}
+					}
+					warnedLimits = current
+				}
+			}
+			if cgroupRoot != "" && ok {
+				var prevCgroup = cgroup
+				cgroup = nil
+				if path, err := watching.CgroupPath(); err == nil {
+					if cgroup, err = readCgroupStats(cgroupRoot, path); err == nil {
+						cgroup.updateSince(prevCgroup)
+					} else if !os.IsNotExist(err) {
+						// A cgroup which is gone, because the process is
+						// exiting or was just moved, is not worth a
+						// message every second.
+						handleErr(err, false)
+					}
+				}
+			}
+			now := time.Now()
+			windows := samples.Windows(statsWindows, now)
+			r <- &IntervalReport{
+				PID:                 watching.PID,
+				Role:                watching.Role,
+				InitTimestamp:       initTimestamp,
+				Timestamp:           now,
+				Age:                 watching.ProcAgeAsDuration(),
+				WindowRate:          windows[0].Rate,
+				LifetimeRateAverage: lifetimeSamples.Window(statsWindows[0], now).Mean,
+				RateEWMA:            rateEWMA.Values(),
+				StandardDev:         windows[0].StandardDev,
+				Windows:             windows,
+				LifetimeRate:        lifetimeRate,
+				CurrentRate:         times.Delta(),
+				CPUSource:           times.Source,
+				RateHistogram:       legacyHistogram.JSONSafeMap(),
+				Histograms: map[string]HistogramView{
+					histCPURate:   histogram.View(),
+					histRSSGrowth: rssGrowth.View(),
+					histIORate:    ioRate.View(),
+				},
+				IO:              ioUsage,
+				Delays:          delays,
+				TimesRestated:   newPIDCounter,
+				TimesExeced:     execCounter,
+				Starttime:       watching.S.Starttime,
+				VirtMemoryBytes: s.VSize,
+				RSSBytes:        s.RSS * osPageSize,
+				PSSBytes:        pss,
+				HostCPU:         hostContext,
+				Affinity:        affinityReport,
+				Cgroup:          cgroup,
+				Resources:       resources,
+				Sockets:         sockets,
+				Interface:       iface,
+				ReporterLevels:  reporterLevels,
+				Suricata:        suricataTotals,
 			}
 
-			<-time.NewTimer(time.Second).C
+			<-time.NewTimer(SampleInterval).C
 		}
 	}
 }
@@ -43,3 +43,115 @@ func Test_monitor(t *testing.T) {
 	p = nil
 
 }
+
+func TestMonitoredProcesses_ReplaceableInstance(t *testing.T) {
+	tests := []struct {
+		name      string
+		monitored []InstanceKey
+		seen      []InstanceKey
+		role      string
+		want      InstanceKey
+		wantOk    bool
+	}{
+		{name: "role gained an instance, nothing to replace",
+			monitored: []InstanceKey{{Role: "worker-1", PID: 100}},
+			seen:      []InstanceKey{{Role: "worker-1", PID: 100}, {Role: "worker-1", PID: 200}},
+			role:      "worker-1",
+			want:      InstanceKey{}, wantOk: false,
+		},
+		{name: "instance went away and another took its place",
+			monitored: []InstanceKey{{Role: "worker-1", PID: 100}, {Role: "worker-1", PID: 101}},
+			seen:      []InstanceKey{{Role: "worker-1", PID: 101}, {Role: "worker-1", PID: 200}},
+			role:      "worker-1",
+			want:      InstanceKey{Role: "worker-1", PID: 100}, wantOk: true,
+		},
+		{name: "lowest PID is replaced first",
+			monitored: []InstanceKey{{Role: "unknown", PID: 300}, {Role: "unknown", PID: 100}, {Role: "unknown", PID: 200}},
+			seen:      []InstanceKey{{Role: "unknown", PID: 400}},
+			role:      "unknown",
+			want:      InstanceKey{Role: "unknown", PID: 100}, wantOk: true,
+		},
+		{name: "instances of other roles are never replaced",
+			monitored: []InstanceKey{{Role: "worker-2", PID: 100}},
+			seen:      []InstanceKey{{Role: "worker-1", PID: 200}},
+			role:      "worker-1",
+			want:      InstanceKey{}, wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := NewMonitoredProcesses()
+			for _, k := range tt.monitored {
+				mp.RegisterNewProcess(k)
+			}
+			mp.NewTransient()
+			for _, k := range tt.seen {
+				mp.InsertIntoTransient(k)
+			}
+			got, ok := mp.ReplaceableInstance(tt.role)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("MonitoredProcesses.ReplaceableInstance() = %v, %v, want %v, %v",
+					got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestMonitoredProcesses_Replace(t *testing.T) {
+	old := InstanceKey{Role: "worker-1", PID: 100}
+	new := InstanceKey{Role: "worker-1", PID: 200}
+	mp := NewMonitoredProcesses()
+	mp.RegisterNewProcess(old)
+	mp.IncrNotSeen(old)
+	ch := mp.channels[old]
+
+	mp.Replace(old, new)
+	if mp.IsMonitored(old) {
+		t.Errorf("Replace(): %v must no longer be monitored", old)
+	}
+	if !mp.IsMonitored(new) {
+		t.Errorf("Replace(): %v must be monitored", new)
+	}
+	if mp.channels[new] != ch {
+		t.Errorf("Replace(): channel of %v must be handed over to %v", old, new)
+	}
+	if mp.NotSeenCount(new) != 0 {
+		t.Errorf("Replace(): not seen count of %v must be zero", new)
+	}
+}
+
+func TestMonitoredProcesses_ExecedInstance(t *testing.T) {
+	running := InstanceKey{Role: "worker-1", PID: 100, Starttime: 5000, ExeInode: 42}
+	tests := []struct {
+		name   string
+		k      InstanceKey
+		want   InstanceKey
+		wantOk bool
+	}{
+		{name: "same process running a different executable",
+			k:    InstanceKey{Role: "worker-1", PID: 100, Starttime: 5000, ExeInode: 43},
+			want: running, wantOk: true,
+		},
+		{name: "PID reused by a new process is not an exec",
+			k:    InstanceKey{Role: "worker-1", PID: 100, Starttime: 9000, ExeInode: 43},
+			want: InstanceKey{}, wantOk: false,
+		},
+		{name: "different PID is not an exec",
+			k:    InstanceKey{Role: "worker-1", PID: 101, Starttime: 5000, ExeInode: 43},
+			want: InstanceKey{}, wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := NewMonitoredProcesses()
+			mp.RegisterNewProcess(running)
+			mp.NewTransient()
+			mp.InsertIntoTransient(tt.k)
+			got, ok := mp.ExecedInstance(tt.k)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("MonitoredProcesses.ExecedInstance() = %v, %v, want %v, %v",
+					got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
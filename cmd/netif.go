@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetIfInterval is how often we sample statistics of capture interfaces.
+const NetIfInterval = time.Second
+
+// Flags of a network interface, from linux/if.h.
+const (
+	iffUp      = 0x1
+	iffRunning = 0x40
+	iffPromisc = 0x100
+)
+
+// InterfaceCounters are receive counters of a network interface. Packets the
+// NIC could not take in because its ring was full show up in RxMissedErrors or
+// RxFIFOErrors depending on the driver, while RxDropped counts packets the
+// kernel had no room for.
+type InterfaceCounters struct {
+	RxPackets      uint64 `json:"rx_packets"`
+	RxDropped      uint64 `json:"rx_dropped"`
+	RxMissedErrors uint64 `json:"rx_missed_errors"`
+	RxFIFOErrors   uint64 `json:"rx_fifo_errors"`
+}
+
+// Drops is all packets lost on their way in.
+func (c InterfaceCounters) Drops() uint64 {
+	return c.RxDropped + c.RxMissedErrors + c.RxFIFOErrors
+}
+
+// readInterfaceCounters reads statistics directory of an interface, which is
+// /sys/class/net/<if>/statistics. Counters a driver does not provide are zero.
+func readInterfaceCounters(dir string) (InterfaceCounters, error) {
+	var c InterfaceCounters
+	for _, f := range []struct {
+		name string
+		v    *uint64
+	}{
+		{"rx_packets", &c.RxPackets},
+		{"rx_dropped", &c.RxDropped},
+		{"rx_missed_errors", &c.RxMissedErrors},
+		{"rx_fifo_errors", &c.RxFIFOErrors},
+	} {
+		data, err := ReadFileNoStat(filepath.Join(dir, f.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return c, err
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("bad %s: %v", f.name, err)
+		}
+		*f.v = n
+	}
+	return c, nil
+}
+
+// InterfaceFlags tells whether an interface is in a state fit for capture.
+type InterfaceFlags struct {
+	Up        bool   `json:"up"`
+	Running   bool   `json:"running"`
+	Promisc   bool   `json:"promisc"`
+	OperState string `json:"operstate"`
+}
+
+// parseInterfaceFlags parses contents of /sys/class/net/<if>/flags, which is a
+// hexadecimal number such as 0x1103.
+func parseInterfaceFlags(s string) (InterfaceFlags, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(s), "0x"), 16, 32)
+	if err != nil {
+		return InterfaceFlags{}, fmt.Errorf("bad interface flags %q: %v", s, err)
+	}
+	return InterfaceFlags{
+		Up:      n&iffUp != 0,
+		Running: n&iffRunning != 0,
+		Promisc: n&iffPromisc != 0,
+	}, nil
+}
+
+// readInterfaceFlags reads flags and operational state of interface in dir.
+func readInterfaceFlags(dir string) (InterfaceFlags, error) {
+	data, err := ReadFileNoStat(filepath.Join(dir, "flags"))
+	if err != nil {
+		return InterfaceFlags{}, err
+	}
+	flags, err := parseInterfaceFlags(string(data))
+	if err != nil {
+		return flags, err
+	}
+	if data, err := ReadFileNoStat(filepath.Join(dir, "operstate")); err == nil {
+		flags.OperState = strings.TrimSpace(string(data))
+	}
+	return flags, nil
+}
+
+// InterfaceRates are per-second rates of receive counters between two samples.
+// DropShare is the share of packets arriving at the interface which were lost.
+type InterfaceRates struct {
+	RxPackets      float64 `json:"rx_packets"`
+	RxDropped      float64 `json:"rx_dropped"`
+	RxMissedErrors float64 `json:"rx_missed_errors"`
+	RxFIFOErrors   float64 `json:"rx_fifo_errors"`
+	Drops          float64 `json:"drops"`
+	DropShare      float64 `json:"drop_share"`
+}
+
+// ratesBetween computes rates of counters over elapsed time. Counters going
+// backwards, which happens when a driver is reloaded, give NaN rather than a
+// bogus spike.
+func ratesBetween(prev, cur InterfaceCounters, elapsed time.Duration) InterfaceRates {
+	var secs = elapsed.Seconds()
+	var rate = func(p, c uint64) float64 {
+		if c < p || secs <= 0 {
+			return math.NaN()
+		}
+		return float64(c-p) / secs
+	}
+	r := InterfaceRates{
+		RxPackets:      rate(prev.RxPackets, cur.RxPackets),
+		RxDropped:      rate(prev.RxDropped, cur.RxDropped),
+		RxMissedErrors: rate(prev.RxMissedErrors, cur.RxMissedErrors),
+		RxFIFOErrors:   rate(prev.RxFIFOErrors, cur.RxFIFOErrors),
+	}
+	r.Drops = r.RxDropped + r.RxMissedErrors + r.RxFIFOErrors
+	r.DropShare = r.Drops / (r.RxPackets + r.Drops)
+	return r
+}
+
+func noInterfaceRates() InterfaceRates {
+	return InterfaceRates{math.NaN(), math.NaN(), math.NaN(), math.NaN(),
+		math.NaN(), math.NaN()}
+}
+
+func (r InterfaceRates) safe() InterfaceRates {
+	for _, v := range []*float64{&r.RxPackets, &r.RxDropped, &r.RxMissedErrors,
+		&r.RxFIFOErrors, &r.Drops, &r.DropShare} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return r
+}
+
+// InterfaceStats is the most recent view of a capture interface.
+type InterfaceStats struct {
+	Name     string            `json:"name"`
+	Flags    InterfaceFlags    `json:"flags"`
+	Counters InterfaceCounters `json:"counters"`
+	Rates    InterfaceRates    `json:"rates"`
+}
+
+func (st InterfaceStats) safe() *InterfaceStats {
+	st.Rates = st.Rates.safe()
+	return &st
+}
+
+// String returns interface statistics in Prometheus format with given labels.
+func (st InterfaceStats) String(labels string) string {
+	var b strings.Builder
+	if labels != "" {
+		labels += ","
+	}
+	labels += fmt.Sprintf("interface=\"%s\"", st.Name)
+	fmt.Fprintf(&b, "bro_interface_rx_packets_rate{%s} %g\n", labels, st.Rates.RxPackets)
+	fmt.Fprintf(&b, "bro_interface_drops_rate{%s} %g\n", labels, st.Rates.Drops)
+	fmt.Fprintf(&b, "bro_interface_drop_share{%s} %g\n", labels, st.Rates.DropShare)
+	return b.String()
+}
+
+// captureInterfaces maps roles to interfaces they capture traffic from. It is
+// populated from interface of each worker in node.cfg and from -interface
+// flags, the latter taking precedence.
+type captureInterfaces map[string]string
+
+func (ci captureInterfaces) String() string {
+	var l []string
+	for role, iface := range ci {
+		l = append(l, role+"="+iface)
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (ci captureInterfaces) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected role=interface, got %q", v)
+	}
+	ci[parts[0]] = interfaceName(parts[1])
+	return nil
+}
+
+// Roles returns a sorted list of roles capturing from iface.
+func (ci captureInterfaces) Roles(iface string) []string {
+	var roles = []string{}
+	for role, name := range ci {
+		if name == iface {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Interfaces returns a sorted list of distinct interfaces.
+func (ci captureInterfaces) Interfaces() []string {
+	var seen = make(map[string]struct{})
+	var l []string
+	for _, iface := range ci {
+		if _, ok := seen[iface]; !ok {
+			seen[iface] = struct{}{}
+			l = append(l, iface)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+// interfaceName strips packet source prefix Zeek allows in front of interface
+// names, such as af_packet::eth0.
+func interfaceName(s string) string {
+	if i := strings.LastIndex(s, "::"); i >= 0 {
+		return s[i+2:]
+	}
+	return s
+}
+
+// interfaceSample is a sample of counters along with the time it was taken.
+type interfaceSample struct {
+	counters InterfaceCounters
+	at       time.Time
+}
+
+// InterfaceCollector keeps the two most recent samples of each capture
+// interface, from which receive and drop rates are computed.
+type InterfaceCollector struct {
+	dir   string
+	prev  map[string]interfaceSample
+	cur   map[string]interfaceSample
+	flags map[string]InterfaceFlags
+	mtx   sync.RWMutex
+}
+
+// NewInterfaceCollector returns a collector of interfaces found in dir, which
+// is normally /sys/class/net.
+func NewInterfaceCollector(dir string) *InterfaceCollector {
+	return &InterfaceCollector{
+		dir:   dir,
+		prev:  make(map[string]interfaceSample),
+		cur:   make(map[string]interfaceSample),
+		flags: make(map[string]InterfaceFlags),
+	}
+}
+
+// Update adds a new sample of an interface.
+func (ic *InterfaceCollector) Update(name string, c InterfaceCounters,
+	flags InterfaceFlags, now time.Time) {
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+	if cur, ok := ic.cur[name]; ok {
+		ic.prev[name] = cur
+	}
+	ic.cur[name] = interfaceSample{counters: c, at: now}
+	ic.flags[name] = flags
+}
+
+// Collect samples each of the named interfaces. Interfaces which cannot be
+// read, for example because they do not exist (yet), are reported as errors,
+// but do not prevent others from being sampled.
+func (ic *InterfaceCollector) Collect(names []string) error {
+	var errs []string
+	for _, name := range names {
+		dir := filepath.Join(ic.dir, name)
+		flags, err := readInterfaceFlags(dir)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		c, err := readInterfaceCounters(filepath.Join(dir, "statistics"))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		ic.Update(name, c, flags, time.Now())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Of returns the most recent view of an interface, and false if it has not
+// been sampled yet. Rates are NaN until there are two samples.
+func (ic *InterfaceCollector) Of(name string) (*InterfaceStats, bool) {
+	ic.mtx.RLock()
+	defer ic.mtx.RUnlock()
+	cur, ok := ic.cur[name]
+	if !ok {
+		return nil, false
+	}
+	st := &InterfaceStats{
+		Name:     name,
+		Flags:    ic.flags[name],
+		Counters: cur.counters,
+		Rates:    noInterfaceRates(),
+	}
+	if prev, ok := ic.prev[name]; ok {
+		st.Rates = ratesBetween(prev.counters, cur.counters, cur.at.Sub(prev.at))
+	}
+	return st, true
+}
+
+// All returns the most recent view of every sampled interface.
+func (ic *InterfaceCollector) All() []*InterfaceStats {
+	ic.mtx.RLock()
+	var names []string
+	for name := range ic.cur {
+		names = append(names, name)
+	}
+	ic.mtx.RUnlock()
+	sort.Strings(names)
+	var l []*InterfaceStats
+	for _, name := range names {
+		if st, ok := ic.Of(name); ok {
+			l = append(l, st)
+		}
+	}
+	return l
+}
+
+func (ic *InterfaceCollector) String() string {
+	var b strings.Builder
+	for _, st := range ic.All() {
+		b.WriteString(st.String(""))
+		up := 0
+		if st.Flags.Up && st.Flags.Running {
+			up = 1
+		}
+		fmt.Fprintf(&b, "bro_interface_up{interface=\"%s\"} %d\n", st.Name, up)
+	}
+	return b.String()
+}
+
+// startInterfaceCollector samples capture interfaces until context is
+// cancelled. Without any capture interfaces configured there is nothing to do.
+func startInterfaceCollector(ctx context.Context, ic *InterfaceCollector,
+	ci captureInterfaces) {
+	names := ci.Interfaces()
+	if len(names) == 0 {
+		return
+	}
+	tick := time.NewTicker(NetIfInterval)
+	defer tick.Stop()
+	for {
+		if err := ic.Collect(names); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// WorkerLoad is CPU use of a role capturing from an interface.
+type WorkerLoad struct {
+	Role         string  `json:"role"`
+	NumInstances int     `json:"num_instances"`
+	CurrentRate  float64 `json:"current_rate"`
+	WindowRate   float64 `json:"window_rate"`
+}
+
+// InterfaceReport puts drops on a capture interface next to CPU use of the
+// workers reading from it. Drops while workers are idle point at the NIC or
+// the kernel, while drops with workers pegged point at the workers.
+type InterfaceReport struct {
+	InterfaceStats
+	Workers []WorkerLoad `json:"workers"`
+	// MaxWorkerRate is the highest CurrentRate among workers.
+	MaxWorkerRate float64 `json:"max_worker_rate"`
+}
+
+// Interfaces returns a report of each sampled capture interface along with
+// CPU use of workers consuming it.
+func (s *Summaries) Interfaces(ic *InterfaceCollector, ci captureInterfaces) []InterfaceReport {
+	var l = []InterfaceReport{}
+	if ic == nil {
+		return l
+	}
+	for _, st := range ic.All() {
+		ir := InterfaceReport{
+			InterfaceStats: *st.safe(),
+			Workers:        []WorkerLoad{},
+			MaxWorkerRate:  math.NaN(),
+		}
+		var rates []float64
+		for _, role := range ci.Roles(st.Name) {
+			s.mtx.RLock()
+			rs := s.roleSummary(role)
+			s.mtx.RUnlock()
+			if rs == nil {
+				continue
+			}
+			ir.Workers = append(ir.Workers, WorkerLoad{
+				Role:         role,
+				NumInstances: rs.NumInstances,
+				CurrentRate:  rs.CurrentRate,
+				WindowRate:   rs.WindowRate,
+			})
+			rates = append(rates, rs.CurrentRate)
+		}
+		if rates = withoutNaNs(rates); len(rates) > 0 {
+			ir.MaxWorkerRate = rates[0]
+			for _, r := range rates[1:] {
+				ir.MaxWorkerRate = math.Max(ir.MaxWorkerRate, r)
+			}
+		}
+		for i := range ir.Workers {
+			if math.IsNaN(ir.Workers[i].CurrentRate) {
+				ir.Workers[i].CurrentRate = -1
+			}
+			if math.IsNaN(ir.Workers[i].WindowRate) {
+				ir.Workers[i].WindowRate = -1
+			}
+		}
+		if math.IsNaN(ir.MaxWorkerRate) {
+			ir.MaxWorkerRate = -1
+		}
+		l = append(l, ir)
+	}
+	return l
+}
+
+// InterfacesToJSON returns serialized reports of capture interfaces.
+func (s *Summaries) InterfacesToJSON(ic *InterfaceCollector, ci captureInterfaces) ([]byte, error) {
+	return json.Marshal(s.Interfaces(ic, ci))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseInterfaceFlags(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    InterfaceFlags
+		wantErr bool
+	}{
+		{"0x1003\n", InterfaceFlags{Up: true}, false},
+		{"0x1143\n", InterfaceFlags{Up: true, Running: true, Promisc: true}, false},
+		{"0x1002\n", InterfaceFlags{}, false},
+		{"up\n", InterfaceFlags{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseInterfaceFlags(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseInterfaceFlags() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseInterfaceFlags() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readInterfaceCounters(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"rx_packets":     "1000\n",
+		"rx_dropped":     "10\n",
+		"rx_fifo_errors": "2\n",
+	})
+	got, err := readInterfaceCounters(root)
+	if err != nil {
+		t.Fatalf("readInterfaceCounters() error = %v", err)
+	}
+	want := InterfaceCounters{RxPackets: 1000, RxDropped: 10, RxFIFOErrors: 2}
+	if got != want {
+		t.Errorf("readInterfaceCounters() = %+v, want %+v", got, want)
+	}
+	if got.Drops() != 12 {
+		t.Errorf("InterfaceCounters.Drops() = %d, want 12", got.Drops())
+	}
+}
+
+func Test_ratesBetween(t *testing.T) {
+	prev := InterfaceCounters{RxPackets: 1000, RxDropped: 10, RxMissedErrors: 5}
+	cur := InterfaceCounters{RxPackets: 2800, RxDropped: 110, RxMissedErrors: 105}
+	got := ratesBetween(prev, cur, 2*time.Second)
+	if !tolerance(got.RxPackets, 900, 1e-9) || !tolerance(got.Drops, 100, 1e-9) {
+		t.Errorf("ratesBetween() = %+v, want 900 packets and 100 drops per second", got)
+	}
+	if !tolerance(got.DropShare, 0.1, 1e-9) {
+		t.Errorf("ratesBetween() DropShare = %v, want 0.1", got.DropShare)
+	}
+	// Counters reset when driver is reloaded.
+	got = ratesBetween(cur, prev, 2*time.Second)
+	if !math.IsNaN(got.RxPackets) || !math.IsNaN(got.DropShare) {
+		t.Errorf("ratesBetween() after reset = %+v, want NaNs", got)
+	}
+}
+
+func Test_interfaceName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"eth0", "eth0"},
+		{"af_packet::eth0", "eth0"},
+		{"pf_ring::zc:eth1", "zc:eth1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := interfaceName(tt.in); got != tt.want {
+				t.Errorf("interfaceName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_captureInterfaces(t *testing.T) {
+	ci := make(captureInterfaces)
+	for _, v := range []string{"worker-1-1=af_packet::eth0", "worker-1-2=eth0", "worker-2=eth1"} {
+		if err := ci.Set(v); err != nil {
+			t.Fatalf("captureInterfaces.Set(%q) error = %v", v, err)
+		}
+	}
+	if err := ci.Set("worker-3"); err == nil {
+		t.Errorf("captureInterfaces.Set() expected error for missing interface")
+	}
+	if got, want := ci.Interfaces(), []string{"eth0", "eth1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("captureInterfaces.Interfaces() = %v, want %v", got, want)
+	}
+	if got, want := ci.Roles("eth0"), []string{"worker-1-1", "worker-1-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("captureInterfaces.Roles() = %v, want %v", got, want)
+	}
+}
+
+func TestInterfaceCollector_Collect(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"eth0/flags":                 "0x1143\n",
+		"eth0/operstate":             "up\n",
+		"eth0/statistics/rx_packets": "1000\n",
+		"eth0/statistics/rx_dropped": "0\n",
+	})
+	ic := NewInterfaceCollector(root)
+	if err := ic.Collect([]string{"eth0", "eth9"}); err == nil {
+		t.Errorf("InterfaceCollector.Collect() expected error for missing interface")
+	}
+	st, ok := ic.Of("eth0")
+	if !ok {
+		t.Fatalf("InterfaceCollector.Of() found nothing")
+	}
+	if !st.Flags.Promisc || st.Flags.OperState != "up" || !math.IsNaN(st.Rates.RxPackets) {
+		t.Errorf("InterfaceCollector.Of() = %+v, want promiscuous interface without rates", st)
+	}
+	if _, ok := ic.Of("eth9"); ok {
+		t.Errorf("InterfaceCollector.Of() found interface which does not exist")
+	}
+	writeFixture(t, filepath.Join(root, "eth0", "statistics"), map[string]string{
+		"rx_packets": "1500\n",
+	})
+	if err := ic.Collect([]string{"eth0"}); err != nil {
+		t.Fatalf("InterfaceCollector.Collect() error = %v", err)
+	}
+	if st, _ = ic.Of("eth0"); st.Rates.RxPackets <= 0 {
+		t.Errorf("InterfaceCollector.Of() rates = %+v, want positive packet rate", st.Rates)
+	}
+}
+
+func TestSummaries_Interfaces(t *testing.T) {
+	now := time.Now()
+	ic := NewInterfaceCollector("")
+	ic.Update("eth0", InterfaceCounters{RxPackets: 1000}, InterfaceFlags{Up: true}, now)
+	ic.Update("eth0", InterfaceCounters{RxPackets: 1900, RxDropped: 100}, InterfaceFlags{Up: true}, now.Add(time.Second))
+	ci := captureInterfaces{"worker-1": "eth0", "worker-2": "eth0", "worker-3": "eth1"}
+
+	s := &Summaries{m: make(map[string]*IntervalReport)}
+	s.Insert(&IntervalReport{Role: "worker-1", PID: 100, CurrentRate: 0.25, WindowRate: 0.2})
+	s.Insert(&IntervalReport{Role: "worker-2", PID: 101, CurrentRate: 0.95, WindowRate: 0.9})
+
+	got := s.Interfaces(ic, ci)
+	if len(got) != 1 {
+		t.Fatalf("Summaries.Interfaces() = %+v, want one interface", got)
+	}
+	if !tolerance(got[0].Rates.DropShare, 0.1, 1e-9) || got[0].MaxWorkerRate != 0.95 {
+		t.Errorf("Summaries.Interfaces() = %+v, want drop share 0.1 and max worker rate 0.95", got[0])
+	}
+	want := []WorkerLoad{
+		{Role: "worker-1", NumInstances: 1, CurrentRate: 0.25, WindowRate: 0.2},
+		{Role: "worker-2", NumInstances: 1, CurrentRate: 0.95, WindowRate: 0.9},
+	}
+	if !reflect.DeepEqual(got[0].Workers, want) {
+		t.Errorf("Summaries.Interfaces() Workers = %+v, want %+v", got[0].Workers, want)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ZeekNode is a single section of Zeek's node.cfg, which describes a node of
+// the cluster. A worker section with lb_procs set stands for several worker
+// processes, see ProcessNames.
+type ZeekNode struct {
+	Name      string
+	Type      string
+	Host      string
+	Interface string
+	LBProcs   int
+	PinCPUs   []int
+	// MetricsPort is where the node serves its own Prometheus telemetry.
+	MetricsPort int
+}
+
+// ProcessNames returns node names of every process this section stands for.
+// Following ZeekControl, a worker worker-1 with lb_procs=4 runs as processes
+// named worker-1-1 through worker-1-4.
+func (n ZeekNode) ProcessNames() []string {
+	if n.LBProcs <= 1 {
+		return []string{n.Name}
+	}
+	var names = make([]string, n.LBProcs)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", n.Name, i+1)
+	}
+	return names
+}
+
+// Pinning returns CPUs each process of this node is meant to be pinned to.
+// ZeekControl pins each process to a single CPU, taken from pin_cpus in order.
+func (n ZeekNode) Pinning() map[string][]int {
+	var m = make(map[string][]int)
+	for i, name := range n.ProcessNames() {
+		if i < len(n.PinCPUs) {
+			m[name] = []int{n.PinCPUs[i]}
+		}
+	}
+	return m
+}
+
+// TelemetryEndpoints returns URL of telemetry of every process this section
+// stands for. Processes of a worker with lb_procs set listen on consecutive
+// ports starting with metrics_port.
+func (n ZeekNode) TelemetryEndpoints() map[string]string {
+	var m = make(map[string]string)
+	if n.MetricsPort == 0 {
+		return m
+	}
+	host := n.Host
+	if host == "" {
+		host = "localhost"
+	}
+	for i, name := range n.ProcessNames() {
+		m[name] = fmt.Sprintf("http://%s/metrics",
+			net.JoinHostPort(host, strconv.Itoa(n.MetricsPort+i)))
+	}
+	return m
+}
+
+// parseNodeCfg parses node.cfg, which is an INI-style file with a section for
+// every node. Keys we do not know about are ignored.
+func parseNodeCfg(r io.Reader) ([]ZeekNode, error) {
+	var nodes []ZeekNode
+	var cur *ZeekNode
+	var lineno int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			nodes = append(nodes, ZeekNode{Name: strings.TrimSpace(line[1 : len(line)-1])})
+			cur = &nodes[len(nodes)-1]
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || cur == nil {
+			return nil, fmt.Errorf("node.cfg line %d: unexpected %q", lineno, line)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "type":
+			cur.Type = value
+		case "host":
+			cur.Host = value
+		case "interface":
+			cur.Interface = value
+		case "lb_procs":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("node.cfg line %d: bad lb_procs: %v", lineno, err)
+			}
+			cur.LBProcs = n
+		case "metrics_port":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("node.cfg line %d: bad metrics_port: %v", lineno, err)
+			}
+			cur.MetricsPort = n
+		case "pin_cpus":
+			cpus, err := parseCPUList(strings.Replace(value, " ", "", -1))
+			if err != nil {
+				return nil, fmt.Errorf("node.cfg line %d: bad pin_cpus: %v", lineno, err)
+			}
+			cur.PinCPUs = cpus
+		}
+	}
+	return nodes, scanner.Err()
+}
+
+// readNodeCfg parses node.cfg at the given path.
+func readNodeCfg(path string) ([]ZeekNode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNodeCfg(f)
+}
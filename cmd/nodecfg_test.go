@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const nodeCfgSample = `# Example ZeekControl node configuration.
+[logger-1]
+type=logger
+host=localhost
+
+[manager]
+type=manager
+host=localhost
+
+[proxy-1]
+type=proxy
+host=localhost
+
+[worker-1]
+type=worker
+host=localhost
+interface=af_packet::eth0
+lb_method=custom
+lb_procs=3
+pin_cpus=2,3,4
+
+[worker-2]
+type=worker
+host=localhost
+interface=eth1
+pin_cpus = 6
+`
+
+func Test_parseNodeCfg(t *testing.T) {
+	nodes, err := parseNodeCfg(strings.NewReader(nodeCfgSample))
+	if err != nil {
+		t.Fatalf("parseNodeCfg() error = %v", err)
+	}
+	if len(nodes) != 5 {
+		t.Fatalf("parseNodeCfg() returned %d nodes, want 5", len(nodes))
+	}
+	want := ZeekNode{Name: "worker-1", Type: "worker", Host: "localhost",
+		Interface: "af_packet::eth0", LBProcs: 3, PinCPUs: []int{2, 3, 4}}
+	if !reflect.DeepEqual(nodes[3], want) {
+		t.Errorf("parseNodeCfg() = %+v, want %+v", nodes[3], want)
+	}
+	if _, err := parseNodeCfg(strings.NewReader("type=worker\n")); err == nil {
+		t.Errorf("parseNodeCfg() expected error for key outside of a section")
+	}
+	if _, err := parseNodeCfg(strings.NewReader("[w]\nlb_procs=many\n")); err == nil {
+		t.Errorf("parseNodeCfg() expected error for bad lb_procs")
+	}
+}
+
+func TestZeekNode_Pinning(t *testing.T) {
+	tests := []struct {
+		name string
+		node ZeekNode
+		want map[string][]int
+	}{
+		{name: "load-balanced worker",
+			node: ZeekNode{Name: "worker-1", LBProcs: 3, PinCPUs: []int{2, 3, 4}},
+			want: map[string][]int{"worker-1-1": {2}, "worker-1-2": {3}, "worker-1-3": {4}}},
+		{name: "fewer CPUs than processes",
+			node: ZeekNode{Name: "worker-1", LBProcs: 2, PinCPUs: []int{2}},
+			want: map[string][]int{"worker-1-1": {2}}},
+		{name: "single process", node: ZeekNode{Name: "worker-2", PinCPUs: []int{6}},
+			want: map[string][]int{"worker-2": {6}}},
+		{name: "not pinned", node: ZeekNode{Name: "manager"}, want: map[string][]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.node.Pinning(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ZeekNode.Pinning() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZeekNode_TelemetryEndpoints(t *testing.T) {
+	n := ZeekNode{Name: "worker-1", Host: "10.0.0.5", LBProcs: 2, MetricsPort: 9991}
+	want := map[string]string{
+		"worker-1-1": "http://10.0.0.5:9991/metrics",
+		"worker-1-2": "http://10.0.0.5:9992/metrics",
+	}
+	if got := n.TelemetryEndpoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ZeekNode.TelemetryEndpoints() = %v, want %v", got, want)
+	}
+	if got := (ZeekNode{Name: "manager"}).TelemetryEndpoints(); len(got) != 0 {
+		t.Errorf("ZeekNode.TelemetryEndpoints() = %v, want none without metrics_port", got)
+	}
+}
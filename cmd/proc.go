@@ -45,6 +45,8 @@ func buildProcInfo(procfile string) *ProcInfo {
 		proci.Role = "unknown"
 	}
 	proci.PID = pid
+	proci.Clock = systemBootClock
+	proci.ExeInode = exeInode(pid)
 	var s ProcStat
 	var ok bool
 	if s, ok = proci.Stat(); !ok {
@@ -57,15 +59,16 @@ func buildProcInfo(procfile string) *ProcInfo {
 }
 
 func findProcsByName(name string) []*ProcInfo {
-	paths, err := filepath.Glob("/proc/[0-9]*")
+	paths, err := filepath.Glob(hostFS.ProcPath("[0-9]*"))
 	handleErr(err, true)
 	var piSlc = make([]*ProcInfo, 0)
+	var exe = newExeMatcher(hostFS, name)
 	for _, procfile := range paths {
 		pSlc := strings.Split(procfile, "/")
 		// FIXME: Check length of slice
 		pid, err := strconv.Atoi(pSlc[len(pSlc)-1])
 		handleErr(err, true)
-		if isTargetProcess(pid, name) {
+		if exe.Matches(hostFS, pid) {
 			//args := cmdLineArgs(pid)
 			// if args.ProgramName() == name {
 			// If buildProcInfo returns nil, a process is likely no longer valid
@@ -141,6 +144,33 @@ type ProcStat struct {
 	VSize uint `json:"virt_memory_bytes"`
 	// Resident set size in pages.
 	RSS int `json:"rss_pages"`
+	// CPU number last executed on.
+	Processor int `json:"processor"`
+}
+
+// InstanceKey identifies a single monitored process. Role alone is not enough,
+// because several processes may share a role, for example Zeek workers started
+// with lb_procs, or any number of processes we could not assign a role to and
+// which all end up as "unknown".
+// PID alone is not enough either, because PIDs are recycled. A process is
+// identified by its PID together with its start time, which cannot repeat for
+// the same PID, and inode of its executable, which changes when a process
+// calls exec(2) in place.
+type InstanceKey struct {
+	Role      string
+	PID       int
+	Starttime uint64
+	ExeInode  uint64
+}
+
+// String returns the key in role/pid form, which is also how instances are
+// addressed under /info/<role>/<pid>.
+func (k InstanceKey) String() string {
+	return instanceKeyString(k.Role, k.PID)
+}
+
+func instanceKeyString(role string, pid int) string {
+	return fmt.Sprintf("%s/%d", role, pid)
 }
 
 // ProcInfo maintains information about a single process
@@ -150,9 +180,30 @@ type ProcInfo struct {
 	Args        []string      `json:"args"`
 	PID         int           `json:"pid"`
 	PIDChaged   bool          `json:"pid_changed"`
+	Execed      bool          `json:"execed"`
+	ExeInode    uint64        `json:"exe_inode"`
 	AgeTicks    int64         `json:"age_ticks"`
 	AgeDuration time.Duration `json:"age_nanoseconds"`
 	S           *ProcStat     `json:"process_stats"`
+	// Clock tells time since boot for age of the process, nil meaning
+	// the system clock.
+	Clock Clock `json:"-"`
+}
+
+// Key returns the instance key for this process.
+func (p ProcInfo) Key() InstanceKey {
+	var k = InstanceKey{Role: p.Role, PID: p.PID, ExeInode: p.ExeInode}
+	if p.S != nil {
+		k.Starttime = p.S.Starttime
+	}
+	return k
+}
+
+// IsSameProcess reports whether a freshly read stat still describes the
+// process we started out watching. If the start time differs, the process we
+// were watching is gone and its PID was handed to another process.
+func (p ProcInfo) IsSameProcess(s ProcStat) bool {
+	return p.S != nil && p.S.Starttime == s.Starttime
 }
 
 // OnCPUTimeTotal returns total amount of time process and its children spent
@@ -170,23 +221,34 @@ func (p ProcInfo) Stat() (ProcStat, bool) {
 		}
 		handleErr(err, true)
 	}
+	s, err := parseStat(data, p.PID)
+	if err != nil {
+		handleErr(err, true)
+	}
+	return s, true
+}
+
+// parseStat parses contents of /proc/<pid>/stat, or of /proc/<pid>/task/<tid>/stat,
+// which has the same format.
+func parseStat(data []byte, pid int) (ProcStat, error) {
 	var (
-		ignore int
+		ignore   int
+		ignore64 uint64
 
-		s = ProcStat{PID: p.PID}
+		s = ProcStat{PID: pid}
 		l = bytes.Index(data, []byte("("))
 		r = bytes.LastIndex(data, []byte(")"))
 	)
 
 	if l < 0 || r < 0 {
-		handleErr(fmt.Errorf(
+		return s, fmt.Errorf(
 			"unexpected format, couldn't extract comm: %s",
 			data,
-		), true)
+		)
 	}
 
 	s.Comm = string(data[l+1 : r])
-	_, err = fmt.Fscan(
+	_, err := fmt.Fscan(
 		bytes.NewBuffer(data[r+2:]),
 		&s.State,
 		&s.PPID,
@@ -210,23 +272,160 @@ func (p ProcInfo) Stat() (ProcStat, bool) {
 		&s.Starttime,
 		&s.VSize,
 		&s.RSS,
+		&ignore64, // rsslim
+		&ignore64, // startcode
+		&ignore64, // endcode
+		&ignore64, // startstack
+		&ignore64, // kstkesp
+		&ignore64, // kstkeip
+		&ignore64, // signal
+		&ignore64, // blocked
+		&ignore64, // sigignore
+		&ignore64, // sigcatch
+		&ignore64, // wchan
+		&ignore64, // nswap
+		&ignore64, // cnswap
+		&ignore,   // exit_signal
+		&s.Processor,
 	)
+	return s, err
+}
+
+// AllowedCPUList returns the list of CPUs this process may run on, as found in
+// Cpus_allowed_list of /proc/<pid>/status, for example 0-3,8.
+func (p ProcInfo) AllowedCPUList() (string, error) {
+	data, err := ReadFileNoStat(p.path("status"))
 	if err != nil {
-		handleErr(err, true)
+		return "", err
 	}
-	return s, true
+	if v, ok := statusField(data, "Cpus_allowed_list"); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("no Cpus_allowed_list in status of %d", p.PID)
+}
+
+// CgroupPath returns path of the process' cgroup in the unified hierarchy,
+// relative to where the hierarchy is mounted.
+func (p ProcInfo) CgroupPath() (string, error) {
+	data, err := ReadFileNoStat(p.path("cgroup"))
+	if err != nil {
+		return "", err
+	}
+	if path, ok := parseProcCgroup(data); ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", p.PID)
+}
+
+// ResourceUsage returns use of file descriptors and other limited resources,
+// along with inodes of sockets the process has open.
+func (p ProcInfo) ResourceUsage(s ProcStat, warnAt float64) (*ResourceUsage, []uint64, error) {
+	fds, sockets, err := countFDs(p.path("fd"))
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ReadFileNoStat(p.path("limits"))
+	if err != nil {
+		return nil, nil, err
+	}
+	limits, err := parseLimits(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var locked int64
+	if status, err := ReadFileNoStat(p.path("status")); err == nil {
+		locked = lockedMemoryBytes(status)
+	}
+	return newResourceUsage(fds, limits, s, locked, warnAt), sockets, nil
+}
+
+// IO returns storage IO counters of the process.
+func (p ProcInfo) IO() (ProcIO, error) {
+	data, err := ReadFileNoStat(p.path("io"))
+	if err != nil {
+		return ProcIO{}, err
+	}
+	return parseProcIO(data)
+}
+
+// PSS returns proportional set size of the process in bytes, which splits
+// pages shared with other processes evenly between them.
+func (p ProcInfo) PSS() (int, error) {
+	data, err := ReadFileNoStat(p.path("smaps_rollup"))
+	if err != nil {
+		return -1, err
+	}
+	return parsePSS(data)
+}
+
+// Sockets returns inventory of sockets with given inodes, which should be
+// inodes of sockets this process has open, see ResourceUsage.
+func (p ProcInfo) Sockets(inodes []uint64) (*SocketReport, error) {
+	inet, unix, packet, err := readSocketTables(p.path("net"))
+	if err != nil {
+		return nil, err
+	}
+	return buildSocketReport(inodes, inet, unix, packet), nil
+}
+
+// ThreadInfo is stat of a single thread of a process, along with the list of
+// CPUs this thread may run on.
+type ThreadInfo struct {
+	TID         int
+	Stat        ProcStat
+	AllowedCPUs []int
+}
+
+// Threads returns information about every thread of the process, as found
+// under /proc/<pid>/task. Threads which exit while we are reading are skipped.
+func (p ProcInfo) Threads() ([]ThreadInfo, error) {
+	paths, err := filepath.Glob(p.path("task/[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	var threads = make([]ThreadInfo, 0, len(paths))
+	for _, taskDir := range paths {
+		tid, err := strconv.Atoi(filepath.Base(taskDir))
+		if err != nil {
+			continue
+		}
+		data, err := ReadFileNoStat(filepath.Join(taskDir, "stat"))
+		if err != nil {
+			continue
+		}
+		s, err := parseStat(data, tid)
+		if err != nil {
+			handleErr(err, false)
+			continue
+		}
+		th := ThreadInfo{TID: tid, Stat: s}
+		if data, err = ReadFileNoStat(filepath.Join(taskDir, "status")); err == nil {
+			if v, ok := statusField(data, "Cpus_allowed_list"); ok {
+				th.AllowedCPUs, _ = parseCPUList(v)
+			}
+		}
+		threads = append(threads, th)
+	}
+	return threads, nil
 }
 
 func (p ProcInfo) path(name string) string {
-	return fmt.Sprintf("/proc/%d/%s", p.PID, name)
+	return hostFS.PIDPath(p.PID, name)
+}
+
+func (p ProcInfo) clock() Clock {
+	if p.Clock == nil {
+		return systemBootClock
+	}
+	return p.Clock
 }
 
 func (p ProcInfo) ProcAgeAsTicks() int64 {
-	return monotonicClockTicks() - int64(p.S.Starttime)
+	return nsecsToTicks(p.clock().SinceBoot().Nanoseconds()) - int64(p.S.Starttime)
 }
 
 func (p ProcInfo) ProcAgeAsDuration() time.Duration {
 	return time.Duration(
-		monotonicSinceBoot().Nanoseconds() -
+		p.clock().SinceBoot().Nanoseconds() -
 			ticksToNsecs(int64(p.S.Starttime)))
 }
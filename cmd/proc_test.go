@@ -201,7 +201,8 @@ func TestProcInfo_path(t *testing.T) {
 		args   args
 		want   string
 	}{
-		// TODO: Add test cases.
+		{name: "stat", fields: fields{PID: 42}, args: args{name: "stat"}, want: "/proc/42/stat"},
+		{name: "threads", fields: fields{PID: 42}, args: args{name: "task/[0-9]*"}, want: "/proc/42/task/[0-9]*"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -222,6 +223,13 @@ func TestProcInfo_path(t *testing.T) {
 	}
 }
 
+// fakeClock is a clock stopped at given time since boot.
+type fakeClock time.Duration
+
+func (c fakeClock) SinceBoot() time.Duration {
+	return time.Duration(c)
+}
+
 func TestProcInfo_ProcAgeAsTicks(t *testing.T) {
 	type fields struct {
 		Name        string
@@ -232,13 +240,16 @@ func TestProcInfo_ProcAgeAsTicks(t *testing.T) {
 		AgeTicks    int64
 		AgeDuration time.Duration
 		S           *ProcStat
+		Clock       Clock
 	}
 	tests := []struct {
 		name   string
 		fields fields
 		want   int64
 	}{
-		// TODO: Add test cases.
+		{name: "started at boot", fields: fields{S: &ProcStat{Starttime: 0}, Clock: fakeClock(time.Minute)}, want: 6000},
+		{name: "started a second ago", fields: fields{S: &ProcStat{Starttime: 5900}, Clock: fakeClock(time.Minute)}, want: 100},
+		{name: "just started", fields: fields{S: &ProcStat{Starttime: 6000}, Clock: fakeClock(time.Minute + 5*time.Millisecond)}, want: 0},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -251,6 +262,7 @@ func TestProcInfo_ProcAgeAsTicks(t *testing.T) {
 				AgeTicks:    tt.fields.AgeTicks,
 				AgeDuration: tt.fields.AgeDuration,
 				S:           tt.fields.S,
+				Clock:       tt.fields.Clock,
 			}
 			if got := p.ProcAgeAsTicks(); got != tt.want {
 				t.Errorf("ProcInfo.ProcAgeAsTicks() = %v, want %v", got, tt.want)
@@ -269,13 +281,16 @@ func TestProcInfo_ProcAgeAsDuration(t *testing.T) {
 		AgeTicks    int64
 		AgeDuration time.Duration
 		S           *ProcStat
+		Clock       Clock
 	}
 	tests := []struct {
 		name   string
 		fields fields
 		want   time.Duration
 	}{
-		// TODO: Add test cases.
+		{name: "started at boot", fields: fields{S: &ProcStat{Starttime: 0}, Clock: fakeClock(time.Minute)}, want: time.Minute},
+		{name: "started a second ago", fields: fields{S: &ProcStat{Starttime: 5900}, Clock: fakeClock(time.Minute)}, want: time.Second},
+		{name: "between ticks", fields: fields{S: &ProcStat{Starttime: 6000}, Clock: fakeClock(time.Minute + 5*time.Millisecond)}, want: 5 * time.Millisecond},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -288,6 +303,7 @@ func TestProcInfo_ProcAgeAsDuration(t *testing.T) {
 				AgeTicks:    tt.fields.AgeTicks,
 				AgeDuration: tt.fields.AgeDuration,
 				S:           tt.fields.S,
+				Clock:       tt.fields.Clock,
 			}
 			if got := p.ProcAgeAsDuration(); got != tt.want {
 				t.Errorf("ProcInfo.ProcAgeAsDuration() = %v, want %v", got, tt.want)
@@ -295,3 +311,47 @@ func TestProcInfo_ProcAgeAsDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestProcInfo_IsSameProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		p    ProcInfo
+		s    ProcStat
+		want bool
+	}{
+		{name: "same start time", p: ProcInfo{PID: 100, S: &ProcStat{Starttime: 5000}},
+			s: ProcStat{PID: 100, Starttime: 5000}, want: true},
+		{name: "PID was reused", p: ProcInfo{PID: 100, S: &ProcStat{Starttime: 5000}},
+			s: ProcStat{PID: 100, Starttime: 9000}, want: false},
+		{name: "nothing to compare against", p: ProcInfo{PID: 100},
+			s: ProcStat{PID: 100, Starttime: 5000}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.IsSameProcess(tt.s); got != tt.want {
+				t.Errorf("ProcInfo.IsSameProcess() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseStat(t *testing.T) {
+	// Captured from a Zeek worker, note the space in comm.
+	data := []byte("4242 (zeek worker) S 1 4242 4242 0 -1 4194560 91837 0 12 0 " +
+		"53712 8731 0 0 20 0 9 0 123456 1593835520 120832 18446744073709551615 " +
+		"1 1 0 0 0 0 0 4096 1260 0 0 0 17 3 0 0 0 0 0 0 0 0 0 0 0 0 0\n")
+	want := ProcStat{PID: 4242, Comm: "zeek worker", State: "S", PPID: 1, PGRP: 4242,
+		Session: 4242, TTY: 0, TPGID: -1, Flags: 4194560, MinFlt: 91837, MajFlt: 12,
+		UTime: 53712, STime: 8731, Priority: 20, NumThreads: 9, Starttime: 123456,
+		VSize: 1593835520, RSS: 120832, Processor: 3}
+	got, err := parseStat(data, 4242)
+	if err != nil {
+		t.Fatalf("parseStat() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStat() = %+v, want %+v", got, want)
+	}
+	if _, err := parseStat([]byte("4242 zeek S 1"), 4242); err == nil {
+		t.Errorf("parseStat() expected error without comm")
+	}
+}
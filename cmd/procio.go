@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcIO is parsed contents of /proc/<pid>/io. ReadBytes and WriteBytes are
+// what actually went to or came from storage, while RChar and WChar count
+// every read and write, including those on sockets and pipes.
+type ProcIO struct {
+	RChar      uint64 `json:"rchar"`
+	WChar      uint64 `json:"wchar"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+}
+
+func parseProcIO(data []byte) (ProcIO, error) {
+	var pio ProcIO
+	var fields = map[string]*uint64{
+		"rchar":       &pio.RChar,
+		"wchar":       &pio.WChar,
+		"read_bytes":  &pio.ReadBytes,
+		"write_bytes": &pio.WriteBytes,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, ok := fields[kv[0]]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil {
+			return pio, fmt.Errorf("bad %s in io: %v", kv[0], err)
+		}
+		*v = n
+	}
+	return pio, scanner.Err()
+}
+
+// IOUsage is storage IO of a process along with its rates in bytes per second
+// since the previous sample.
+type IOUsage struct {
+	ProcIO
+	ReadRate  float64 `json:"read_rate"`
+	WriteRate float64 `json:"write_rate"`
+}
+
+// newIOUsage computes rates between two samples taken elapsed apart. Without
+// a previous sample rates are NaN.
+func newIOUsage(prev *ProcIO, cur ProcIO, elapsed time.Duration) *IOUsage {
+	var u = &IOUsage{ProcIO: cur, ReadRate: math.NaN(), WriteRate: math.NaN()}
+	secs := elapsed.Seconds()
+	if prev == nil || secs <= 0 {
+		return u
+	}
+	if cur.ReadBytes >= prev.ReadBytes {
+		u.ReadRate = float64(cur.ReadBytes-prev.ReadBytes) / secs
+	}
+	if cur.WriteBytes >= prev.WriteBytes {
+		u.WriteRate = float64(cur.WriteBytes-prev.WriteBytes) / secs
+	}
+	return u
+}
+
+// Rate is the combined read and write rate.
+func (u IOUsage) Rate() float64 {
+	return u.ReadRate + u.WriteRate
+}
+
+func (u IOUsage) safe() *IOUsage {
+	if math.IsNaN(u.ReadRate) {
+		u.ReadRate = -1
+	}
+	if math.IsNaN(u.WriteRate) {
+		u.WriteRate = -1
+	}
+	return &u
+}
+
+// String returns IO usage in Prometheus format with given labels.
+func (u IOUsage) String(labels string) string {
+	return fmt.Sprintf("bro_io_read_bytes{%s} %d\nbro_io_write_bytes{%s} %d\n",
+		labels, u.ReadBytes, labels, u.WriteBytes)
+}
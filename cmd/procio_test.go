@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const procIOSample = `rchar: 323934931
+wchar: 323929600
+syscr: 632687
+syscw: 632675
+read_bytes: 4096
+write_bytes: 8192
+cancelled_write_bytes: 0
+`
+
+func Test_parseProcIO(t *testing.T) {
+	got, err := parseProcIO([]byte(procIOSample))
+	if err != nil {
+		t.Fatalf("parseProcIO() error = %v", err)
+	}
+	want := ProcIO{RChar: 323934931, WChar: 323929600, ReadBytes: 4096, WriteBytes: 8192}
+	if got != want {
+		t.Errorf("parseProcIO() = %+v, want %+v", got, want)
+	}
+	if _, err := parseProcIO([]byte("read_bytes: lots\n")); err == nil {
+		t.Errorf("parseProcIO() expected error for bad value")
+	}
+}
+
+func Test_newIOUsage(t *testing.T) {
+	prev := ProcIO{ReadBytes: 1000, WriteBytes: 1000}
+	cur := ProcIO{ReadBytes: 5000, WriteBytes: 3000}
+	got := newIOUsage(&prev, cur, 2*time.Second)
+	if got.ReadRate != 2000 || got.WriteRate != 1000 || got.Rate() != 3000 {
+		t.Errorf("newIOUsage() = %+v, want 2000 and 1000 bytes per second", got)
+	}
+	got = newIOUsage(nil, cur, time.Second)
+	if !math.IsNaN(got.ReadRate) || !math.IsNaN(got.WriteRate) {
+		t.Errorf("newIOUsage() without previous sample = %+v, want NaNs", got)
+	}
+	if safe := got.safe(); safe.ReadRate != -1 || safe.WriteRate != -1 {
+		t.Errorf("IOUsage.safe() = %+v, want -1s", safe)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PressureInterval is how often we sample system-wide pressure.
+const PressureInterval = time.Second
+
+// pressureResources are resources for which the kernel reports pressure, both
+// system-wide under /proc/pressure and per cgroup as <resource>.pressure.
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// PressureLine is one line of a PSI file. Averages are percentages of time
+// over last 10, 60 and 300 seconds during which tasks were stalled. Total is
+// cumulative stall time in microseconds, and TotalDelta is stall time since
+// previous sample.
+type PressureLine struct {
+	Avg10      float64 `json:"avg10"`
+	Avg60      float64 `json:"avg60"`
+	Avg300     float64 `json:"avg300"`
+	Total      uint64  `json:"total_usec"`
+	TotalDelta uint64  `json:"total_delta_usec"`
+}
+
+// Pressure is contents of a single PSI file. "some" means at least one task
+// was stalled, "full" means all non-idle tasks were stalled at once. Full is
+// missing for system-wide cpu on older kernels.
+type Pressure struct {
+	Some *PressureLine `json:"some,omitempty"`
+	Full *PressureLine `json:"full,omitempty"`
+}
+
+func parsePressure(data []byte) (*Pressure, error) {
+	var p = &Pressure{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var line = &PressureLine{}
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("unexpected pressure field %q", kv)
+			}
+			var err error
+			switch parts[0] {
+			case "avg10":
+				line.Avg10, err = strconv.ParseFloat(parts[1], 64)
+			case "avg60":
+				line.Avg60, err = strconv.ParseFloat(parts[1], 64)
+			case "avg300":
+				line.Avg300, err = strconv.ParseFloat(parts[1], 64)
+			case "total":
+				line.Total, err = strconv.ParseUint(parts[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("bad pressure %s: %v", parts[0], err)
+			}
+		}
+		switch fields[0] {
+		case "some":
+			p.Some = line
+		case "full":
+			p.Full = line
+		}
+	}
+	return p, scanner.Err()
+}
+
+// PressureStats is pressure of every resource, keyed by resource name.
+type PressureStats map[string]*Pressure
+
+// readPressureStats reads PSI files from dir, with filenames made of resource
+// name and suffix, which is empty under /proc/pressure and ".pressure" in a
+// cgroup directory. Resources without a PSI file are left out, and so are all
+// of them on kernels booted with PSI disabled, where the files are there, but
+// reading them fails with EOPNOTSUPP.
+func readPressureStats(dir, suffix string) (PressureStats, error) {
+	var ps = make(PressureStats)
+	for _, res := range pressureResources {
+		data, err := ReadFileNoStat(filepath.Join(dir, res+suffix))
+		if err != nil {
+			if os.IsNotExist(err) || errors.Is(err, syscall.EOPNOTSUPP) {
+				continue
+			}
+			return nil, err
+		}
+		p, err := parsePressure(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s pressure: %v", res, err)
+		}
+		ps[res] = p
+	}
+	return ps, nil
+}
+
+// updateDeltas computes stall time since prev for every line present in both.
+func (ps PressureStats) updateDeltas(prev PressureStats) {
+	var delta = func(cur, prev *PressureLine) {
+		if cur != nil && prev != nil && cur.Total >= prev.Total {
+			cur.TotalDelta = cur.Total - prev.Total
+		}
+	}
+	for res, p := range ps {
+		if old, ok := prev[res]; ok {
+			delta(p.Some, old.Some)
+			delta(p.Full, old.Full)
+		}
+	}
+}
+
+// String returns pressure in Prometheus format, with extra labels, if any,
+// added to resource and kind labels.
+func (ps PressureStats) String(prefix, labels string) string {
+	var b strings.Builder
+	if labels != "" {
+		labels += ","
+	}
+	for _, res := range pressureResources {
+		p, ok := ps[res]
+		if !ok {
+			continue
+		}
+		for _, kl := range []struct {
+			kind string
+			line *PressureLine
+		}{{"some", p.Some}, {"full", p.Full}} {
+			if kl.line == nil {
+				continue
+			}
+			l := fmt.Sprintf("%sresource=\"%s\",kind=\"%s\"", labels, res, kl.kind)
+			fmt.Fprintf(&b, "%s_pressure_avg10{%s} %g\n", prefix, l, kl.line.Avg10)
+			fmt.Fprintf(&b, "%s_pressure_avg60{%s} %g\n", prefix, l, kl.line.Avg60)
+			fmt.Fprintf(&b, "%s_pressure_avg300{%s} %g\n", prefix, l, kl.line.Avg300)
+			fmt.Fprintf(&b, "%s_pressure_stall_usec_total{%s} %d\n", prefix, l, kl.line.Total)
+		}
+	}
+	return b.String()
+}
+
+// SystemPressure keeps the most recent sample of system-wide pressure.
+type SystemPressure struct {
+	dir   string
+	stats PressureStats
+	mtx   sync.RWMutex
+}
+
+// NewSystemPressure returns a collector of PSI files found in dir, which is
+// normally /proc/pressure.
+func NewSystemPressure(dir string) *SystemPressure {
+	return &SystemPressure{dir: dir, stats: make(PressureStats)}
+}
+
+// Collect reads PSI files and replaces previous sample.
+func (sp *SystemPressure) Collect() error {
+	ps, err := readPressureStats(sp.dir, "")
+	if err != nil {
+		return err
+	}
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	ps.updateDeltas(sp.stats)
+	sp.stats = ps
+	return nil
+}
+
+// Stats returns the most recent sample.
+func (sp *SystemPressure) Stats() PressureStats {
+	sp.mtx.RLock()
+	defer sp.mtx.RUnlock()
+	return sp.stats
+}
+
+// ToJSON returns serialized most recent sample.
+func (sp *SystemPressure) ToJSON() ([]byte, error) {
+	return json.Marshal(sp.Stats())
+}
+
+func (sp *SystemPressure) String() string {
+	return sp.Stats().String("bro_host", "")
+}
+
+// startPressureCollector samples system-wide pressure until context is
+// cancelled. Kernels without PSI do not have /proc/pressure at all, in which
+// case we give up right away.
+func startPressureCollector(ctx context.Context, sp *SystemPressure) {
+	if _, err := os.Stat(sp.dir); err != nil {
+		handleErr(err, false)
+		return
+	}
+	tick := time.NewTicker(PressureInterval)
+	defer tick.Stop()
+	for {
+		if err := sp.Collect(); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
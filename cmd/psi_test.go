@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parsePressure(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *Pressure
+		wantErr bool
+	}{
+		{name: "memory with some and full",
+			data: "some avg10=1.53 avg60=0.87 avg300=0.22 total=12345678\nfull avg10=0.50 avg60=0.25 avg300=0.05 total=2345678\n",
+			want: &Pressure{
+				Some: &PressureLine{Avg10: 1.53, Avg60: 0.87, Avg300: 0.22, Total: 12345678},
+				Full: &PressureLine{Avg10: 0.5, Avg60: 0.25, Avg300: 0.05, Total: 2345678},
+			}},
+		{name: "cpu on older kernel",
+			data: "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+			want: &Pressure{Some: &PressureLine{}}},
+		{name: "garbage", data: "some avg10=lots\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePressure([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePressure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePressure() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemPressure_Collect(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string]string{
+		"cpu":    "some avg10=5.00 avg60=4.00 avg300=3.00 total=1000000\n",
+		"memory": "some avg10=0.00 avg60=0.00 avg300=0.00 total=500\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=100\n",
+	})
+	sp := NewSystemPressure(dir)
+	if err := sp.Collect(); err != nil {
+		t.Fatalf("SystemPressure.Collect() error = %v", err)
+	}
+	if _, ok := sp.Stats()["io"]; ok {
+		t.Errorf("SystemPressure.Stats() must leave out resources without a PSI file")
+	}
+
+	writeFixture(t, dir, map[string]string{
+		"cpu":    "some avg10=6.00 avg60=4.20 avg300=3.01 total=1250000\n",
+		"memory": "some avg10=0.10 avg60=0.00 avg300=0.00 total=900\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=300\n",
+	})
+	if err := sp.Collect(); err != nil {
+		t.Fatalf("SystemPressure.Collect() error = %v", err)
+	}
+	ps := sp.Stats()
+	if got := ps["cpu"].Some.TotalDelta; got != 250000 {
+		t.Errorf("cpu some TotalDelta = %d, want 250000", got)
+	}
+	if got := ps["memory"].Full.TotalDelta; got != 200 {
+		t.Errorf("memory full TotalDelta = %d, want 200", got)
+	}
+	out := sp.String()
+	if !strings.Contains(out, `bro_host_pressure_avg10{resource="cpu",kind="some"} 6`) {
+		t.Errorf("SystemPressure.String() = %q, missing cpu avg10", out)
+	}
+}
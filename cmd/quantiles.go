@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sketchSlices is how many slices a window is divided into. Values are
+// sketched into the slice for the current time, and a window is the merge of
+// its slices, so a window ends up to one slice longer than asked for.
+const sketchSlices = 12
+
+// reportedQuantiles are quantiles we report of every window.
+var reportedQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Names of series we estimate quantiles of.
+const (
+	quantileCurrentRate = "current_rate"
+	quantileRSSBytes    = "rss_bytes"
+	quantileIORate      = "io_rate"
+)
+
+// durations is a list of durations given on command line as 5m,1h.
+type durations []time.Duration
+
+func (d *durations) String() string {
+	var l []string
+	for _, v := range *d {
+		l = append(l, v.String())
+	}
+	return strings.Join(l, ",")
+}
+
+// Set replaces defaults with the given list, rather than appending to them.
+func (d *durations) Set(v string) error {
+	var l durations
+	for _, s := range strings.Split(v, ",") {
+		dur, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		if dur <= 0 {
+			return fmt.Errorf("duration must be positive, got %s", s)
+		}
+		l = append(l, dur)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	*d = l
+	return nil
+}
+
+// sketchSlice is a sketch of values observed from start on.
+type sketchSlice struct {
+	start  time.Time
+	sketch *DDSketch
+}
+
+// WindowedSketch estimates quantiles of values observed over a sliding window.
+type WindowedSketch struct {
+	window time.Duration
+	slice  time.Duration
+	slices []sketchSlice
+}
+
+// NewWindowedSketch returns a sketch over window.
+func NewWindowedSketch(window time.Duration) *WindowedSketch {
+	return &WindowedSketch{window: window, slice: window / sketchSlices}
+}
+
+// Insert adds value observed at time now.
+func (ws *WindowedSketch) Insert(v float64, now time.Time) {
+	ws.expire(now)
+	n := len(ws.slices)
+	if n == 0 || now.Sub(ws.slices[n-1].start) >= ws.slice {
+		ws.slices = append(ws.slices, sketchSlice{
+			start:  now.Truncate(ws.slice),
+			sketch: NewDDSketch(defaultSketchAccuracy),
+		})
+		n++
+	}
+	ws.slices[n-1].sketch.Insert(v)
+}
+
+// expire drops slices which ended before the window starting at now.
+func (ws *WindowedSketch) expire(now time.Time) {
+	var cutoff = now.Add(-ws.window)
+	var i int
+	for i < len(ws.slices) && ws.slices[i].start.Add(ws.slice).Before(cutoff) {
+		i++
+	}
+	ws.slices = ws.slices[i:]
+}
+
+// Sketch returns a merge of all slices within the window as of now.
+func (ws *WindowedSketch) Sketch(now time.Time) *DDSketch {
+	ws.expire(now)
+	var merged = NewDDSketch(defaultSketchAccuracy)
+	for _, s := range ws.slices {
+		merged.Merge(s.sketch)
+	}
+	return merged
+}
+
+// QuantileSummary is a summary of a series over a window.
+type QuantileSummary struct {
+	Window time.Duration `json:"window"`
+	Count  uint64        `json:"count"`
+	Sum    float64       `json:"sum"`
+	P50    float64       `json:"p50"`
+	P90    float64       `json:"p90"`
+	P99    float64       `json:"p99"`
+	Max    float64       `json:"max"`
+}
+
+func newQuantileSummary(window time.Duration, s *DDSketch) QuantileSummary {
+	return QuantileSummary{
+		Window: window,
+		Count:  s.Count(),
+		Sum:    s.Sum(),
+		P50:    s.Quantile(0.5),
+		P90:    s.Quantile(0.9),
+		P99:    s.Quantile(0.99),
+		Max:    s.Max(),
+	}
+}
+
+func (qs QuantileSummary) safe() QuantileSummary {
+	for _, v := range []*float64{&qs.P50, &qs.P90, &qs.P99, &qs.Max} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return qs
+}
+
+// String returns summary in Prometheus format as a summary named name, with
+// given labels.
+func (qs QuantileSummary) String(name, labels string) string {
+	var b strings.Builder
+	labels += fmt.Sprintf(",window=\"%s\"", qs.Window)
+	for i, v := range []float64{qs.P50, qs.P90, qs.P99} {
+		fmt.Fprintf(&b, "%s{%s,quantile=\"%g\"} %g\n", name, labels, reportedQuantiles[i], v)
+	}
+	fmt.Fprintf(&b, "%s_max{%s} %g\n", name, labels, qs.Max)
+	fmt.Fprintf(&b, "%s_sum{%s} %g\n", name, labels, qs.Sum)
+	fmt.Fprintf(&b, "%s_count{%s} %d\n", name, labels, qs.Count)
+	return b.String()
+}
+
+// QuantileTracker keeps windowed sketches of several series of every role.
+// Every instance of a role feeds the same sketches.
+type QuantileTracker struct {
+	windows  durations
+	sketches map[string]map[string][]*WindowedSketch
+	mtx      sync.Mutex
+}
+
+// NewQuantileTracker returns a tracker estimating quantiles over windows.
+func NewQuantileTracker(windows durations) *QuantileTracker {
+	return &QuantileTracker{
+		windows:  windows,
+		sketches: make(map[string]map[string][]*WindowedSketch),
+	}
+}
+
+// Observe adds value of a series of role observed at time now.
+func (t *QuantileTracker) Observe(role, series string, v float64, now time.Time) {
+	if math.IsNaN(v) {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bySeries, ok := t.sketches[role]
+	if !ok {
+		bySeries = make(map[string][]*WindowedSketch)
+		t.sketches[role] = bySeries
+	}
+	l, ok := bySeries[series]
+	if !ok {
+		for _, w := range t.windows {
+			l = append(l, NewWindowedSketch(w))
+		}
+		bySeries[series] = l
+	}
+	for _, ws := range l {
+		ws.Insert(v, now)
+	}
+}
+
+// ObserveReport adds every series we estimate quantiles of from a report.
+func (t *QuantileTracker) ObserveReport(r *IntervalReport) {
+	t.Observe(r.Role, quantileCurrentRate, r.CurrentRate, r.Timestamp)
+	t.Observe(r.Role, quantileRSSBytes, float64(r.RSSBytes), r.Timestamp)
+	if r.IO != nil {
+		t.Observe(r.Role, quantileIORate, r.IO.Rate(), r.Timestamp)
+	}
+}
+
+// Summaries returns summaries of every series of a role over each window as
+// of now, nil if nothing was observed for the role.
+func (t *QuantileTracker) Summaries(role string, now time.Time) map[string][]QuantileSummary {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bySeries, ok := t.sketches[role]
+	if !ok {
+		return nil
+	}
+	var m = make(map[string][]QuantileSummary, len(bySeries))
+	for series, l := range bySeries {
+		for _, ws := range l {
+			m[series] = append(m[series], newQuantileSummary(ws.window, ws.Sketch(now)))
+		}
+	}
+	return m
+}
+
+// safeQuantiles converts any NaNs in summaries to -1's.
+func safeQuantiles(m map[string][]QuantileSummary) map[string][]QuantileSummary {
+	if m == nil {
+		return nil
+	}
+	var safe = make(map[string][]QuantileSummary, len(m))
+	for series, l := range m {
+		for _, qs := range l {
+			safe[series] = append(safe[series], qs.safe())
+		}
+	}
+	return safe
+}
+
+// quantilesString returns summaries of a role in Prometheus format.
+func quantilesString(role string, m map[string][]QuantileSummary) string {
+	var b strings.Builder
+	var names []string
+	for series := range m {
+		names = append(names, series)
+	}
+	sort.Strings(names)
+	labels := fmt.Sprintf("role=\"%s\"", role)
+	for _, series := range names {
+		for _, qs := range m[series] {
+			b.WriteString(qs.String("bro_role_"+series, labels))
+		}
+	}
+	return b.String()
+}
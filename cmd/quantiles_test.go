@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_durations_Set(t *testing.T) {
+	var d = append(durations(nil), defaultQuantileWindows...)
+	if err := d.Set("1h,5m"); err != nil {
+		t.Fatalf("durations.Set() error = %v", err)
+	}
+	if want := (durations{5 * time.Minute, time.Hour}); !reflect.DeepEqual(d, want) {
+		t.Errorf("durations.Set() = %v, want %v", d, want)
+	}
+	for _, bad := range []string{"1h,", "-5m", "0s", "hour"} {
+		if err := d.Set(bad); err == nil {
+			t.Errorf("durations.Set(%q) expected error", bad)
+		}
+	}
+}
+
+func TestWindowedSketch(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	ws := NewWindowedSketch(time.Hour)
+	// An hour of high rates, followed by an hour of low ones.
+	for i := 0; i < 3600; i++ {
+		ws.Insert(0.9, start.Add(time.Duration(i)*time.Second))
+	}
+	for i := 3600; i < 7200; i++ {
+		ws.Insert(0.1, start.Add(time.Duration(i)*time.Second))
+	}
+	now := start.Add(7200 * time.Second)
+	s := ws.Sketch(now)
+	if got := s.Quantile(0.5); !tolerance(got, 0.1, 0.01*0.1) {
+		t.Errorf("WindowedSketch p50 = %v, want 0.1", got)
+	}
+	// Window may be up to a slice longer than asked for.
+	if s.Count() < 3600 || s.Count() > 3600+3600/sketchSlices {
+		t.Errorf("WindowedSketch count = %d, want about 3600", s.Count())
+	}
+	if got := ws.Sketch(now.Add(2 * time.Hour)).Count(); got != 0 {
+		t.Errorf("WindowedSketch count = %d after window passed, want 0", got)
+	}
+}
+
+func TestQuantileTracker(t *testing.T) {
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	qt := NewQuantileTracker(durations{time.Minute, time.Hour})
+	for i := 0; i < 100; i++ {
+		at := now.Add(time.Duration(i-100) * time.Second)
+		qt.ObserveReport(&IntervalReport{Role: "worker-1", CurrentRate: float64(i) / 100, RSSBytes: 1 << 20, Timestamp: at})
+	}
+	if got := qt.Summaries("manager", now); got != nil {
+		t.Errorf("QuantileTracker.Summaries() = %v, want nil for unknown role", got)
+	}
+	got := qt.Summaries("worker-1", now)
+	rates := got[quantileCurrentRate]
+	if len(rates) != 2 || rates[0].Window != time.Minute || rates[1].Window != time.Hour {
+		t.Fatalf("QuantileTracker.Summaries() = %+v, want one summary per window", rates)
+	}
+	if rates[1].Count != 100 || !tolerance(rates[1].P99, 0.98, 0.01) || rates[1].Max != 0.99 {
+		t.Errorf("QuantileTracker.Summaries() hour = %+v, want 100 values with p99 0.98", rates[1])
+	}
+	if rates[0].Count >= 100 || rates[0].P50 < 0.4 {
+		t.Errorf("QuantileTracker.Summaries() minute = %+v, want only recent values", rates[0])
+	}
+	if _, ok := got[quantileIORate]; ok {
+		t.Errorf("QuantileTracker.Summaries() has io_rate, which was never observed")
+	}
+	out := quantilesString("worker-1", got)
+	for _, want := range []string{
+		`bro_role_current_rate{role="worker-1",window="1h0m0s",quantile="0.99"} `,
+		`bro_role_current_rate_count{role="worker-1",window="1h0m0s"} 100`,
+		`bro_role_rss_bytes_max{role="worker-1",window="1m0s"} 1.048576e+06`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("quantilesString() is missing %s:\n%s", want, out)
+		}
+	}
+}
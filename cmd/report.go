@@ -1,49 +1,142 @@
 package main
 
 import (
-	"errors"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
 // IntervalReport is a point in time view of process' CPU usage with three
 // figures, WindowRate, LifeTimeRate and CurrentRate.
-// WindowRate - an average of samples over several intervals, which effectively
-// makes the data smoother.
-// StandardDev - standard deviation for samples in this window.
+// WindowRate - rate of time spent on CPU over the shortest of windows of
+// wall-clock time, computed from CPU time and run time at both ends of it.
+// StandardDev - standard deviation of per-interval rates in this window.
+// LifetimeRateAverage - an average of LifetimeRate samples over the same
+// window, which is what WindowRate used to be.
+// RateEWMA - moving averages of CurrentRate akin to load averages.
 // LifeTimeRate - rate of time spent on CPU over total process' runtime,
 // computed over the entire lifetime of process; least volatile.
 // CurrentRate - derivative between two interval samples; most volatile.
 type IntervalReport struct {
-	PID             int              `json:"pid"`
-	Role            string           `json:"role"`
-	InitTimestamp   time.Time        `json:"first_seen"`
-	Timestamp       time.Time        `json:"last_seen"`
-	Age             time.Duration    `json:"age"`
-	WindowRate      float64          `json:"window_rate"`
-	StandardDev     float64          `json:"standard_dev"`
-	LifetimeRate    float64          `json:"lifetime_rate"`
-	CurrentRate     float64          `json:"current_rate"`
-	TimesRestated   uint64           `json:"times_restarted"`
-	VirtMemoryBytes uint             `json:"virtual_memory_bytes"`
-	RSSBytes        int              `json:"rss_bytes"`
-	RateHistogram   map[string]int64 `json:"rate_histogram"`
+	PID                 int           `json:"pid"`
+	Role                string        `json:"role"`
+	Starttime           uint64        `json:"starttime"`
+	InitTimestamp       time.Time     `json:"first_seen"`
+	Timestamp           time.Time     `json:"last_seen"`
+	Age                 time.Duration `json:"age"`
+	WindowRate          float64       `json:"window_rate"`
+	StandardDev         float64       `json:"standard_dev"`
+	LifetimeRateAverage float64       `json:"lifetime_rate_average"`
+	LifetimeRate        float64       `json:"lifetime_rate"`
+	// CPUSource is where CPU times behind rates come from, ticks or
+	// schedstat, see cpuTimes.
+	CPUSource       string  `json:"cpu_source"`
+	CurrentRate     float64 `json:"current_rate"`
+	TimesRestated   uint64  `json:"times_restarted"`
+	TimesExeced     uint64  `json:"times_execed"`
+	VirtMemoryBytes uint    `json:"virtual_memory_bytes"`
+	RSSBytes        int     `json:"rss_bytes"`
+	// PSSBytes is proportional set size, read every PSSInterval, -1 when
+	// the kernel does not give it to us.
+	PSSBytes int `json:"pss_bytes"`
+	// RateHistogram is CPU rate in buckets of NewHist, whatever layout of
+	// cpu_rate is configured for Histograms.
+	RateHistogram map[string]int64 `json:"rate_histogram"`
+	// RateEWMA are moving averages of CurrentRate over 1, 5 and 15 minutes.
+	RateEWMA *RateEWMAs `json:"rate_ewma,omitempty"`
+	// Windows are statistics over each of the windows of wall-clock time,
+	// shortest first, see SampleSeries.
+	Windows []WindowStats `json:"windows,omitempty"`
+	// Histograms are histograms of CPU rate, RSS growth and IO rate, in
+	// cumulative and non-cumulative views, see defaultHistLayouts.
+	Histograms map[string]HistogramView `json:"histograms,omitempty"`
+	// Delays are delay accounting figures from taskstats, see TaskDelays.
+	Delays *TaskDelays `json:"delays,omitempty"`
+	// IO is storage IO of the process.
+	IO *IOUsage `json:"io,omitempty"`
+	// HostCPU puts CurrentRate in context of CPUs the process may run on.
+	HostCPU *HostCPUContext `json:"host_cpu,omitempty"`
+	// Affinity tells where threads of the process run and whether this is
+	// where they are meant to run.
+	Affinity *AffinityReport `json:"affinity,omitempty"`
+	// Cgroup has resource figures of the cgroup this process belongs to.
+	Cgroup *CgroupStats `json:"cgroup,omitempty"`
+	// Resources is use of file descriptors and other limited resources.
+	Resources *ResourceUsage `json:"resources,omitempty"`
+	// Sockets is inventory of sockets the process has open.
+	Sockets *SocketReport `json:"sockets,omitempty"`
+	// Interface is the interface this process captures traffic from, so that
+	// drops can be read right next to CPU rates.
+	Interface *InterfaceStats `json:"capture_interface,omitempty"`
+	// Suricata are totals of Suricata's own counters, only set on reports of
+	// Suricata processes.
+	Suricata *SuricataCounters `json:"suricata,omitempty"`
+	// ReporterLevels are counts of reporter.log messages of this role by
+	// level, see ZeekReporter.
+	ReporterLevels map[string]uint64 `json:"reporter_levels,omitempty"`
+	// Retired is set on a report which does not carry any data, but tells us
+	// that the instance it refers to is no longer monitored.
+	Retired bool `json:"-"`
+}
+
+// Key returns the instance key of process this report is about.
+func (i IntervalReport) Key() string {
+	return instanceKeyString(i.Role, i.PID)
 }
 
 func (i IntervalReport) String() string {
 
-	role := i.Role
-	pid := fmt.Sprintf("bro_pid{role=\"%s\"} %d", role, i.PID)
-	first_seen := fmt.Sprintf("bro_process_start_seconds{role=\"%s\"} %d", role, i.Timestamp.Unix() )
-	age := fmt.Sprintf("bro_process_age_seconds{role=\"%s\"} %d", role, int(i.Age.Seconds()))
-	vmem := fmt.Sprintf("bro_virtual_memory_bytes{role=\"%s\"} %d", role, i.VirtMemoryBytes)
+	labels := fmt.Sprintf("role=\"%s\",pid=\"%d\"", i.Role, i.PID)
+	pid := fmt.Sprintf("bro_pid{%s} %d", labels, i.PID)
+	first_seen := fmt.Sprintf("bro_process_start_seconds{%s} %d", labels, i.Timestamp.Unix())
+	age := fmt.Sprintf("bro_process_age_seconds{%s} %d", labels, int(i.Age.Seconds()))
+	vmem := fmt.Sprintf("bro_virtual_memory_bytes{%s} %d", labels, i.VirtMemoryBytes)
 
-	return pid + "\n" + first_seen + "\n" + age + "\n" + vmem + "\n"
+	out := pid + "\n" + first_seen + "\n" + age + "\n" + vmem + "\n"
+	if i.RateEWMA != nil {
+		out += i.RateEWMA.String(labels)
+	}
+	if i.HostCPU != nil {
+		out += fmt.Sprintf("bro_cpu_share_of_allowed{%s} %g\n", labels, i.HostCPU.ShareOfAllowed)
+		out += fmt.Sprintf("bro_allowed_cpus_steal{%s} %g\n", labels, i.HostCPU.Allowed.Steal)
+	}
+	if i.Affinity != nil {
+		out += fmt.Sprintf("bro_affinity_violations{%s} %d\n", labels, len(i.Affinity.Violations))
+		out += fmt.Sprintf("bro_thread_migrations{%s} %d\n", labels, i.Affinity.Migrations)
+	}
+	if i.Cgroup != nil {
+		out += i.Cgroup.String(labels)
+	}
+	if i.Resources != nil {
+		out += i.Resources.String(labels)
+	}
+	if i.Sockets != nil {
+		out += i.Sockets.String(labels)
+	}
+	if i.Interface != nil {
+		out += i.Interface.String(labels)
+	}
+	if i.IO != nil {
+		out += i.IO.String(labels)
+	}
+	if i.Delays != nil {
+		out += i.Delays.String(labels)
+	}
+	var names []string
+	for name := range i.Histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out += i.Histograms[name].String(name, labels)
+	}
+	return out
 }
 
 func startIntervalReport(c <-chan *IntervalReport) {
@@ -56,7 +149,13 @@ func startIntervalReport(c <-chan *IntervalReport) {
 				return
 			}
 			metricsReport.Insert(v)
-		case <-tick.C:
+			if quantiles != nil && !v.Retired {
+				quantiles.ObserveReport(v)
+			}
+		case now := <-tick.C:
+			metricsReport.ObserveImbalance(now)
+			metricsReport.ObserveAnomalies(now)
+			metricsReport.ObserveMemoryTrends(now)
 			if !metricsReport.Empty() {
 				data, err := metricsReport.ToJSON()
 				if err != nil {
@@ -71,18 +170,107 @@ func startIntervalReport(c <-chan *IntervalReport) {
 	}
 }
 
+// RoleSummary is an aggregate view of all instances sharing a role. Rates and
+// memory figures are sums over instances, so that a role backed by several
+// processes is comparable with the same role backed by a single process.
+type RoleSummary struct {
+	Role            string            `json:"role"`
+	NumInstances    int               `json:"num_instances"`
+	WindowRate      float64           `json:"window_rate"`
+	LifetimeRate    float64           `json:"lifetime_rate"`
+	CurrentRate     float64           `json:"current_rate"`
+	TimesRestated   uint64            `json:"times_restarted"`
+	TimesExeced     uint64            `json:"times_execed"`
+	VirtMemoryBytes uint              `json:"virtual_memory_bytes"`
+	RSSBytes        int               `json:"rss_bytes"`
+	Instances       []*IntervalReport `json:"instances"`
+	// Zeek is Zeek's own view of this node, taken from its logs.
+	Zeek *ZeekPeerStats `json:"zeek,omitempty"`
+	// Sockets are sockets of all instances of this role, see RoleSockets.
+	Sockets *RoleSockets `json:"sockets,omitempty"`
+	// Delays are delay accounting figures summed over instances of this
+	// role, with the oldest taskstats version among them, see TaskDelays.
+	Delays *TaskDelays `json:"delays,omitempty"`
+	// Quantiles are summaries of CPU rate and other series of all instances
+	// of this role over each of the quantile windows, see QuantileTracker.
+	Quantiles map[string][]QuantileSummary `json:"quantiles,omitempty"`
+	// Anomalies are scores of the role's CPU rate and RSS against their own
+	// history, see AnomalyTracker.
+	Anomalies map[string]*AnomalyScore `json:"anomalies,omitempty"`
+	// MemoryTrends are growth of the role's RSS and PSS over each of the
+	// trend horizons, see MemoryTrendTracker.
+	MemoryTrends map[string][]MemoryTrend `json:"memory_trends,omitempty"`
+}
+
+func (rs RoleSummary) String() string {
+	role := rs.Role
+	instances := fmt.Sprintf("bro_role_instances{role=\"%s\"} %d", role, rs.NumInstances)
+	restarts := fmt.Sprintf("bro_role_times_restarted{role=\"%s\"} %d", role, rs.TimesRestated)
+	rss := fmt.Sprintf("bro_role_rss_bytes{role=\"%s\"} %d", role, rs.RSSBytes)
+
+	out := instances + "\n" + restarts + "\n" + rss + "\n"
+	if rs.Zeek != nil {
+		out += rs.Zeek.String(fmt.Sprintf("role=\"%s\"", role))
+	}
+	if rs.Sockets != nil {
+		out += rs.Sockets.String(role)
+	}
+	if rs.Delays != nil {
+		out += rs.Delays.metrics("bro_role_task", fmt.Sprintf("role=\"%s\"", role))
+	}
+	out += quantilesString(role, rs.Quantiles)
+	out += anomaliesString(role, rs.Anomalies)
+	out += memoryTrendsString(role, rs.MemoryTrends)
+	return out
+}
+
+// newRoleSummary aggregates given reports, all of which are expected to be for
+// the same role. NaNs are skipped in the sums, same as they are in averages.
+func newRoleSummary(role string, reps []*IntervalReport) *RoleSummary {
+	var windowRates, lifetimeRates, currentRates = make([]float64, len(reps)),
+		make([]float64, len(reps)), make([]float64, len(reps))
+	rs := &RoleSummary{
+		Role:         role,
+		NumInstances: len(reps),
+		Instances:    reps,
+	}
+	for i, rep := range reps {
+		windowRates[i] = rep.WindowRate
+		lifetimeRates[i] = rep.LifetimeRate
+		currentRates[i] = rep.CurrentRate
+		rs.TimesRestated += rep.TimesRestated
+		rs.TimesExeced += rep.TimesExeced
+		rs.VirtMemoryBytes += rep.VirtMemoryBytes
+		rs.RSSBytes += rep.RSSBytes
+	}
+	rs.WindowRate = sum(windowRates)
+	rs.LifetimeRate = sum(lifetimeRates)
+	rs.CurrentRate = sum(currentRates)
+	rs.Sockets = newRoleSockets(reps)
+	rs.Delays = newRoleDelays(reps)
+	return rs
+}
+
 // Summaries is used as a global singleton to keep track of running
-// statistics for processes being monitored.
+// statistics for processes being monitored. Reports are kept per instance,
+// and keyed by role/pid, see InstanceKey. Exported methods take the lock once,
+// unexported ones expect their caller to hold it, because a pending writer
+// blocks further readers and read locks cannot be taken recursively.
 type Summaries struct {
 	m   map[string]*IntervalReport
 	mtx sync.RWMutex
 }
 
-// Insert updates the map with latest interval report.
+// Insert updates the map with latest interval report. A retired report removes
+// the instance it refers to instead.
 func (s *Summaries) Insert(r *IntervalReport) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
-	s.m[r.Role] = r
+	if r.Retired {
+		delete(s.m, r.Key())
+		return
+	}
+	s.m[r.Key()] = r
 }
 
 // Len returns number of available summaries.
@@ -99,41 +287,50 @@ func (s *Summaries) Empty() bool {
 	return len(s.m) == 0
 }
 
-func (s *Summaries) findRole(role string) *IntervalReport {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
-	if v, ok := s.m[role]; ok {
+func (s *Summaries) findInstance(key string) *IntervalReport {
+	if v, ok := s.m[key]; ok {
 		return v
 	}
 	return nil
 }
 
+// instancesOf returns keys of all instances of a role, sorted.
+func (s *Summaries) instancesOf(role string) []string {
+	var keys []string
+	for k, rep := range s.m {
+		if rep.Role == role {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// roles returns a sorted list of distinct roles for which we have reports.
+func (s *Summaries) roles() []string {
+	var seen = make(map[string]struct{})
+	var roles []string
+	for _, rep := range s.m {
+		if _, ok := seen[rep.Role]; !ok {
+			seen[rep.Role] = struct{}{}
+			roles = append(roles, rep.Role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
 // safeIntervalReport converts any NaNs to -1's, because JSON is brain-dead
 // and the idiots behind it apparently don't understand that NaNs, -Inf and +Inf
 // are actually a thing.
-func (s *Summaries) safeIntervalReport(role string) *IntervalReport {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
+func (s *Summaries) safeIntervalReport(key string) *IntervalReport {
 	var rep, safeRep *IntervalReport
-	if rep = s.findRole(role); rep == nil {
+	if rep = s.findInstance(key); rep == nil {
 		return nil
 	}
 
-	safeRep = &IntervalReport{
-		PID:             rep.PID,
-		Role:            rep.Role,
-		InitTimestamp:   rep.InitTimestamp,
-		Timestamp:       rep.Timestamp,
-		Age:             rep.Age,
-		WindowRate:      rep.WindowRate,
-		StandardDev:     rep.StandardDev,
-		LifetimeRate:    rep.LifetimeRate,
-		CurrentRate:     rep.CurrentRate,
-		RateHistogram:   rep.RateHistogram,
-		TimesRestated:   rep.TimesRestated,
-		VirtMemoryBytes: rep.VirtMemoryBytes,
-		RSSBytes:        rep.RSSBytes,
-	}
+	copied := *rep
+	safeRep = &copied
 
 	if math.IsNaN(safeRep.CurrentRate) {
 		safeRep.CurrentRate = -1
@@ -147,18 +344,108 @@ func (s *Summaries) safeIntervalReport(role string) *IntervalReport {
 	if math.IsNaN(safeRep.WindowRate) {
 		safeRep.WindowRate = -1
 	}
+	if math.IsNaN(safeRep.LifetimeRateAverage) {
+		safeRep.LifetimeRateAverage = -1
+	}
+	if safeRep.RateEWMA != nil {
+		safeRep.RateEWMA = safeRep.RateEWMA.safe()
+	}
+	if safeRep.HostCPU != nil {
+		safeRep.HostCPU = safeRep.HostCPU.safe()
+	}
+	if safeRep.Cgroup != nil {
+		safeRep.Cgroup = safeRep.Cgroup.safe()
+	}
+	if safeRep.Resources != nil {
+		safeRep.Resources = safeRep.Resources.safe()
+	}
+	if safeRep.Windows != nil {
+		var windows = make([]WindowStats, len(safeRep.Windows))
+		for i, w := range safeRep.Windows {
+			windows[i] = w.safe()
+		}
+		safeRep.Windows = windows
+	}
+	if safeRep.IO != nil {
+		safeRep.IO = safeRep.IO.safe()
+	}
+	if safeRep.Delays != nil {
+		safeRep.Delays = safeRep.Delays.safe()
+	}
+	if safeRep.Interface != nil {
+		safeRep.Interface = safeRep.Interface.safe()
+	}
 	return safeRep
 }
 
-// RoleToJSON returns serialized version of a single entry from interval
-// summaries map, assuming entry is found in the map.
+// roleSummary aggregates all instances of a role. Instances included in the
+// summary are JSON-safe, while the aggregate figures are computed from the
+// original reports. Nil is returned if there are no instances of this role.
+// Figures of trackers are left for withTrackers.
+func (s *Summaries) roleSummary(role string) *RoleSummary {
+	var keys = s.instancesOf(role)
+	if len(keys) == 0 {
+		return nil
+	}
+	var reps = make([]*IntervalReport, len(keys))
+	for i, k := range keys {
+		reps[i] = s.findInstance(k)
+	}
+	rs := newRoleSummary(role, reps)
+	if rs.Delays != nil {
+		rs.Delays = rs.Delays.safe()
+	}
+	rs.Instances = make([]*IntervalReport, len(keys))
+	for i, k := range keys {
+		rs.Instances[i] = s.safeIntervalReport(k)
+	}
+	if zeekLogs != nil {
+		rs.Zeek = zeekLogs.Peer(role)
+	}
+	return rs
+}
+
+// withTrackers adds quantiles, anomaly scores and memory trends of the role to
+// its summary. Fitting trends takes a while, so this is done without holding
+// the lock of Summaries.
+func withTrackers(rs *RoleSummary) *RoleSummary {
+	now := time.Now()
+	if quantiles != nil {
+		rs.Quantiles = safeQuantiles(quantiles.Summaries(rs.Role, now))
+	}
+	if anomalies != nil {
+		rs.Anomalies = anomalies.Scores(rs.Role)
+	}
+	if memoryTrends != nil {
+		rs.MemoryTrends = applyMemoryLimits(memoryTrends.Trends(rs.Role, now), rs.Instances, memoryCeiling)
+	}
+	return rs
+}
+
+// RoleToJSON returns serialized aggregate of all instances of a role, assuming
+// at least one instance of the role is found in the map.
 // Multiple concurrent readers are possible, but only one writer is allowed.
 func (s *Summaries) RoleToJSON(role string) ([]byte, error) {
+	s.mtx.RLock()
+	rs := s.roleSummary(role)
+	s.mtx.RUnlock()
+
+	if rs == nil {
+		return []byte{}, errNoInfoForRole
+	}
+
+	return json.Marshal(withTrackers(rs))
+}
+
+// InstanceToJSON returns serialized version of a single entry from interval
+// summaries map, assuming entry is found in the map.
+// Multiple concurrent readers are possible, but only one writer is allowed.
+func (s *Summaries) InstanceToJSON(role string, pid int) ([]byte, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 	var rep *IntervalReport
 
-	if rep = s.safeIntervalReport(role); rep == nil {
+	if rep = s.safeIntervalReport(instanceKeyString(role, pid)); rep == nil {
 		return []byte{}, errNoInfoForRole
 	}
 
@@ -171,8 +458,8 @@ func (s *Summaries) ToJSON() ([]byte, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 	l := make([]*IntervalReport, 0)
-	for role := range s.m {
-		l = append(l, s.safeIntervalReport(role))
+	for key := range s.m {
+		l = append(l, s.safeIntervalReport(key))
 	}
 	return json.Marshal(l)
 }
@@ -182,16 +469,31 @@ func (s *Summaries) ToJSON() ([]byte, error) {
 func (s *Summaries) All() ([]*IntervalReport, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
-	if s.Len() == 0 {
+	if len(s.m) == 0 {
 		return nil, errors.New("zero summaries currently available")
 	}
-	l := make([]*IntervalReport, s.Len())
+	l := make([]*IntervalReport, len(s.m))
 	var c = 0
-	for role := range s.m {
-		// l = append(l, s.safeIntervalReport(role))
-		l[c] = s.safeIntervalReport(role)
-		fmt.Printf("role => %v\n", role)
+	for key := range s.m {
+		l[c] = s.safeIntervalReport(key)
 		c++
 	}
 	return l, nil
 }
+
+// Roles returns aggregates for every role for which we have at least one
+// instance.
+func (s *Summaries) Roles() []*RoleSummary {
+	s.mtx.RLock()
+	var l []*RoleSummary
+	for _, role := range s.roles() {
+		if rs := s.roleSummary(role); rs != nil {
+			l = append(l, rs)
+		}
+	}
+	s.mtx.RUnlock()
+	for _, rs := range l {
+		withTrackers(rs)
+	}
+	return l
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"reflect"
 	"sync"
 	"testing"
@@ -81,13 +82,13 @@ func TestSummaries_Len(t *testing.T) {
 	}
 }
 
-func TestSummaries_findRole(t *testing.T) {
+func TestSummaries_findInstance(t *testing.T) {
 	type fields struct {
 		m   map[string]*IntervalReport
 		mtx sync.RWMutex
 	}
 	type args struct {
-		role string
+		key string
 	}
 	tests := []struct {
 		name   string
@@ -103,8 +104,8 @@ func TestSummaries_findRole(t *testing.T) {
 				m:   tt.fields.m,
 				mtx: tt.fields.mtx,
 			}
-			if got := s.findRole(tt.args.role); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Summaries.findRole() = %v, want %v", got, tt.want)
+			if got := s.findInstance(tt.args.key); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Summaries.findInstance() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -204,3 +205,43 @@ func TestSummaries_ToJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestSummaries_roleSummary(t *testing.T) {
+	s := &Summaries{m: make(map[string]*IntervalReport)}
+	for _, r := range []*IntervalReport{
+		{Role: "worker-1", PID: 100, CurrentRate: 0.5, WindowRate: 0.25, RSSBytes: 1024, TimesRestated: 1},
+		{Role: "worker-1", PID: 101, CurrentRate: 0.25, WindowRate: math.NaN(), RSSBytes: 2048},
+		{Role: "worker-2", PID: 102, CurrentRate: 0.75, RSSBytes: 4096},
+		{Role: "worker-1", PID: 99, Retired: true},
+	} {
+		s.Insert(r)
+	}
+
+	rs := s.roleSummary("worker-1")
+	if rs == nil {
+		t.Fatal("Summaries.roleSummary() must not be nil for worker-1")
+	}
+	if rs.NumInstances != 2 {
+		t.Errorf("Summaries.roleSummary().NumInstances = %d, want 2", rs.NumInstances)
+	}
+	if rs.CurrentRate != 0.75 {
+		t.Errorf("Summaries.roleSummary().CurrentRate = %v, want 0.75", rs.CurrentRate)
+	}
+	if rs.WindowRate != 0.25 {
+		t.Errorf("Summaries.roleSummary().WindowRate = %v, want 0.25", rs.WindowRate)
+	}
+	if rs.RSSBytes != 3072 || rs.TimesRestated != 1 {
+		t.Errorf("Summaries.roleSummary() = %+v, want 3072 RSS bytes and 1 restart", rs)
+	}
+	if rs.Instances[0].PID != 100 || rs.Instances[1].WindowRate != -1 {
+		t.Errorf("Summaries.roleSummary().Instances must be sorted and JSON-safe")
+	}
+	if s.roleSummary("manager") != nil {
+		t.Errorf("Summaries.roleSummary() must be nil for unknown role")
+	}
+
+	s.Insert(&IntervalReport{Role: "worker-1", PID: 100, Retired: true})
+	if got := s.instancesOf("worker-1"); !reflect.DeepEqual(got, []string{"worker-1/101"}) {
+		t.Errorf("Summaries.instancesOf() = %v after retirement, want [worker-1/101]", got)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultReporterKeep is how many of the most recent reporter.log messages we
+// keep for each role.
+const defaultReporterKeep = 20
+
+// maxReporterPatterns limits number of distinct message patterns counted for
+// a role, so that a message we fail to normalize cannot eat all our memory.
+// Any patterns beyond the limit are counted as otherReporterPattern.
+const maxReporterPatterns = 200
+
+const otherReporterPattern = "<other>"
+
+// unknownReporterRole is role of messages we cannot attribute to a node.
+const unknownReporterRole = "unknown"
+
+// ReporterEntry is a single entry of Zeek's reporter.log.
+type ReporterEntry struct {
+	Timestamp time.Time `json:"ts"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	Location  string    `json:"location,omitempty"`
+	Role      string    `json:"role"`
+	Pattern   string    `json:"pattern"`
+}
+
+// reporterNodePrefix matches name of the node some messages are prefixed with
+// when they are forwarded from other nodes of a cluster, as in
+// "worker-1-1: field value missing".
+var reporterNodePrefix = regexp.MustCompile(`^((?:manager|logger|proxy|worker)[\w-]*): `)
+
+var reporterNormalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"[^"]*"`), `"<str>"`},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`), "<ip>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]*:[0-9a-fA-F:]*:[0-9a-fA-F]*\b`), "<ip>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<hex>"},
+	{regexp.MustCompile(`\bC[0-9A-Za-z]{14,18}\b`), "<uid>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)?`), "<n>"},
+}
+
+// normalizeReporterMessage turns a message into a pattern by replacing parts
+// which differ from one occurrence to another, such as addresses, connection
+// UIDs and numbers, with placeholders.
+func normalizeReporterMessage(msg string) string {
+	for _, n := range reporterNormalizers {
+		msg = n.re.ReplaceAllString(msg, n.repl)
+	}
+	return msg
+}
+
+// newReporterEntry interprets a reporter.log record. Level is reported
+// without its Reporter:: prefix. Role is taken from peer or node field, which
+// some deployments add, or from node name the message is prefixed with.
+func newReporterEntry(rec logRecord) (ReporterEntry, error) {
+	var e = ReporterEntry{
+		Level:    strings.TrimPrefix(rec["level"], "Reporter::"),
+		Message:  rec["message"],
+		Location: rec["location"],
+	}
+	if ts, ok := rec["ts"]; ok {
+		t, err := parseLogTime(ts)
+		if err != nil {
+			return e, fmt.Errorf("bad ts %q: %v", ts, err)
+		}
+		e.Timestamp = t
+	}
+	if e.Level == "" {
+		e.Level = "UNKNOWN"
+	}
+	switch {
+	case rec["peer"] != "":
+		e.Role = rec["peer"]
+	case rec["node"] != "":
+		e.Role = rec["node"]
+	default:
+		if m := reporterNodePrefix.FindStringSubmatch(e.Message); m != nil {
+			e.Role = m[1]
+			e.Message = strings.TrimPrefix(e.Message, m[0])
+		} else {
+			e.Role = unknownReporterRole
+		}
+	}
+	e.Pattern = normalizeReporterMessage(e.Message)
+	return e, nil
+}
+
+// ReporterStats are counts of reporter.log messages of a single role, by level
+// and by pattern, along with the most recent messages, newest last.
+type ReporterStats struct {
+	Role     string            `json:"role"`
+	Levels   map[string]uint64 `json:"levels"`
+	Patterns map[string]uint64 `json:"patterns"`
+	Recent   []ReporterEntry   `json:"recent"`
+}
+
+func newReporterStats(role string) *ReporterStats {
+	return &ReporterStats{
+		Role:     role,
+		Levels:   make(map[string]uint64),
+		Patterns: make(map[string]uint64),
+		Recent:   []ReporterEntry{},
+	}
+}
+
+func (rs *ReporterStats) add(e ReporterEntry, keep int) {
+	rs.Levels[e.Level]++
+	pattern := e.Pattern
+	if _, ok := rs.Patterns[pattern]; !ok && len(rs.Patterns) >= maxReporterPatterns {
+		pattern = otherReporterPattern
+	}
+	rs.Patterns[pattern]++
+	rs.Recent = append(rs.Recent, e)
+	if len(rs.Recent) > keep {
+		rs.Recent = rs.Recent[len(rs.Recent)-keep:]
+	}
+}
+
+func (rs ReporterStats) copy() *ReporterStats {
+	var c = newReporterStats(rs.Role)
+	for k, v := range rs.Levels {
+		c.Levels[k] = v
+	}
+	for k, v := range rs.Patterns {
+		c.Patterns[k] = v
+	}
+	c.Recent = append(c.Recent, rs.Recent...)
+	return c
+}
+
+// ZeekReporter follows Zeek's reporter.log and classifies its messages by
+// role.
+type ZeekReporter struct {
+	lf    *logFollower
+	keep  int
+	roles map[string]*ReporterStats
+	mtx   sync.RWMutex
+}
+
+// NewZeekReporter returns a collector of reporter.log at path, which keeps keep
+// most recent messages of each role.
+func NewZeekReporter(path string, keep int) *ZeekReporter {
+	return &ZeekReporter{
+		lf:    newLogFollower(path),
+		keep:  keep,
+		roles: make(map[string]*ReporterStats),
+	}
+}
+
+// Collect reads entries written since it was last called.
+func (zr *ZeekReporter) Collect() error {
+	recs, err := zr.lf.Poll()
+	var errs []string
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	zr.mtx.Lock()
+	for _, rec := range recs {
+		e, err := newReporterEntry(rec)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", zr.lf.path, err))
+			continue
+		}
+		rs, ok := zr.roles[e.Role]
+		if !ok {
+			rs = newReporterStats(e.Role)
+			zr.roles[e.Role] = rs
+		}
+		rs.add(e, zr.keep)
+	}
+	zr.mtx.Unlock()
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Role returns a copy of counts and recent messages of a role, which is empty
+// if the role has not reported anything.
+func (zr *ZeekReporter) Role(role string) *ReporterStats {
+	zr.mtx.RLock()
+	defer zr.mtx.RUnlock()
+	rs, ok := zr.roles[role]
+	if !ok {
+		return newReporterStats(role)
+	}
+	return rs.copy()
+}
+
+// Levels returns counts of messages of a role by level, nil if the role has
+// not reported anything.
+func (zr *ZeekReporter) Levels(role string) map[string]uint64 {
+	zr.mtx.RLock()
+	defer zr.mtx.RUnlock()
+	rs, ok := zr.roles[role]
+	if !ok {
+		return nil
+	}
+	var m = make(map[string]uint64, len(rs.Levels))
+	for k, v := range rs.Levels {
+		m[k] = v
+	}
+	return m
+}
+
+// ToJSON returns serialized counts and recent messages of a role.
+func (zr *ZeekReporter) ToJSON(role string) ([]byte, error) {
+	return json.Marshal(zr.Role(role))
+}
+
+func (zr *ZeekReporter) String() string {
+	zr.mtx.RLock()
+	defer zr.mtx.RUnlock()
+	var b strings.Builder
+	var roles []string
+	for role := range zr.roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		var levels []string
+		for level := range zr.roles[role].Levels {
+			levels = append(levels, level)
+		}
+		sort.Strings(levels)
+		for _, level := range levels {
+			fmt.Fprintf(&b, "bro_zeek_reporter_messages_total{role=\"%s\",level=\"%s\"} %d\n",
+				role, level, zr.roles[role].Levels[level])
+		}
+	}
+	return b.String()
+}
+
+// startZeekReporterCollector follows reporter.log until context is cancelled.
+func startZeekReporterCollector(ctx context.Context, zr *ZeekReporter) {
+	tick := time.NewTicker(ZeekLogInterval)
+	defer tick.Stop()
+	defer zr.lf.close()
+	for {
+		if err := zr.Collect(); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
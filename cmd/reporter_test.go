@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_normalizeReporterMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{
+			`field value missing (c$http$uri) [CHhAvVGS1DHFjwGM9, 10.0.0.1:51234 > 10.0.0.2:80]`,
+			`field value missing (c$http$uri) [<uid>, <ip>:<n> > <ip>:<n>]`,
+		},
+		{
+			`Failed to open GeoIP location database "/usr/share/GeoIP/GeoLite2-City.mmdb"`,
+			`Failed to open GeoIP location database "<str>"`,
+		},
+		{
+			`expression value overflow at 0x7f3a2c for 2001:db8::1`,
+			`expression value overflow at <hex> for <ip>`,
+		},
+		{`processing suspended`, `processing suspended`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := normalizeReporterMessage(tt.msg); got != tt.want {
+				t.Errorf("normalizeReporterMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newReporterEntry(t *testing.T) {
+	tests := []struct {
+		name      string
+		rec       logRecord
+		wantRole  string
+		wantLevel string
+		wantMsg   string
+	}{
+		{"peer", logRecord{"level": "Reporter::ERROR", "message": "boom", "peer": "worker-1-1"},
+			"worker-1-1", "ERROR", "boom"},
+		{"node", logRecord{"level": "Reporter::WARNING", "message": "boom", "node": "proxy-1"},
+			"proxy-1", "WARNING", "boom"},
+		{"prefix", logRecord{"level": "Reporter::ERROR", "message": "worker-2-3: field value missing"},
+			"worker-2-3", "ERROR", "field value missing"},
+		{"unknown", logRecord{"level": "Reporter::INFO", "message": "processing suspended"},
+			unknownReporterRole, "INFO", "processing suspended"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newReporterEntry(tt.rec)
+			if err != nil {
+				t.Fatalf("newReporterEntry() error = %v", err)
+			}
+			if got.Role != tt.wantRole || got.Level != tt.wantLevel || got.Message != tt.wantMsg {
+				t.Errorf("newReporterEntry() = %+v, want role %s, level %s, message %q",
+					got, tt.wantRole, tt.wantLevel, tt.wantMsg)
+			}
+		})
+	}
+	if _, err := newReporterEntry(logRecord{"ts": "yesterday"}); err == nil {
+		t.Errorf("newReporterEntry() expected error for bad ts")
+	}
+}
+
+func TestReporterStats_add(t *testing.T) {
+	rs := newReporterStats("worker-1")
+	for i := 0; i < maxReporterPatterns+5; i++ {
+		rs.add(ReporterEntry{Level: "ERROR", Pattern: fmt.Sprintf("pattern %c%c", 'a'+i/26%26, 'a'+i%26)}, 3)
+	}
+	if rs.Levels["ERROR"] != maxReporterPatterns+5 {
+		t.Errorf("ReporterStats.add() Levels = %v, want %d errors", rs.Levels, maxReporterPatterns+5)
+	}
+	if len(rs.Patterns) != maxReporterPatterns+1 || rs.Patterns[otherReporterPattern] != 5 {
+		t.Errorf("ReporterStats.add() kept %d patterns and %d others, want %d and 5",
+			len(rs.Patterns), rs.Patterns[otherReporterPattern], maxReporterPatterns+1)
+	}
+	if len(rs.Recent) != 3 {
+		t.Errorf("ReporterStats.add() kept %d recent messages, want 3", len(rs.Recent))
+	}
+}
+
+func TestZeekReporter_Collect(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string]string{
+		"reporter.log": "#separator \\x09\n" +
+			"#fields\tts\tlevel\tmessage\tlocation\n" +
+			"1600000000.0\tReporter::ERROR\tworker-1-1: field value missing (c$http) [CHhAvVGS1DHFjwGM9]\t/opt/zeek/share/zeek/base/protocols/http/main.zeek, line 1\n" +
+			"1600000001.0\tReporter::ERROR\tworker-1-1: field value missing (c$http) [CUM0KZ3MLUfNB0cl11]\t-\n" +
+			"1600000002.0\tReporter::WARNING\tworker-1-2: dropping event\t-\n" +
+			"1600000003.0\tReporter::INFO\tprocessing suspended\t-\n",
+	})
+	zr := NewZeekReporter(filepath.Join(dir, "reporter.log"), 1)
+	if err := zr.Collect(); err != nil {
+		t.Fatalf("ZeekReporter.Collect() error = %v", err)
+	}
+	got := zr.Role("worker-1-1")
+	if want := map[string]uint64{"ERROR": 2}; !reflect.DeepEqual(got.Levels, want) {
+		t.Errorf("ZeekReporter.Role() Levels = %v, want %v", got.Levels, want)
+	}
+	if want := map[string]uint64{"field value missing (c$http) [<uid>]": 2}; !reflect.DeepEqual(got.Patterns, want) {
+		t.Errorf("ZeekReporter.Role() Patterns = %v, want %v", got.Patterns, want)
+	}
+	if len(got.Recent) != 1 || !strings.Contains(got.Recent[0].Message, "CUM0KZ3MLUfNB0cl11") {
+		t.Errorf("ZeekReporter.Role() Recent = %+v, want the newest message only", got.Recent)
+	}
+	if got := zr.Levels(unknownReporterRole); got["INFO"] != 1 {
+		t.Errorf("ZeekReporter.Levels() = %v, want one INFO for unknown role", got)
+	}
+	if got := zr.Levels("manager"); got != nil {
+		t.Errorf("ZeekReporter.Levels() = %v, want nil for role without messages", got)
+	}
+	if got := zr.Role("manager"); len(got.Recent) != 0 || len(got.Levels) != 0 {
+		t.Errorf("ZeekReporter.Role() = %+v, want empty stats", got)
+	}
+	if got := zr.String(); !strings.Contains(got, `bro_zeek_reporter_messages_total{role="worker-1-2",level="WARNING"} 1`) {
+		t.Errorf("ZeekReporter.String() = %v, want WARNING of worker-1-2", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// Sources of CPU times, recorded with every sample, see CPUTimes.
+// cpuSourceTicks - utime and stime from stat, in clock ticks, usually 10ms,
+// which makes rates over one second samples coarse.
+// cpuSourceSchedstat - on-CPU time from schedstat of every thread, in
+// nanoseconds, used in precise mode whenever the kernel provides it.
+const (
+	cpuSourceTicks     = "ticks"
+	cpuSourceSchedstat = "schedstat"
+)
+
+// parseSchedstat returns time spent on CPU in nanoseconds from contents of
+// /proc/<pid>/task/<tid>/schedstat, which is the first of three fields.
+func parseSchedstat(data []byte) (int64, error) {
+	fields := bytes.Fields(data)
+	if len(fields) < 3 {
+		return 0, fmt.Errorf("expected 3 fields in schedstat, got %d", len(fields))
+	}
+	ns, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad on-CPU time in schedstat: %v", err)
+	}
+	return ns, nil
+}
+
+// ThreadSchedstat returns on-CPU nanoseconds of every thread of the process,
+// keyed by thread ID. Schedstat of the process itself only covers its main
+// thread, which is why we go through all of them. Threads which exit while we
+// are reading are skipped.
+func (p ProcInfo) ThreadSchedstat() (map[int]int64, error) {
+	paths, err := filepath.Glob(p.path("task/[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	var threads = make(map[int]int64, len(paths))
+	for _, taskDir := range paths {
+		tid, err := strconv.Atoi(filepath.Base(taskDir))
+		if err != nil {
+			continue
+		}
+		data, err := ReadFileNoStat(filepath.Join(taskDir, "schedstat"))
+		if err != nil {
+			continue
+		}
+		ns, err := parseSchedstat(data)
+		if err != nil {
+			return nil, err
+		}
+		threads[tid] = ns
+	}
+	if len(threads) == 0 {
+		return nil, fmt.Errorf("no schedstat for any thread of PID %d", p.PID)
+	}
+	return threads, nil
+}
+
+// SchedstatCounter turns on-CPU times of individual threads into a running
+// total for the process. A plain sum over threads would drop whenever a busy
+// thread exits, so instead we add up how much each thread ran since previous
+// observation, with threads we have not seen before counting in full.
+type SchedstatCounter struct {
+	threads map[int]int64
+	total   int64
+}
+
+// Update adds on-CPU times of threads observed now and returns the total.
+func (c *SchedstatCounter) Update(threads map[int]int64) int64 {
+	for tid, ns := range threads {
+		if prev, ok := c.threads[tid]; ok {
+			// A thread ID reused by a new thread may have less time than
+			// the thread before it had.
+			if ns > prev {
+				c.total += ns - prev
+			}
+		} else {
+			c.total += ns
+		}
+	}
+	c.threads = threads
+	return c.total
+}
+
+// Reset forgets all threads, for when we start looking at another process.
+func (c *SchedstatCounter) Reset() {
+	c.threads, c.total = nil, 0
+}
+
+// cpuTimes returns time the process spent on CPU and its run time, along with
+// source they came from. In precise mode, these are nanoseconds from
+// schedstat, which unlike ticks do not include time of waited-for children,
+// unless the kernel does not give us schedstat, in which case we
+// fall back to ticks from stat.
+func cpuTimes(p *ProcInfo, s ProcStat, precise bool, sc *SchedstatCounter) (int64, int64, string) {
+	if precise {
+		if threads, err := p.ThreadSchedstat(); err == nil {
+			return sc.Update(threads), p.ProcAgeAsDuration().Nanoseconds(), cpuSourceSchedstat
+		}
+	}
+	return s.OnCPUTimeTotal(), p.ProcAgeAsTicks(), cpuSourceTicks
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_parseSchedstat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "busy thread", data: "8210593184 123456 9876\n", want: 8210593184},
+		{name: "never ran", data: "0 0 0\n", want: 0},
+		{name: "too few fields", data: "8210593184\n", wantErr: true},
+		{name: "bad number", data: "lots 0 0\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSchedstat([]byte(tt.data))
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseSchedstat() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedstatCounter_Update(t *testing.T) {
+	var c SchedstatCounter
+	steps := []struct {
+		name    string
+		threads map[int]int64
+		want    int64
+	}{
+		{"first sample counts in full", map[int]int64{10: 1000, 11: 500}, 1500},
+		{"threads keep running", map[int]int64{10: 1200, 11: 800}, 2000},
+		// A plain sum would drop to 1300 here.
+		{"busy thread exits", map[int]int64{10: 1300}, 2100},
+		{"new thread", map[int]int64{10: 1300, 12: 50}, 2150},
+		{"thread ID reused", map[int]int64{10: 1300, 12: 60, 11: 5}, 2165},
+	}
+	for _, st := range steps {
+		if got := c.Update(st.threads); got != st.want {
+			t.Errorf("SchedstatCounter.Update() %s = %v, want %v", st.name, got, st.want)
+		}
+	}
+	c.Reset()
+	if got := c.Update(map[int]int64{20: 100}); got != 100 {
+		t.Errorf("SchedstatCounter.Update() after Reset() = %v, want 100", got)
+	}
+}
+
+func Test_cpuTimes(t *testing.T) {
+	p := &ProcInfo{PID: os.Getpid()}
+	s, ok := p.Stat()
+	if !ok {
+		t.Skip("no stat of our own process")
+	}
+	p.S = &s
+	var sc SchedstatCounter
+	if _, _, source := cpuTimes(p, s, false, &sc); source != cpuSourceTicks {
+		t.Errorf("cpuTimes() source = %s, want %s without precise mode", source, cpuSourceTicks)
+	}
+	if _, err := p.ThreadSchedstat(); err != nil {
+		t.Skip("no schedstat in this kernel")
+	}
+	onCPU, runTime, source := cpuTimes(p, s, true, &sc)
+	if source != cpuSourceSchedstat || onCPU <= 0 || runTime <= 0 {
+		t.Errorf("cpuTimes() = %v, %v, %s, want nanoseconds from schedstat", onCPU, runTime, source)
+	}
+	gone := &ProcInfo{PID: 1 << 30, S: &s}
+	if _, _, source := cpuTimes(gone, s, true, &sc); source != cpuSourceTicks {
+		t.Errorf("cpuTimes() source = %s, want fallback to %s", source, cpuSourceTicks)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tcpStates maps state codes found in /proc/<pid>/net/tcp to their names.
+var tcpStates = map[uint64]string{
+	0x01: "ESTABLISHED",
+	0x02: "SYN_SENT",
+	0x03: "SYN_RECV",
+	0x04: "FIN_WAIT1",
+	0x05: "FIN_WAIT2",
+	0x06: "TIME_WAIT",
+	0x07: "CLOSE",
+	0x08: "CLOSE_WAIT",
+	0x09: "LAST_ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+}
+
+// unixAcceptCon is __SO_ACCEPTCON flag of a unix socket, which is set on
+// listening sockets.
+const unixAcceptCon = 0x10000
+
+// inetSocket is a single entry from /proc/<pid>/net/{tcp,tcp6,udp,udp6}.
+type inetSocket struct {
+	Proto  string
+	Local  string
+	Remote string
+	State  string
+	SendQ  uint64
+	RecvQ  uint64
+	Inode  uint64
+}
+
+// parseHexAddr converts address in the form used by /proc/net/tcp, such as
+// 0100007F:1F90, to host:port. Addresses are written as 32-bit words, each
+// printed as a number in host byte order, so bytes of every word come out
+// reversed on little-endian hosts.
+func parseHexAddr(s string) (string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("bad address %q", s)
+	}
+	b, err := hex.DecodeString(parts[0])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return "", fmt.Errorf("bad address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(b[i:], binary.NativeEndian.Uint32(b[i:]))
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("bad port in %q: %v", s, err)
+	}
+	return net.JoinHostPort(net.IP(b).String(), strconv.FormatUint(port, 10)), nil
+}
+
+// parseInetSockets parses /proc/<pid>/net/{tcp,tcp6,udp,udp6}, all of which
+// have the same format.
+func parseInetSockets(data []byte, proto string) ([]inetSocket, error) {
+	var l []inetSocket
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		local, err := parseHexAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remote, err := parseHexAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		st, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad state %q: %v", fields[3], err)
+		}
+		queues := strings.SplitN(fields[4], ":", 2)
+		if len(queues) != 2 {
+			return nil, fmt.Errorf("bad queues %q", fields[4])
+		}
+		sendQ, err := strconv.ParseUint(queues[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad send queue %q: %v", queues[0], err)
+		}
+		recvQ, err := strconv.ParseUint(queues[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad receive queue %q: %v", queues[1], err)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad inode %q: %v", fields[9], err)
+		}
+		state, ok := tcpStates[st]
+		if !ok {
+			state = fmt.Sprintf("0x%02X", st)
+		}
+		l = append(l, inetSocket{Proto: proto, Local: local, Remote: remote,
+			State: state, SendQ: sendQ, RecvQ: recvQ, Inode: inode})
+	}
+	return l, scanner.Err()
+}
+
+// unixSocket is a single entry from /proc/<pid>/net/unix.
+type unixSocket struct {
+	Listening bool
+	Inode     uint64
+	Path      string
+}
+
+func parseUnixSockets(data []byte) ([]unixSocket, error) {
+	var l []unixSocket
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad unix socket flags %q: %v", fields[3], err)
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad unix socket inode %q: %v", fields[6], err)
+		}
+		us := unixSocket{Listening: flags&unixAcceptCon != 0, Inode: inode}
+		if len(fields) > 7 {
+			us.Path = fields[7]
+		}
+		l = append(l, us)
+	}
+	return l, scanner.Err()
+}
+
+// PacketSocket is an AF_PACKET socket, which is how Zeek and others capture
+// traffic. RecvMemBytes is memory taken by packets waiting to be read.
+type PacketSocket struct {
+	Inode        uint64 `json:"inode"`
+	Type         uint64 `json:"type"`
+	Protocol     uint64 `json:"protocol"`
+	IfIndex      int    `json:"ifindex"`
+	RecvMemBytes uint64 `json:"recv_mem_bytes"`
+}
+
+func parsePacketSockets(data []byte) ([]PacketSocket, error) {
+	var l []PacketSocket
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 {
+			continue
+		}
+		var ps PacketSocket
+		var err error
+		if ps.Type, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("bad packet socket type %q: %v", fields[2], err)
+		}
+		if ps.Protocol, err = strconv.ParseUint(fields[3], 16, 64); err != nil {
+			return nil, fmt.Errorf("bad packet socket protocol %q: %v", fields[3], err)
+		}
+		if ps.IfIndex, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, fmt.Errorf("bad packet socket interface %q: %v", fields[4], err)
+		}
+		if ps.RecvMemBytes, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+			return nil, fmt.Errorf("bad packet socket rmem %q: %v", fields[6], err)
+		}
+		if ps.Inode, err = strconv.ParseUint(fields[8], 10, 64); err != nil {
+			return nil, fmt.Errorf("bad packet socket inode %q: %v", fields[8], err)
+		}
+		l = append(l, ps)
+	}
+	return l, scanner.Err()
+}
+
+// ListeningSocket is a TCP socket in LISTEN state, or a bound UDP socket.
+type ListeningSocket struct {
+	Proto   string `json:"proto"`
+	Address string `json:"address"`
+}
+
+// Connection is a connected TCP socket along with its queue depths in bytes.
+// A growing RecvQ means the process is not keeping up with reading from its
+// peer, which on a Zeek manager means Broker falling behind.
+type Connection struct {
+	Proto  string `json:"proto"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	State  string `json:"state"`
+	RecvQ  uint64 `json:"recv_q"`
+	SendQ  uint64 `json:"send_q"`
+}
+
+// SocketReport is an inventory of sockets a process has open.
+type SocketReport struct {
+	Listening     []ListeningSocket `json:"listening"`
+	TCPStates     map[string]int    `json:"tcp_states"`
+	Connections   []Connection      `json:"connections"`
+	RecvQTotal    uint64            `json:"recv_q_total"`
+	SendQTotal    uint64            `json:"send_q_total"`
+	RecvQMax      uint64            `json:"recv_q_max"`
+	UDP           int               `json:"udp"`
+	Unix          int               `json:"unix"`
+	UnixListening int               `json:"unix_listening"`
+	Packet        []PacketSocket    `json:"packet"`
+}
+
+// buildSocketReport picks out, from every socket in the process' network
+// namespace, only those whose inodes the process has open.
+func buildSocketReport(inodes []uint64, inet []inetSocket,
+	unix []unixSocket, packet []PacketSocket) *SocketReport {
+	var own = make(map[uint64]struct{}, len(inodes))
+	for _, ino := range inodes {
+		own[ino] = struct{}{}
+	}
+	rep := &SocketReport{
+		Listening:   []ListeningSocket{},
+		TCPStates:   make(map[string]int),
+		Connections: []Connection{},
+		Packet:      []PacketSocket{},
+	}
+	for _, s := range inet {
+		if _, ok := own[s.Inode]; !ok {
+			continue
+		}
+		isTCP := strings.HasPrefix(s.Proto, "tcp")
+		switch {
+		case isTCP && s.State == "LISTEN":
+			rep.Listening = append(rep.Listening, ListeningSocket{Proto: s.Proto, Address: s.Local})
+		case isTCP:
+			rep.Connections = append(rep.Connections, Connection{Proto: s.Proto, Local: s.Local,
+				Remote: s.Remote, State: s.State, RecvQ: s.RecvQ, SendQ: s.SendQ})
+		default:
+			rep.UDP++
+			// Unconnected UDP sockets are in CLOSE state.
+			if s.State == "CLOSE" {
+				rep.Listening = append(rep.Listening, ListeningSocket{Proto: s.Proto, Address: s.Local})
+			}
+		}
+		if isTCP {
+			rep.TCPStates[s.State]++
+		}
+		rep.RecvQTotal += s.RecvQ
+		rep.SendQTotal += s.SendQ
+		if s.RecvQ > rep.RecvQMax {
+			rep.RecvQMax = s.RecvQ
+		}
+	}
+	for _, s := range unix {
+		if _, ok := own[s.Inode]; !ok {
+			continue
+		}
+		rep.Unix++
+		if s.Listening {
+			rep.UnixListening++
+		}
+	}
+	for _, s := range packet {
+		if _, ok := own[s.Inode]; ok {
+			rep.Packet = append(rep.Packet, s)
+		}
+	}
+	sort.Slice(rep.Listening, func(i, j int) bool {
+		return rep.Listening[i].Proto+rep.Listening[i].Address < rep.Listening[j].Proto+rep.Listening[j].Address
+	})
+	return rep
+}
+
+// readSocketTables reads socket tables of the network namespace from netDir,
+// which is /proc/<pid>/net. Tables which are missing, for example tcp6 when
+// IPv6 is disabled, are skipped.
+func readSocketTables(netDir string) ([]inetSocket, []unixSocket, []PacketSocket, error) {
+	var inet []inetSocket
+	var unix []unixSocket
+	var packet []PacketSocket
+	var read = func(name string) ([]byte, bool, error) {
+		data, err := ReadFileNoStat(filepath.Join(netDir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		return data, true, nil
+	}
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		data, ok, err := read(proto)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		l, err := parseInetSockets(data, proto)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", proto, err)
+		}
+		inet = append(inet, l...)
+	}
+	if data, ok, err := read("unix"); err != nil {
+		return nil, nil, nil, err
+	} else if ok {
+		if unix, err = parseUnixSockets(data); err != nil {
+			return nil, nil, nil, fmt.Errorf("unix: %v", err)
+		}
+	}
+	if data, ok, err := read("packet"); err != nil {
+		return nil, nil, nil, err
+	} else if ok {
+		if packet, err = parsePacketSockets(data); err != nil {
+			return nil, nil, nil, fmt.Errorf("packet: %v", err)
+		}
+	}
+	return inet, unix, packet, nil
+}
+
+// String returns socket inventory in Prometheus format with given labels.
+func (sr SocketReport) String(labels string) string {
+	var b strings.Builder
+	var states []string
+	for state := range sr.TCPStates {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(&b, "bro_tcp_sockets{%s,state=\"%s\"} %d\n", labels, state, sr.TCPStates[state])
+	}
+	fmt.Fprintf(&b, "bro_listening_sockets{%s} %d\n", labels, len(sr.Listening))
+	fmt.Fprintf(&b, "bro_socket_recv_queue_bytes{%s} %d\n", labels, sr.RecvQTotal)
+	fmt.Fprintf(&b, "bro_socket_send_queue_bytes{%s} %d\n", labels, sr.SendQTotal)
+	fmt.Fprintf(&b, "bro_socket_recv_queue_max_bytes{%s} %d\n", labels, sr.RecvQMax)
+	fmt.Fprintf(&b, "bro_packet_sockets{%s} %d\n", labels, len(sr.Packet))
+	return b.String()
+}
+
+// RoleSockets are sockets of every instance of a role. Counts and queue
+// totals are sums over instances, while RecvQMax is the deepest receive queue
+// of any of them.
+type RoleSockets struct {
+	Listening     []ListeningSocket `json:"listening"`
+	TCPStates     map[string]int    `json:"tcp_states"`
+	Connections   int               `json:"connections"`
+	RecvQTotal    uint64            `json:"recv_q_total"`
+	SendQTotal    uint64            `json:"send_q_total"`
+	RecvQMax      uint64            `json:"recv_q_max"`
+	UDP           int               `json:"udp"`
+	Unix          int               `json:"unix"`
+	UnixListening int               `json:"unix_listening"`
+	Packet        int               `json:"packet"`
+}
+
+// newRoleSockets sums socket reports of instances of a role, nil if none of
+// them has one.
+func newRoleSockets(reps []*IntervalReport) *RoleSockets {
+	var rs *RoleSockets
+	for _, rep := range reps {
+		sr := rep.Sockets
+		if sr == nil {
+			continue
+		}
+		if rs == nil {
+			rs = &RoleSockets{Listening: []ListeningSocket{}, TCPStates: make(map[string]int)}
+		}
+		rs.Listening = append(rs.Listening, sr.Listening...)
+		for state, n := range sr.TCPStates {
+			rs.TCPStates[state] += n
+		}
+		rs.Connections += len(sr.Connections)
+		rs.RecvQTotal += sr.RecvQTotal
+		rs.SendQTotal += sr.SendQTotal
+		if sr.RecvQMax > rs.RecvQMax {
+			rs.RecvQMax = sr.RecvQMax
+		}
+		rs.UDP += sr.UDP
+		rs.Unix += sr.Unix
+		rs.UnixListening += sr.UnixListening
+		rs.Packet += len(sr.Packet)
+	}
+	if rs != nil {
+		sort.Slice(rs.Listening, func(i, j int) bool {
+			return rs.Listening[i].Proto+rs.Listening[i].Address < rs.Listening[j].Proto+rs.Listening[j].Address
+		})
+	}
+	return rs
+}
+
+// String returns sockets of a role in Prometheus format.
+func (rs RoleSockets) String(role string) string {
+	var b strings.Builder
+	var states []string
+	for state := range rs.TCPStates {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(&b, "bro_role_tcp_sockets{role=\"%s\",state=\"%s\"} %d\n", role, state, rs.TCPStates[state])
+	}
+	fmt.Fprintf(&b, "bro_role_listening_sockets{role=\"%s\"} %d\n", role, len(rs.Listening))
+	fmt.Fprintf(&b, "bro_role_socket_recv_queue_bytes{role=\"%s\"} %d\n", role, rs.RecvQTotal)
+	fmt.Fprintf(&b, "bro_role_socket_send_queue_bytes{role=\"%s\"} %d\n", role, rs.SendQTotal)
+	fmt.Fprintf(&b, "bro_role_socket_recv_queue_max_bytes{role=\"%s\"} %d\n", role, rs.RecvQMax)
+	fmt.Fprintf(&b, "bro_role_packet_sockets{role=\"%s\"} %d\n", role, rs.Packet)
+	return b.String()
+}
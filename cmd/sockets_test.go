@@ -0,0 +1,217 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const tcpSample = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode                                                     
+   0: 0100007F:08AE 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1001 1 0000000000000000 100 0 0 10 0                     
+   1: 0100007F:08AE 0100007F:D431 01 00000010:00000200 00:00000000 00000000  1000        0 1002 1 0000000000000000 20 4 30 10 -1                    
+   2: 0100007F:D431 0100007F:08AE 01 00000000:00000000 00:00000000 00000000  1000        0 1003 1 0000000000000000 20 4 30 10 -1                    
+   3: 0100007F:08AF 0100007F:D432 08 00000000:00000040 00:00000000 00000000  1000        0 1004 1 0000000000000000 20 4 30 10 -1                    
+`
+
+const tcp6Sample = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 2001 1 0000000000000000 100 0 0 10 0
+`
+
+const udpSample = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops             
+  100: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 3001 2 0000000000000000 0         
+`
+
+const unixSample = `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 4001 /run/zeek.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 4002
+0000000000000000: 00000003 00000000 00000000 0001 03 4003 /run/other.sock
+`
+
+const packetSample = `sk               RefCnt Type Proto  Iface R Rmem   User   Inode
+0000000000000000 3      3    0003   2     1 4352   0      5001
+0000000000000000 3      3    0003   3     1 0      0      5002
+`
+
+func Test_parseHexAddr(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"0100007F:08AE", "127.0.0.1:2222", false},
+		{"00000000:0000", "0.0.0.0:0", false},
+		{"00000000000000000000000001000000:1F90", "[::1]:8080", false},
+		{"0100007F", "", true},
+		{"01007F:0016", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseHexAddr(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseHexAddr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseHexAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseInetSockets(t *testing.T) {
+	got, err := parseInetSockets([]byte(tcpSample), "tcp")
+	if err != nil {
+		t.Fatalf("parseInetSockets() error = %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("parseInetSockets() returned %d sockets, want 4", len(got))
+	}
+	want := inetSocket{Proto: "tcp", Local: "127.0.0.1:2222", Remote: "127.0.0.1:54321",
+		State: "ESTABLISHED", SendQ: 0x10, RecvQ: 0x200, Inode: 1002}
+	if !reflect.DeepEqual(got[1], want) {
+		t.Errorf("parseInetSockets() = %+v, want %+v", got[1], want)
+	}
+	if got[3].State != "CLOSE_WAIT" {
+		t.Errorf("parseInetSockets() state = %v, want CLOSE_WAIT", got[3].State)
+	}
+}
+
+func Test_parseUnixSockets(t *testing.T) {
+	got, err := parseUnixSockets([]byte(unixSample))
+	if err != nil {
+		t.Fatalf("parseUnixSockets() error = %v", err)
+	}
+	want := []unixSocket{
+		{Listening: true, Inode: 4001, Path: "/run/zeek.sock"},
+		{Listening: false, Inode: 4002},
+		{Listening: false, Inode: 4003, Path: "/run/other.sock"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseUnixSockets() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_parsePacketSockets(t *testing.T) {
+	got, err := parsePacketSockets([]byte(packetSample))
+	if err != nil {
+		t.Fatalf("parsePacketSockets() error = %v", err)
+	}
+	want := []PacketSocket{
+		{Inode: 5001, Type: 3, Protocol: 3, IfIndex: 2, RecvMemBytes: 4352},
+		{Inode: 5002, Type: 3, Protocol: 3, IfIndex: 3, RecvMemBytes: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePacketSockets() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_buildSocketReport(t *testing.T) {
+	tcp, _ := parseInetSockets([]byte(tcpSample), "tcp")
+	tcp6, _ := parseInetSockets([]byte(tcp6Sample), "tcp6")
+	udp, _ := parseInetSockets([]byte(udpSample), "udp")
+	unix, _ := parseUnixSockets([]byte(unixSample))
+	packet, _ := parsePacketSockets([]byte(packetSample))
+	inet := append(append(tcp, tcp6...), udp...)
+
+	// Inode 1003 is the other end of connection, owned by another process,
+	// same with 4003 and 5002.
+	inodes := []uint64{1001, 1002, 1004, 2001, 3001, 4001, 4002, 5001, 9999}
+	got := buildSocketReport(inodes, inet, unix, packet)
+
+	wantListening := []ListeningSocket{
+		{Proto: "tcp", Address: "127.0.0.1:2222"},
+		{Proto: "tcp6", Address: "[::1]:8080"},
+		{Proto: "udp", Address: "0.0.0.0:68"},
+	}
+	if !reflect.DeepEqual(got.Listening, wantListening) {
+		t.Errorf("buildSocketReport() Listening = %+v, want %+v", got.Listening, wantListening)
+	}
+	wantStates := map[string]int{"LISTEN": 2, "ESTABLISHED": 1, "CLOSE_WAIT": 1}
+	if !reflect.DeepEqual(got.TCPStates, wantStates) {
+		t.Errorf("buildSocketReport() TCPStates = %v, want %v", got.TCPStates, wantStates)
+	}
+	if len(got.Connections) != 2 {
+		t.Errorf("buildSocketReport() Connections = %+v, want 2", got.Connections)
+	}
+	if got.RecvQTotal != 0x240 || got.RecvQMax != 0x200 || got.SendQTotal != 0x10 {
+		t.Errorf("buildSocketReport() queues = %d/%d/%d, want %d/%d/%d",
+			got.RecvQTotal, got.RecvQMax, got.SendQTotal, 0x240, 0x200, 0x10)
+	}
+	if got.UDP != 1 || got.Unix != 2 || got.UnixListening != 1 {
+		t.Errorf("buildSocketReport() UDP = %d, Unix = %d, UnixListening = %d, want 1, 2, 1",
+			got.UDP, got.Unix, got.UnixListening)
+	}
+	if len(got.Packet) != 1 || got.Packet[0].Inode != 5001 {
+		t.Errorf("buildSocketReport() Packet = %+v, want inode 5001 only", got.Packet)
+	}
+}
+
+func Test_newRoleSockets(t *testing.T) {
+	reps := []*IntervalReport{
+		{Sockets: &SocketReport{
+			Listening:   []ListeningSocket{{Proto: "tcp", Address: "0.0.0.0:47761"}},
+			TCPStates:   map[string]int{"LISTEN": 1, "ESTABLISHED": 2},
+			Connections: []Connection{{RecvQ: 4096}, {RecvQ: 512}},
+			RecvQTotal:  4608,
+			RecvQMax:    4096,
+			Packet:      []PacketSocket{{Inode: 1}},
+		}},
+		{},
+		{Sockets: &SocketReport{
+			Listening:   []ListeningSocket{{Proto: "tcp", Address: "0.0.0.0:47760"}},
+			TCPStates:   map[string]int{"LISTEN": 1, "ESTABLISHED": 1},
+			Connections: []Connection{{RecvQ: 8192, SendQ: 16}},
+			RecvQTotal:  8192,
+			SendQTotal:  16,
+			RecvQMax:    8192,
+			UDP:         1,
+		}},
+	}
+	got := newRoleSockets(reps)
+	want := &RoleSockets{
+		Listening: []ListeningSocket{
+			{Proto: "tcp", Address: "0.0.0.0:47760"},
+			{Proto: "tcp", Address: "0.0.0.0:47761"},
+		},
+		TCPStates:   map[string]int{"LISTEN": 2, "ESTABLISHED": 3},
+		Connections: 3,
+		RecvQTotal:  12800,
+		SendQTotal:  16,
+		RecvQMax:    8192,
+		UDP:         1,
+		Packet:      1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newRoleSockets() = %+v, want %+v", got, want)
+	}
+	if got := newRoleSockets(reps[1:2]); got != nil {
+		t.Errorf("newRoleSockets() = %+v, want nil without socket reports", got)
+	}
+	out := got.String("manager")
+	for _, want := range []string{
+		`bro_role_tcp_sockets{role="manager",state="ESTABLISHED"} 3`,
+		`bro_role_socket_recv_queue_max_bytes{role="manager"} 8192`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("RoleSockets.String() is missing %s:\n%s", want, out)
+		}
+	}
+}
+
+func Test_readSocketTables(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"tcp":    tcpSample,
+		"udp":    udpSample,
+		"unix":   unixSample,
+		"packet": packetSample,
+	})
+	inet, unix, packet, err := readSocketTables(root)
+	if err != nil {
+		t.Fatalf("readSocketTables() error = %v", err)
+	}
+	if len(inet) != 5 || len(unix) != 3 || len(packet) != 2 {
+		t.Errorf("readSocketTables() = %d inet, %d unix, %d packet, want 5, 3, 2",
+			len(inet), len(unix), len(packet))
+	}
+}
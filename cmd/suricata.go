@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SuricataInterval is how often we ask Suricata for its counters.
+const SuricataInterval = 10 * time.Second
+
+// SuricataTimeout bounds a whole exchange with Suricata's command socket.
+const SuricataTimeout = 5 * time.Second
+
+const defaultSuricataSocket = "/var/run/suricata/suricata-command.socket"
+
+// suricataRole is role of Suricata processes. Their command line does not
+// tell us anything like a Zeek node name does, and there is normally just one.
+const suricataRole = "suricata"
+
+// suricataProtocolVersion is version of the command protocol we speak.
+const suricataProtocolVersion = "0.2"
+
+// findSuricataProcs finds Suricata processes running exe, all of which get
+// suricataRole.
+func findSuricataProcs(exe string) []*ProcInfo {
+	procs := findProcsByName(exe)
+	for _, p := range procs {
+		p.Role = suricataRole
+	}
+	return procs
+}
+
+// suricataThreadRole turns name of a Suricata thread, such as W#01-eth0, into
+// a role name safe to use in a URL path, such as suricata-W01-eth0.
+func suricataThreadRole(thread string) string {
+	return suricataRole + "-" + strings.Map(func(r rune) rune {
+		switch r {
+		case '#':
+			return -1
+		case '/', ' ':
+			return '-'
+		}
+		return r
+	}, thread)
+}
+
+// flattenCounters turns nested counters, as dump-counters returns them, into
+// a map keyed by dotted names such as capture.kernel_drops.
+func flattenCounters(prefix string, m map[string]interface{}, out map[string]float64) {
+	for k, v := range m {
+		name := k
+		if prefix != "" {
+			name = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case float64:
+			out[name] = v
+		case map[string]interface{}:
+			flattenCounters(name, v, out)
+		}
+	}
+}
+
+// SuricataCounters are the counters of a Suricata thread, or totals of all
+// threads, we care about most, along with every counter under its dotted name.
+type SuricataCounters struct {
+	Role          string             `json:"role"`
+	Thread        string             `json:"thread,omitempty"`
+	KernelPackets float64            `json:"kernel_packets"`
+	KernelDrops   float64            `json:"kernel_drops"`
+	DecoderPkts   float64            `json:"decoder_pkts"`
+	FlowMemuse    float64            `json:"flow_memuse"`
+	Counters      map[string]float64 `json:"counters"`
+}
+
+func newSuricataCounters(role, thread string, m map[string]interface{}) *SuricataCounters {
+	var c = &SuricataCounters{Role: role, Thread: thread, Counters: make(map[string]float64)}
+	flattenCounters("", m, c.Counters)
+	c.KernelPackets = c.Counters["capture.kernel_packets"]
+	c.KernelDrops = c.Counters["capture.kernel_drops"]
+	c.DecoderPkts = c.Counters["decoder.pkts"]
+	c.FlowMemuse = c.Counters["flow.memuse"]
+	return c
+}
+
+// String returns counters in Prometheus format.
+func (c SuricataCounters) String() string {
+	var b strings.Builder
+	labels := fmt.Sprintf("role=\"%s\"", c.Role)
+	if c.Thread != "" {
+		labels += fmt.Sprintf(",thread=\"%s\"", c.Thread)
+	}
+	fmt.Fprintf(&b, "bro_suricata_kernel_packets{%s} %g\n", labels, c.KernelPackets)
+	fmt.Fprintf(&b, "bro_suricata_kernel_drops{%s} %g\n", labels, c.KernelDrops)
+	fmt.Fprintf(&b, "bro_suricata_decoder_pkts{%s} %g\n", labels, c.DecoderPkts)
+	fmt.Fprintf(&b, "bro_suricata_flow_memuse_bytes{%s} %g\n", labels, c.FlowMemuse)
+	return b.String()
+}
+
+// SuricataStats is the outcome of dump-counters. Threads are keyed by their
+// roles, see suricataThreadRole.
+type SuricataStats struct {
+	Timestamp time.Time                    `json:"ts"`
+	Uptime    float64                      `json:"uptime"`
+	Totals    *SuricataCounters            `json:"totals"`
+	Threads   map[string]*SuricataCounters `json:"threads"`
+}
+
+// parseDumpCounters interprets message of dump-counters reply, which has
+// totals at top level and counters of each thread under threads.
+func parseDumpCounters(msg map[string]interface{}, now time.Time) *SuricataStats {
+	var st = &SuricataStats{
+		Timestamp: now,
+		Threads:   make(map[string]*SuricataCounters),
+	}
+	var totals = make(map[string]interface{})
+	for k, v := range msg {
+		switch k {
+		case "uptime":
+			st.Uptime, _ = v.(float64)
+		case "threads":
+			threads, _ := v.(map[string]interface{})
+			for thread, counters := range threads {
+				m, ok := counters.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				role := suricataThreadRole(thread)
+				st.Threads[role] = newSuricataCounters(role, thread, m)
+			}
+		default:
+			totals[k] = v
+		}
+	}
+	st.Totals = newSuricataCounters(suricataRole, "", totals)
+	return st
+}
+
+// suricataReply is a reply to any command sent to Suricata's command socket.
+type suricataReply struct {
+	Return  string          `json:"return"`
+	Message json.RawMessage `json:"message"`
+}
+
+// suricataCommand sends a command and decodes reply, failing unless Suricata
+// says OK.
+func suricataCommand(enc *json.Encoder, dec *json.Decoder, cmd interface{}) (json.RawMessage, error) {
+	if err := enc.Encode(cmd); err != nil {
+		return nil, err
+	}
+	var reply suricataReply
+	if err := dec.Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Return != "OK" {
+		return nil, fmt.Errorf("suricata replied %s: %s", reply.Return, string(reply.Message))
+	}
+	return reply.Message, nil
+}
+
+// dumpSuricataCounters connects to command socket at path, negotiates the
+// protocol version and asks for counters.
+func dumpSuricataCounters(path string, timeout time.Duration) (*SuricataStats, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	if _, err := suricataCommand(enc, dec, map[string]string{"version": suricataProtocolVersion}); err != nil {
+		return nil, fmt.Errorf("suricata handshake: %v", err)
+	}
+	raw, err := suricataCommand(enc, dec, map[string]string{"command": "dump-counters"})
+	if err != nil {
+		return nil, fmt.Errorf("suricata dump-counters: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, fmt.Errorf("suricata dump-counters: %v", err)
+	}
+	return parseDumpCounters(msg, time.Now()), nil
+}
+
+// Suricata periodically collects counters from Suricata's command socket.
+type Suricata struct {
+	socket string
+	stats  *SuricataStats
+	mtx    sync.RWMutex
+}
+
+// NewSuricata returns a collector talking to command socket at path.
+func NewSuricata(socket string) *Suricata {
+	return &Suricata{socket: socket}
+}
+
+// Collect asks Suricata for counters and replaces previous sample.
+func (s *Suricata) Collect() error {
+	st, err := dumpSuricataCounters(s.socket, SuricataTimeout)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stats = st
+	return nil
+}
+
+// Stats returns the most recent sample, nil if there is none.
+func (s *Suricata) Stats() *SuricataStats {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.stats
+}
+
+// Totals returns totals of all threads, nil if we do not have any sample.
+func (s *Suricata) Totals() *SuricataCounters {
+	if st := s.Stats(); st != nil {
+		return st.Totals
+	}
+	return nil
+}
+
+// ToJSON returns serialized most recent sample.
+func (s *Suricata) ToJSON() ([]byte, error) {
+	st := s.Stats()
+	if st == nil {
+		return []byte{}, errNoInfoForRole
+	}
+	return json.Marshal(st)
+}
+
+// ThreadToJSON returns serialized counters of the thread with given role, see
+// suricataThreadRole.
+func (s *Suricata) ThreadToJSON(role string) ([]byte, error) {
+	st := s.Stats()
+	if st == nil || st.Threads[role] == nil {
+		return []byte{}, errNoInfoForRole
+	}
+	return json.Marshal(st.Threads[role])
+}
+
+func (s *Suricata) String() string {
+	st := s.Stats()
+	if st == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(st.Totals.String())
+	var roles []string
+	for role := range st.Threads {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		b.WriteString(st.Threads[role].String())
+	}
+	return b.String()
+}
+
+// startSuricataCollector collects counters until context is cancelled.
+func startSuricataCollector(ctx context.Context, s *Suricata) {
+	tick := time.NewTicker(SuricataInterval)
+	defer tick.Stop()
+	for {
+		if err := s.Collect(); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dumpCountersSample = `{"return":"OK","message":{
+"uptime":120,
+"capture":{"kernel_packets":3000,"kernel_drops":30},
+"decoder":{"pkts":2970,"bytes":1000000},
+"flow":{"memuse":7394304},
+"threads":{
+  "W#01-eth0":{"capture":{"kernel_packets":1000,"kernel_drops":0},"decoder":{"pkts":1000},"flow":{"memuse":0}},
+  "W#02-eth0":{"capture":{"kernel_packets":2000,"kernel_drops":30},"decoder":{"pkts":1970}},
+  "FM#01":{"flow":{"memuse":7394304}}
+}}}`
+
+// serveSuricata stands in for Suricata's command socket, replying to the
+// handshake and then with reply to whatever command comes next.
+func serveSuricata(t *testing.T, path, reply string) <-chan []map[string]string {
+	t.Helper()
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got = make(chan []map[string]string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec := json.NewDecoder(conn)
+		var cmds []map[string]string
+		for _, r := range []string{`{"return":"OK"}`, reply} {
+			var cmd map[string]string
+			if err := dec.Decode(&cmd); err != nil {
+				break
+			}
+			cmds = append(cmds, cmd)
+			// Suricata does not terminate replies with a newline.
+			conn.Write([]byte(r))
+		}
+		got <- cmds
+	}()
+	return got
+}
+
+func Test_suricataThreadRole(t *testing.T) {
+	tests := []struct {
+		thread string
+		want   string
+	}{
+		{"W#01-eth0", "suricata-W01-eth0"},
+		{"FM#01", "suricata-FM01"},
+		{"RX#01-af_packet/eth1", "suricata-RX01-af_packet-eth1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.thread, func(t *testing.T) {
+			if got := suricataThreadRole(tt.thread); got != tt.want {
+				t.Errorf("suricataThreadRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dumpSuricataCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suricata.socket")
+	cmds := serveSuricata(t, path, dumpCountersSample)
+	st, err := dumpSuricataCounters(path, time.Second)
+	if err != nil {
+		t.Fatalf("dumpSuricataCounters() error = %v", err)
+	}
+	wantCmds := []map[string]string{{"version": suricataProtocolVersion}, {"command": "dump-counters"}}
+	if got := <-cmds; !reflect.DeepEqual(got, wantCmds) {
+		t.Errorf("dumpSuricataCounters() sent %v, want %v", got, wantCmds)
+	}
+	if st.Uptime != 120 || st.Totals.KernelDrops != 30 || st.Totals.FlowMemuse != 7394304 {
+		t.Errorf("dumpSuricataCounters() totals = %+v", st.Totals)
+	}
+	if st.Totals.Counters["decoder.bytes"] != 1000000 {
+		t.Errorf("dumpSuricataCounters() counters = %v, want decoder.bytes", st.Totals.Counters)
+	}
+	if len(st.Threads) != 3 {
+		t.Fatalf("dumpSuricataCounters() threads = %v, want 3", st.Threads)
+	}
+	w2 := st.Threads["suricata-W02-eth0"]
+	if w2 == nil || w2.Thread != "W#02-eth0" || w2.KernelPackets != 2000 || w2.KernelDrops != 30 || w2.DecoderPkts != 1970 {
+		t.Errorf("dumpSuricataCounters() W#02-eth0 = %+v", w2)
+	}
+}
+
+func Test_dumpSuricataCounters_errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := dumpSuricataCounters(filepath.Join(dir, "missing.socket"), time.Second); err == nil {
+		t.Errorf("dumpSuricataCounters() expected error without socket")
+	}
+	path := filepath.Join(dir, "suricata.socket")
+	serveSuricata(t, path, `{"return":"NOK","message":"Unknown command"}`)
+	_, err := dumpSuricataCounters(path, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "Unknown command") {
+		t.Errorf("dumpSuricataCounters() error = %v, want Unknown command", err)
+	}
+}
+
+func TestSuricata_Collect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suricata.socket")
+	s := NewSuricata(path)
+	if s.Totals() != nil || s.String() != "" {
+		t.Errorf("Suricata without sample = %v, want nothing", s.Totals())
+	}
+	if _, err := s.ToJSON(); err != errNoInfoForRole {
+		t.Errorf("Suricata.ToJSON() error = %v, want %v", err, errNoInfoForRole)
+	}
+	serveSuricata(t, path, dumpCountersSample)
+	if err := s.Collect(); err != nil {
+		t.Fatalf("Suricata.Collect() error = %v", err)
+	}
+	got := s.String()
+	for _, want := range []string{
+		`bro_suricata_kernel_drops{role="suricata"} 30`,
+		`bro_suricata_kernel_drops{role="suricata-W02-eth0",thread="W#02-eth0"} 30`,
+		`bro_suricata_flow_memuse_bytes{role="suricata-FM01",thread="FM#01"} 7.394304e+06`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Suricata.String() is missing %s:\n%s", want, got)
+		}
+	}
+	if data, err := s.ThreadToJSON("suricata-W02-eth0"); err != nil || !strings.Contains(string(data), `"W#02-eth0"`) {
+		t.Errorf("Suricata.ThreadToJSON() = %s, %v, want counters of W#02-eth0", data, err)
+	}
+	if _, err := s.ThreadToJSON("suricata-W09-eth0"); err != errNoInfoForRole {
+		t.Errorf("Suricata.ThreadToJSON() error = %v, want %v", err, errNoInfoForRole)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TaskstatsTimeout is how long we wait for the kernel to answer a query.
+const TaskstatsTimeout = time.Second
+
+// Generic netlink constants from linux/genetlink.h and linux/taskstats.h,
+// which the syscall package does not have.
+const (
+	genlIDCtrl             = 0x10
+	ctrlCmdGetFamily       = 3
+	ctrlAttrFamilyID       = 1
+	ctrlAttrFamilyName     = 2
+	taskstatsGenlName      = "TASKSTATS"
+	taskstatsCmdGet        = 1
+	taskstatsCmdAttrTGID   = 2
+	taskstatsTypeStats     = 3
+	taskstatsTypeAggrPID   = 4
+	taskstatsTypeAggrTGID  = 5
+	nlmsgHdrLen            = 16
+	genlHdrLen             = 4
+	nlaHdrLen              = 4
+	taskstatsMinSize       = 312 // Up to cpu_scaled_run_real_total, version 4.
+	taskstatsFreepagesSize = 328 // Adds freepages delay, version 6.
+	taskstatsThrashingSize = 344 // Adds thrashing delay, version 9.
+)
+
+// Delay is one kind of delay a task went through, with number of waits and
+// their total in nanoseconds. Share is share of wall-clock time since
+// previous sample spent waiting. Delays are summed over all threads, so a
+// process whose threads all wait can have share above 1.
+type Delay struct {
+	Count   uint64  `json:"count"`
+	TotalNs uint64  `json:"total_ns"`
+	Share   float64 `json:"share"`
+}
+
+// TaskDelays are delay accounting figures of a process from taskstats. These
+// tell whether a process is slow because it waits for a CPU, for disk, for
+// pages to be swapped in, for memory to be reclaimed, or for its working set
+// to be read back after being evicted. Delays are only counted when the
+// kernel has delay accounting enabled, see kernel.task_delayacct sysctl.
+// Freepages and thrashing delays are missing from older kernels, and their
+// counts are zero there.
+type TaskDelays struct {
+	Version   uint16 `json:"version"`
+	CPURunNs  uint64 `json:"cpu_run_ns"`
+	CPU       Delay  `json:"cpu"`
+	BlkIO     Delay  `json:"blkio"`
+	Swapin    Delay  `json:"swapin"`
+	Freepages Delay  `json:"freepages"`
+	Thrashing Delay  `json:"thrashing"`
+}
+
+// delays returns every kind of delay along with its name.
+func (td *TaskDelays) delays() []struct {
+	name string
+	d    *Delay
+} {
+	return []struct {
+		name string
+		d    *Delay
+	}{
+		{"cpu", &td.CPU},
+		{"blkio", &td.BlkIO},
+		{"swapin", &td.Swapin},
+		{"freepages", &td.Freepages},
+		{"thrashing", &td.Thrashing},
+	}
+}
+
+// parseTaskstats decodes struct taskstats. Fields are only ever appended to
+// the struct, so we read those the size and version we got say are there.
+func parseTaskstats(b []byte) (*TaskDelays, error) {
+	if len(b) < taskstatsMinSize {
+		return nil, fmt.Errorf("taskstats too short, %d bytes", len(b))
+	}
+	u64 := func(off int) uint64 { return binary.NativeEndian.Uint64(b[off:]) }
+	td := &TaskDelays{
+		Version:  binary.NativeEndian.Uint16(b),
+		CPURunNs: u64(64),
+		CPU:      Delay{Count: u64(16), TotalNs: u64(24)},
+		BlkIO:    Delay{Count: u64(32), TotalNs: u64(40)},
+		Swapin:   Delay{Count: u64(48), TotalNs: u64(56)},
+	}
+	if td.Version >= 6 && len(b) >= taskstatsFreepagesSize {
+		td.Freepages = Delay{Count: u64(312), TotalNs: u64(320)}
+	}
+	if td.Version >= 9 && len(b) >= taskstatsThrashingSize {
+		td.Thrashing = Delay{Count: u64(328), TotalNs: u64(336)}
+	}
+	return td, nil
+}
+
+// updateSince computes shares of delays since prev, taken elapsed ago. Without
+// a previous sample, or when totals went backwards, shares are NaN.
+func (td *TaskDelays) updateSince(prev *TaskDelays, elapsed time.Duration) {
+	var prevDelays []struct {
+		name string
+		d    *Delay
+	}
+	if prev != nil {
+		prevDelays = prev.delays()
+	}
+	for i, kind := range td.delays() {
+		kind.d.Share = math.NaN()
+		if prev == nil || elapsed <= 0 || kind.d.TotalNs < prevDelays[i].d.TotalNs {
+			continue
+		}
+		kind.d.Share = float64(kind.d.TotalNs-prevDelays[i].d.TotalNs) / float64(elapsed.Nanoseconds())
+	}
+}
+
+func (td TaskDelays) safe() *TaskDelays {
+	for _, kind := range td.delays() {
+		if math.IsNaN(kind.d.Share) {
+			kind.d.Share = -1
+		}
+	}
+	return &td
+}
+
+// String returns delays in Prometheus format with given labels.
+func (td TaskDelays) String(labels string) string {
+	return td.metrics("bro_task", labels)
+}
+
+// metrics returns delays in Prometheus format, with names starting with
+// prefix, so that delays of a role are told from those of its instances.
+func (td TaskDelays) metrics(prefix, labels string) string {
+	var b strings.Builder
+	for _, kind := range td.delays() {
+		l := fmt.Sprintf("%s,delay=\"%s\"", labels, kind.name)
+		fmt.Fprintf(&b, "%s_delays_total{%s} %d\n", prefix, l, kind.d.Count)
+		fmt.Fprintf(&b, "%s_delay_seconds_total{%s} %g\n", prefix, l, float64(kind.d.TotalNs)/1e9)
+		fmt.Fprintf(&b, "%s_delay_share{%s} %g\n", prefix, l, kind.d.Share)
+	}
+	return b.String()
+}
+
+// newRoleDelays sums delays of instances of a role, nil if none of them has
+// any. Shares are summed the same as rates are, and are NaN only if no
+// instance has one.
+func newRoleDelays(reps []*IntervalReport) *TaskDelays {
+	var rd *TaskDelays
+	var shares [][]float64
+	for _, rep := range reps {
+		if rep.Delays == nil {
+			continue
+		}
+		if rd == nil {
+			rd = &TaskDelays{Version: rep.Delays.Version}
+			shares = make([][]float64, len(rd.delays()))
+		}
+		if rep.Delays.Version < rd.Version {
+			rd.Version = rep.Delays.Version
+		}
+		rd.CPURunNs += rep.Delays.CPURunNs
+		var inst = rep.Delays.delays()
+		for i, kind := range rd.delays() {
+			kind.d.Count += inst[i].d.Count
+			kind.d.TotalNs += inst[i].d.TotalNs
+			shares[i] = append(shares[i], inst[i].d.Share)
+		}
+	}
+	if rd == nil {
+		return nil
+	}
+	for i, kind := range rd.delays() {
+		kind.d.Share = math.NaN()
+		if countNaNs(shares[i]) < len(shares[i]) {
+			kind.d.Share = sum(shares[i])
+		}
+	}
+	return rd
+}
+
+// netlinkAttr is a netlink attribute, its payload possibly being further
+// attributes.
+type netlinkAttr struct {
+	Type uint16
+	Data []byte
+}
+
+func nlaAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// encodeAttrs appends attributes to b, each padded to four bytes.
+func encodeAttrs(b []byte, attrs []netlinkAttr) []byte {
+	for _, a := range attrs {
+		var hdr [nlaHdrLen]byte
+		binary.NativeEndian.PutUint16(hdr[0:], uint16(nlaHdrLen+len(a.Data)))
+		binary.NativeEndian.PutUint16(hdr[2:], a.Type)
+		b = append(b, hdr[:]...)
+		b = append(b, a.Data...)
+		b = append(b, make([]byte, nlaAlign(len(a.Data))-len(a.Data))...)
+	}
+	return b
+}
+
+// parseAttrs decodes attributes, ignoring nested and byte order flags in
+// their types.
+func parseAttrs(b []byte) ([]netlinkAttr, error) {
+	var attrs []netlinkAttr
+	for len(b) >= nlaHdrLen {
+		n := int(binary.NativeEndian.Uint16(b))
+		if n < nlaHdrLen || n > len(b) {
+			return nil, fmt.Errorf("bad netlink attribute length %d", n)
+		}
+		attrs = append(attrs, netlinkAttr{
+			Type: binary.NativeEndian.Uint16(b[2:]) & 0x3fff,
+			Data: b[nlaHdrLen:n],
+		})
+		if nlaAlign(n) >= len(b) {
+			break
+		}
+		b = b[nlaAlign(n):]
+	}
+	return attrs, nil
+}
+
+// genlRequest builds a generic netlink request for family.
+func genlRequest(family uint16, cmd uint8, seq uint32, attrs []netlinkAttr) []byte {
+	b := make([]byte, nlmsgHdrLen+genlHdrLen)
+	binary.NativeEndian.PutUint16(b[4:], family)
+	binary.NativeEndian.PutUint16(b[6:], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(b[8:], seq)
+	b[nlmsgHdrLen] = cmd
+	b[nlmsgHdrLen+1] = 1
+	b = encodeAttrs(b, attrs)
+	binary.NativeEndian.PutUint32(b[0:], uint32(len(b)))
+	return b
+}
+
+// parseGenlResponse returns attributes of the generic netlink message in b
+// answering request seq. An error message from the kernel is returned as its
+// errno.
+func parseGenlResponse(b []byte, seq uint32) ([]netlinkAttr, error) {
+	for len(b) >= nlmsgHdrLen {
+		n := int(binary.NativeEndian.Uint32(b))
+		if n < nlmsgHdrLen || n > len(b) {
+			return nil, fmt.Errorf("bad netlink message length %d", n)
+		}
+		typ := binary.NativeEndian.Uint16(b[4:])
+		msg := b[nlmsgHdrLen:n]
+		if binary.NativeEndian.Uint32(b[8:]) == seq {
+			switch {
+			case typ == syscall.NLMSG_ERROR:
+				if len(msg) < 4 {
+					return nil, errors.New("short netlink error message")
+				}
+				if code := int32(binary.NativeEndian.Uint32(msg)); code != 0 {
+					return nil, syscall.Errno(-code)
+				}
+			case typ >= syscall.NLMSG_MIN_TYPE:
+				if len(msg) < genlHdrLen {
+					return nil, errors.New("short generic netlink message")
+				}
+				return parseAttrs(msg[genlHdrLen:])
+			}
+		}
+		if nlaAlign(n) >= len(b) {
+			break
+		}
+		b = b[nlaAlign(n):]
+	}
+	return nil, errNoNetlinkAnswer
+}
+
+// taskstatsFromAttrs finds stats in a response to TASKSTATS_CMD_GET.
+func taskstatsFromAttrs(attrs []netlinkAttr) (*TaskDelays, error) {
+	for _, a := range attrs {
+		if a.Type != taskstatsTypeAggrTGID && a.Type != taskstatsTypeAggrPID {
+			continue
+		}
+		nested, err := parseAttrs(a.Data)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nested {
+			if n.Type == taskstatsTypeStats {
+				return parseTaskstats(n.Data)
+			}
+		}
+	}
+	return nil, errNoTaskstats
+}
+
+// TaskstatsClient queries taskstats of processes over generic netlink. A
+// single socket is shared by all monitors, one query at a time.
+type TaskstatsClient struct {
+	fd     int
+	family uint16
+	seq    uint32
+	buf    []byte
+	mtx    sync.Mutex
+}
+
+// NewTaskstatsClient opens a netlink socket and looks up the taskstats family,
+// which fails on kernels built without taskstats. Looking up the family needs
+// no privilege, while querying stats needs CAP_NET_ADMIN, so we query our own
+// stats once to tell whether we may.
+func NewTaskstatsClient() (*TaskstatsClient, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %v", err)
+	}
+	c := &TaskstatsClient{fd: fd, buf: make([]byte, 1<<16)}
+	tv := syscall.NsecToTimeval(TaskstatsTimeout.Nanoseconds())
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("netlink socket: %v", err)
+	}
+	attrs, err := c.request(genlIDCtrl, ctrlCmdGetFamily, []netlinkAttr{
+		{Type: ctrlAttrFamilyName, Data: append([]byte(taskstatsGenlName), 0)},
+	})
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("taskstats family: %v", err)
+	}
+	for _, a := range attrs {
+		if a.Type == ctrlAttrFamilyID && len(a.Data) >= 2 {
+			c.family = binary.NativeEndian.Uint16(a.Data)
+		}
+	}
+	if c.family == 0 {
+		c.Close()
+		return nil, errors.New("taskstats family: no family ID")
+	}
+	if _, err = c.Delays(os.Getpid()); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("taskstats query: %v", err)
+	}
+	return c, nil
+}
+
+// request sends a request and waits for an answer to it. Callers hold the
+// lock, except while the client is being created.
+func (c *TaskstatsClient) request(family uint16, cmd uint8, attrs []netlinkAttr) ([]netlinkAttr, error) {
+	c.seq++
+	err := syscall.Sendto(c.fd, genlRequest(family, cmd, c.seq, attrs), 0,
+		&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, c.buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := parseGenlResponse(c.buf[:n], c.seq)
+		// Answers to earlier requests which timed out may still arrive,
+		// those we skip.
+		if err == errNoNetlinkAnswer {
+			continue
+		}
+		return attrs, err
+	}
+}
+
+// Delays returns delay accounting figures of process with given PID, summed
+// over all of its threads.
+func (c *TaskstatsClient) Delays(pid int) (*TaskDelays, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var data [4]byte
+	binary.NativeEndian.PutUint32(data[:], uint32(pid))
+	attrs, err := c.request(c.family, taskstatsCmdGet, []netlinkAttr{
+		{Type: taskstatsCmdAttrTGID, Data: data[:]},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return taskstatsFromAttrs(attrs)
+}
+
+// Close closes the netlink socket.
+func (c *TaskstatsClient) Close() error {
+	return syscall.Close(c.fd)
+}
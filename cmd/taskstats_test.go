@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// synthTaskstats returns struct taskstats of given version and size, with
+// counts of delays 1 to 5 and totals of them in milliseconds.
+func synthTaskstats(version uint16, size int) []byte {
+	b := make([]byte, size)
+	binary.NativeEndian.PutUint16(b, version)
+	binary.NativeEndian.PutUint64(b[64:], 9e9)
+	for i, off := range []int{16, 32, 48, 312, 328} {
+		if off+16 > size {
+			break
+		}
+		binary.NativeEndian.PutUint64(b[off:], uint64(i+1))
+		binary.NativeEndian.PutUint64(b[off+8:], uint64(i+1)*1e6)
+	}
+	return b
+}
+
+// synthMessage wraps attributes in a generic netlink message.
+func synthMessage(typ uint16, seq uint32, attrs []netlinkAttr) []byte {
+	b := genlRequest(typ, 0, seq, attrs)
+	binary.NativeEndian.PutUint16(b[6:], 0)
+	return b
+}
+
+func Test_parseTaskstats(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		wantFreepages uint64
+		wantThrashing uint64
+		wantErr       bool
+	}{
+		{name: "version 14", data: synthTaskstats(14, 432), wantFreepages: 4e6, wantThrashing: 5e6},
+		{name: "version 8 has no thrashing", data: synthTaskstats(8, 328), wantFreepages: 4e6},
+		{name: "version 4", data: synthTaskstats(4, 312)},
+		{name: "too short", data: synthTaskstats(4, 100), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTaskstats(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTaskstats() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.CPU.Count != 1 || got.BlkIO.TotalNs != 2e6 || got.Swapin.Count != 3 || got.CPURunNs != 9e9 {
+				t.Errorf("parseTaskstats() = %+v", got)
+			}
+			if got.Freepages.TotalNs != tt.wantFreepages || got.Thrashing.TotalNs != tt.wantThrashing {
+				t.Errorf("parseTaskstats() freepages %v and thrashing %v, want %v and %v",
+					got.Freepages.TotalNs, got.Thrashing.TotalNs, tt.wantFreepages, tt.wantThrashing)
+			}
+		})
+	}
+}
+
+func Test_parseGenlResponse(t *testing.T) {
+	var pid [4]byte
+	binary.NativeEndian.PutUint32(pid[:], 4242)
+	aggr := encodeAttrs(nil, []netlinkAttr{
+		{Type: 2, Data: pid[:]},
+		{Type: taskstatsTypeStats, Data: synthTaskstats(14, 432)},
+	})
+	stats := synthMessage(27, 7, []netlinkAttr{{Type: taskstatsTypeAggrTGID | 0x8000, Data: aggr}})
+
+	// An error carries errno, negated, followed by the request it refers to.
+	errMsg := make([]byte, nlmsgHdrLen+4)
+	binary.NativeEndian.PutUint32(errMsg, uint32(len(errMsg)))
+	binary.NativeEndian.PutUint16(errMsg[4:], syscall.NLMSG_ERROR)
+	binary.NativeEndian.PutUint32(errMsg[8:], 8)
+	errno := -int32(syscall.ESRCH)
+	binary.NativeEndian.PutUint32(errMsg[nlmsgHdrLen:], uint32(errno))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		seq     uint32
+		wantErr error
+	}{
+		{name: "stats of a process", data: stats, seq: 7},
+		{name: "stale answer is skipped", data: append(append([]byte(nil), errMsg...), stats...), seq: 7},
+		{name: "no such process", data: errMsg, seq: 8, wantErr: syscall.ESRCH},
+		{name: "answer to another request", data: stats, seq: 9, wantErr: errNoNetlinkAnswer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, err := parseGenlResponse(tt.data, tt.seq)
+			if err != tt.wantErr {
+				t.Fatalf("parseGenlResponse() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			td, err := taskstatsFromAttrs(attrs)
+			if err != nil || td.Thrashing.Count != 5 {
+				t.Errorf("taskstatsFromAttrs() = %+v, %v", td, err)
+			}
+		})
+	}
+	if _, err := taskstatsFromAttrs(nil); err != errNoTaskstats {
+		t.Errorf("taskstatsFromAttrs() error = %v, want %v", err, errNoTaskstats)
+	}
+	if _, err := parseAttrs([]byte{200, 0, 1, 0}); err == nil {
+		t.Errorf("parseAttrs() accepted attribute longer than its message")
+	}
+}
+
+func Test_genlRequest(t *testing.T) {
+	b := genlRequest(27, taskstatsCmdGet, 3, []netlinkAttr{{Type: taskstatsCmdAttrTGID, Data: []byte{1, 2, 3, 4}}, {Type: 9, Data: []byte("ab")}})
+	if n := binary.NativeEndian.Uint32(b); int(n) != len(b) || len(b) != nlmsgHdrLen+genlHdrLen+8+8 {
+		t.Fatalf("genlRequest() length %d in header, %d bytes, want %d", n, len(b), nlmsgHdrLen+genlHdrLen+16)
+	}
+	attrs, err := parseAttrs(b[nlmsgHdrLen+genlHdrLen:])
+	if err != nil || len(attrs) != 2 || attrs[0].Type != taskstatsCmdAttrTGID || string(attrs[1].Data) != "ab" {
+		t.Errorf("genlRequest() attributes = %+v, %v", attrs, err)
+	}
+}
+
+func TestTaskDelays_updateSince(t *testing.T) {
+	prev, _ := parseTaskstats(synthTaskstats(14, 432))
+	cur, _ := parseTaskstats(synthTaskstats(14, 432))
+	cur.BlkIO.TotalNs += 250e6
+	cur.CPU.TotalNs = 0
+	cur.updateSince(prev, time.Second)
+	if cur.BlkIO.Share != 0.25 || cur.Swapin.Share != 0 || !math.IsNaN(cur.CPU.Share) {
+		t.Errorf("TaskDelays.updateSince() = %+v", cur)
+	}
+	cur.updateSince(nil, time.Second)
+	if safe := cur.safe(); safe.BlkIO.Share != -1 || !math.IsNaN(cur.BlkIO.Share) {
+		t.Errorf("TaskDelays.safe() = %+v, want -1 shares without previous sample", safe)
+	}
+	out := cur.String(`role="worker-1",pid="1"`)
+	want := `bro_task_delay_seconds_total{role="worker-1",pid="1",delay="blkio"} 0.252`
+	if !strings.Contains(out, want) {
+		t.Errorf("TaskDelays.String() is missing %s:\n%s", want, out)
+	}
+}
+
+func Test_newRoleDelays(t *testing.T) {
+	reps := []*IntervalReport{
+		{Delays: &TaskDelays{Version: 14, CPURunNs: 3e9,
+			CPU:   Delay{Count: 10, TotalNs: 1e9, Share: 0.1},
+			BlkIO: Delay{Count: 2, TotalNs: 5e8, Share: math.NaN()}}},
+		{},
+		{Delays: &TaskDelays{Version: 9, CPURunNs: 1e9,
+			CPU:   Delay{Count: 5, TotalNs: 2e9, Share: 0.2},
+			BlkIO: Delay{Count: 1, TotalNs: 1e8, Share: math.NaN()}}},
+	}
+	got := newRoleDelays(reps)
+	if got == nil || got.Version != 9 || got.CPURunNs != 4e9 || got.CPU.Count != 15 ||
+		got.CPU.TotalNs != 3e9 || !tolerance(got.CPU.Share, 0.3, 1e-9) || got.BlkIO.Count != 3 {
+		t.Fatalf("newRoleDelays() = %+v, want sums over instances", got)
+	}
+	if !math.IsNaN(got.BlkIO.Share) {
+		t.Errorf("newRoleDelays() BlkIO.Share = %v, want NaN when no instance has one", got.BlkIO.Share)
+	}
+	if newRoleDelays(reps[1:2]) != nil {
+		t.Errorf("newRoleDelays() is not nil without delays")
+	}
+	rs := newRoleSummary("worker-1", reps)
+	want := `bro_role_task_delays_total{role="worker-1",delay="cpu"} 15`
+	if out := rs.String(); !strings.Contains(out, want) {
+		t.Errorf("RoleSummary.String() is missing %s:\n%s", want, out)
+	}
+}
+
+func TestTaskstatsClient_Delays(t *testing.T) {
+	c, err := NewTaskstatsClient()
+	if err != nil {
+		t.Skipf("taskstats is not available: %v", err)
+	}
+	defer c.Close()
+	td, err := c.Delays(os.Getpid())
+	if err != nil {
+		t.Fatalf("TaskstatsClient.Delays() of own process failed with: %v, after NewTaskstatsClient() succeeded", err)
+	}
+	if td.Version == 0 || td.CPURunNs == 0 {
+		t.Errorf("TaskstatsClient.Delays() = %+v, want version and CPU time", td)
+	}
+	if _, err := c.Delays(1 << 30); err != syscall.ESRCH {
+		t.Errorf("TaskstatsClient.Delays() error = %v, want %v", err, syscall.ESRCH)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TelemetryInterval is how often we scrape telemetry of Zeek nodes.
+const TelemetryInterval = 15 * time.Second
+
+// TelemetryTimeout is how long we wait for a node to serve its telemetry.
+const TelemetryTimeout = 5 * time.Second
+
+// telemetryEndpoints maps roles to URLs where they serve Prometheus telemetry.
+// It is populated from metrics_port in node.cfg and from -telemetry flags, the
+// latter taking precedence.
+type telemetryEndpoints map[string]string
+
+func (te telemetryEndpoints) String() string {
+	var l []string
+	for role, url := range te {
+		l = append(l, role+"="+url)
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (te telemetryEndpoints) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected role=url, got %q", v)
+	}
+	te[parts[0]] = parts[1]
+	return nil
+}
+
+// MetricFamily is a group of samples sharing a name along with its metadata.
+// Samples of histograms and summaries have suffixes, such as _bucket, but are
+// still part of the family named in the TYPE line.
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []string
+}
+
+// parseExposition parses Prometheus text exposition format into families,
+// adding role label to every sample. Families are returned in the order they
+// appear.
+func parseExposition(r io.Reader, role string) ([]*MetricFamily, error) {
+	var families []*MetricFamily
+	var byName = make(map[string]*MetricFamily)
+	var family = func(name string) *MetricFamily {
+		f, ok := byName[name]
+		if !ok {
+			f = &MetricFamily{Name: name}
+			byName[name] = f
+			families = append(families, f)
+		}
+		return f
+	}
+	var cur *MetricFamily
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+				continue
+			}
+			cur = family(fields[2])
+			var text string
+			if len(fields) == 4 {
+				text = fields[3]
+			}
+			if fields[1] == "HELP" {
+				cur.Help = text
+			} else {
+				cur.Type = text
+			}
+			continue
+		}
+		name, sample, err := relabelSample(line, role)
+		if err != nil {
+			return nil, err
+		}
+		if cur == nil || !inFamily(name, cur.Name) {
+			cur = family(name)
+		}
+		cur.Samples = append(cur.Samples, sample)
+	}
+	return families, scanner.Err()
+}
+
+// inFamily tells whether a sample named name belongs to family.
+func inFamily(name, family string) bool {
+	switch strings.TrimPrefix(name, family) {
+	case "", "_bucket", "_sum", "_count", "_total", "_created":
+		return strings.HasPrefix(name, family)
+	}
+	return false
+}
+
+// relabelSample adds role label in front of any labels of a sample, replacing
+// role label the sample may already have, and returns the sample's name along
+// with the relabeled sample.
+func relabelSample(line, role string) (string, string, error) {
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return "", "", fmt.Errorf("bad sample %q", line)
+	}
+	name, rest := line[:end], line[end:]
+	var labels []string
+	if strings.HasPrefix(rest, "{") {
+		var closing = -1
+		var quoted, escaped bool
+		for i := 1; i < len(rest) && closing < 0; i++ {
+			switch c := rest[i]; {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				quoted = !quoted
+			case c == '}' && !quoted:
+				closing = i
+			}
+		}
+		if closing < 0 {
+			return "", "", fmt.Errorf("bad labels in sample %q", line)
+		}
+		labels = splitLabels(rest[1:closing])
+		rest = rest[closing+1:]
+	}
+	var relabeled = []string{fmt.Sprintf("role=\"%s\"", role)}
+	for _, l := range labels {
+		if !strings.HasPrefix(l, "role=") {
+			relabeled = append(relabeled, l)
+		}
+	}
+	value := strings.TrimSpace(rest)
+	if value == "" {
+		return "", "", fmt.Errorf("sample without value %q", line)
+	}
+	return name, fmt.Sprintf("%s{%s} %s", name, strings.Join(relabeled, ","), value), nil
+}
+
+// splitLabels splits label pairs on commas outside of quoted values.
+func splitLabels(s string) []string {
+	var l []string
+	var start int
+	var quoted, escaped bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			if p := strings.TrimSpace(s[start:i]); p != "" {
+				l = append(l, p)
+			}
+			start = i + 1
+		}
+	}
+	if p := strings.TrimSpace(s[start:]); p != "" {
+		l = append(l, p)
+	}
+	return l
+}
+
+// telemetryScrape is the outcome of the most recent scrape of a role.
+type telemetryScrape struct {
+	families []*MetricFamily
+	duration time.Duration
+	err      error
+}
+
+// TelemetryScraper periodically scrapes telemetry of Zeek nodes and merges it
+// into a single exposition, so that a sensor is one scrape target.
+type TelemetryScraper struct {
+	client    *http.Client
+	endpoints telemetryEndpoints
+	scrapes   map[string]telemetryScrape
+	mtx       sync.RWMutex
+}
+
+// NewTelemetryScraper returns a scraper of given endpoints.
+func NewTelemetryScraper(endpoints telemetryEndpoints, timeout time.Duration) *TelemetryScraper {
+	return &TelemetryScraper{
+		client:    &http.Client{Timeout: timeout},
+		endpoints: endpoints,
+		scrapes:   make(map[string]telemetryScrape),
+	}
+}
+
+func (ts *TelemetryScraper) scrape(role, url string) telemetryScrape {
+	start := time.Now()
+	resp, err := ts.client.Get(url)
+	if err != nil {
+		return telemetryScrape{duration: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return telemetryScrape{duration: time.Since(start),
+			err: fmt.Errorf("scraping %s of %s: %s", url, role, resp.Status)}
+	}
+	families, err := parseExposition(resp.Body, role)
+	if err != nil {
+		err = fmt.Errorf("scraping %s of %s: %v", url, role, err)
+	}
+	return telemetryScrape{families: families, duration: time.Since(start), err: err}
+}
+
+// Collect scrapes every endpoint concurrently. Errors of individual endpoints
+// are returned together, but do not prevent others from being scraped.
+func (ts *TelemetryScraper) Collect() error {
+	var wg sync.WaitGroup
+	var results = make(map[string]telemetryScrape, len(ts.endpoints))
+	var mtx sync.Mutex
+	for role, url := range ts.endpoints {
+		wg.Add(1)
+		go func(role, url string) {
+			defer wg.Done()
+			res := ts.scrape(role, url)
+			mtx.Lock()
+			results[role] = res
+			mtx.Unlock()
+		}(role, url)
+	}
+	wg.Wait()
+	ts.mtx.Lock()
+	ts.scrapes = results
+	ts.mtx.Unlock()
+	var errs []string
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// String returns telemetry of all nodes merged into one exposition, with HELP
+// and TYPE of each family written once, followed by whether each node was
+// scraped successfully.
+func (ts *TelemetryScraper) String() string {
+	ts.mtx.RLock()
+	defer ts.mtx.RUnlock()
+	var roles []string
+	for role := range ts.scrapes {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	var merged = make(map[string]*MetricFamily)
+	var names []string
+	for _, role := range roles {
+		for _, f := range ts.scrapes[role].families {
+			m, ok := merged[f.Name]
+			if !ok {
+				m = &MetricFamily{Name: f.Name, Help: f.Help, Type: f.Type}
+				merged[f.Name] = m
+				names = append(names, f.Name)
+			}
+			m.Samples = append(m.Samples, f.Samples...)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := merged[name]
+		if f.Help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", f.Name, f.Help)
+		}
+		if f.Type != "" {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", f.Name, f.Type)
+		}
+		for _, s := range f.Samples {
+			b.WriteString(s + "\n")
+		}
+	}
+	for _, role := range roles {
+		up := 1
+		if ts.scrapes[role].err != nil {
+			up = 0
+		}
+		fmt.Fprintf(&b, "bro_telemetry_up{role=\"%s\"} %d\n", role, up)
+		fmt.Fprintf(&b, "bro_telemetry_scrape_seconds{role=\"%s\"} %g\n",
+			role, ts.scrapes[role].duration.Seconds())
+	}
+	return b.String()
+}
+
+// startTelemetryScraper scrapes telemetry until context is cancelled. Without
+// any endpoints configured there is nothing to do.
+func startTelemetryScraper(ctx context.Context, ts *TelemetryScraper) {
+	if len(ts.endpoints) == 0 {
+		return
+	}
+	tick := time.NewTicker(TelemetryInterval)
+	defer tick.Stop()
+	for {
+		if err := ts.Collect(); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
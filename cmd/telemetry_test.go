@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const zeekTelemetrySample = `# HELP zeek_active_sessions Active Zeek sessions
+# TYPE zeek_active_sessions gauge
+zeek_active_sessions{endpoint="worker-1",protocol="tcp"} 500
+zeek_active_sessions{endpoint="worker-1",protocol="udp"} 20
+# HELP zeek_event_handler_invocations_total Number of times the given event handler was called
+# TYPE zeek_event_handler_invocations_total counter
+zeek_event_handler_invocations_total{endpoint="worker-1",name="connection_state_remove"} 1234
+# TYPE zeek_log_writer_write_seconds histogram
+zeek_log_writer_write_seconds_bucket{le="0.1"} 5
+zeek_log_writer_write_seconds_bucket{le="+Inf"} 6
+zeek_log_writer_write_seconds_sum 0.5
+zeek_log_writer_write_seconds_count 6
+process_open_fds 42
+`
+
+func Test_relabelSample(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantName string
+		want     string
+		wantErr  bool
+	}{
+		{`zeek_x{endpoint="w1",a="b"} 1`, "zeek_x", `zeek_x{role="worker-1",endpoint="w1",a="b"} 1`, false},
+		{`zeek_x 1.5 1600000000000`, "zeek_x", `zeek_x{role="worker-1"} 1.5 1600000000000`, false},
+		{`zeek_x{role="old",msg="a,b}c"} 2`, "zeek_x", `zeek_x{role="worker-1",msg="a,b}c"} 2`, false},
+		{`zeek_x{a="b"`, "", "", true},
+		{`zeek_x{a="b"}`, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			name, got, err := relabelSample(tt.line, "worker-1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("relabelSample() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if name != tt.wantName || got != tt.want {
+				t.Errorf("relabelSample() = %v, %v, want %v, %v", name, got, tt.wantName, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseExposition(t *testing.T) {
+	families, err := parseExposition(strings.NewReader(zeekTelemetrySample), "worker-1")
+	if err != nil {
+		t.Fatalf("parseExposition() error = %v", err)
+	}
+	var names []string
+	var counts []int
+	for _, f := range families {
+		names = append(names, f.Name)
+		counts = append(counts, len(f.Samples))
+	}
+	wantNames := []string{"zeek_active_sessions", "zeek_event_handler_invocations_total",
+		"zeek_log_writer_write_seconds", "process_open_fds"}
+	if !reflect.DeepEqual(names, wantNames) || !reflect.DeepEqual(counts, []int{2, 1, 4, 1}) {
+		t.Errorf("parseExposition() = %v with %v samples, want %v with [2 1 4 1]", names, counts, wantNames)
+	}
+	if families[2].Type != "histogram" || families[0].Help != "Active Zeek sessions" {
+		t.Errorf("parseExposition() lost metadata: %+v, %+v", families[0], families[2])
+	}
+}
+
+func TestTelemetryScraper_Collect(t *testing.T) {
+	var served = func(role string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, strings.Replace(zeekTelemetrySample, "worker-1", role, -1))
+		}))
+	}
+	w1, w2 := served("worker-1"), served("worker-2")
+	defer w1.Close()
+	defer w2.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	ts := NewTelemetryScraper(telemetryEndpoints{
+		"worker-1": w1.URL,
+		"worker-2": w2.URL,
+		"proxy-1":  broken.URL,
+	}, time.Second)
+	if err := ts.Collect(); err == nil || !strings.Contains(err.Error(), "proxy-1") {
+		t.Errorf("TelemetryScraper.Collect() error = %v, want error of proxy-1", err)
+	}
+	got := ts.String()
+	if n := strings.Count(got, "# TYPE zeek_active_sessions gauge\n"); n != 1 {
+		t.Errorf("TelemetryScraper.String() has %d TYPE lines of a family, want 1:\n%s", n, got)
+	}
+	for _, want := range []string{
+		`zeek_active_sessions{role="worker-1",endpoint="worker-1",protocol="tcp"} 500`,
+		`zeek_active_sessions{role="worker-2",endpoint="worker-2",protocol="tcp"} 500`,
+		`zeek_log_writer_write_seconds_sum{role="worker-2"} 0.5`,
+		`bro_telemetry_up{role="proxy-1"} 0`,
+		`bro_telemetry_up{role="worker-1"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TelemetryScraper.String() is missing %s:\n%s", want, got)
+		}
+	}
+	// Samples of a family must follow its TYPE line without interruption.
+	i := strings.Index(got, "# TYPE zeek_active_sessions")
+	j := strings.Index(got, "# HELP zeek_event_handler_invocations_total")
+	if block := got[i:j]; strings.Count(block, "zeek_active_sessions{") != 4 {
+		t.Errorf("TelemetryScraper.String() did not group samples of a family:\n%s", got)
+	}
+}
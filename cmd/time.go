@@ -1,39 +1,123 @@
 package main
 
-/*
-#include <time.h>
-#include <unistd.h>
-extern int clock_gettime(clockid_t clock_id, struct timespec *tp);
-extern long sysconf(int name);
-*/
-import "C"
-
 import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
+// defaultClockTicks is USER_HZ on every architecture Linux runs on today, and
+// is what we assume if the kernel does not tell us otherwise.
+const defaultClockTicks = 100
+
+// clockMonotonic is CLOCK_MONOTONIC clock ID, which syscall does not have.
+const clockMonotonic = 1
+
+// atClkTck is the auxiliary vector entry with clock tick rate, which is where
+// sysconf(_SC_CLK_TCK) in libc gets it from.
+const atClkTck = 17
+
+// clockTicks is number of clock ticks per second, which is what process times
+// in stat are counted in. It is read once at startup.
+var clockTicks = readClockTicks("/proc/self/auxv")
+
+// parseAuxvClockTicks finds clock tick rate in contents of /proc/self/auxv,
+// which are pairs of type and value, each a native word.
+func parseAuxvClockTicks(data []byte) (int64, bool) {
+	const word = strconv.IntSize / 8
+	for len(data) >= 2*word {
+		var typ, val uint64
+		if word == 8 {
+			typ, val = binary.NativeEndian.Uint64(data), binary.NativeEndian.Uint64(data[word:])
+		} else {
+			typ, val = uint64(binary.NativeEndian.Uint32(data)), uint64(binary.NativeEndian.Uint32(data[word:]))
+		}
+		if typ == 0 {
+			break
+		}
+		if typ == atClkTck && val > 0 {
+			return int64(val), true
+		}
+		data = data[2*word:]
+	}
+	return 0, false
+}
+
+func readClockTicks(path string) int64 {
+	data, err := ReadFileNoStat(path)
+	if err != nil {
+		return defaultClockTicks
+	}
+	if hz, ok := parseAuxvClockTicks(data); ok {
+		return hz
+	}
+	return defaultClockTicks
+}
+
 func ticksToNsecs(ticks int64) int64 {
-	var hz_per_sec_c C.long
-	var nsecs int64
-	hz_per_sec_c = C.sysconf(C._SC_CLK_TCK)
-	nsecs = (1e9 * ticks / int64(hz_per_sec_c))
-	return nsecs
+	return 1e9 * ticks / clockTicks
 }
 
-func monotonicClockTicks() int64 {
-	var ts C.struct_timespec
-	var hz_per_sec_c C.long
-	var ns int64
-	C.clock_gettime(C.CLOCK_MONOTONIC, &ts)
-	hz_per_sec_c = C.sysconf(C._SC_CLK_TCK)
+func nsecsToTicks(ns int64) int64 {
+	return ns * clockTicks / 1e9
+}
 
-	ns = int64(ts.tv_sec) * 1e9
-	ns += int64(ts.tv_nsec)
-	return (ns * int64(hz_per_sec_c)) / 1e9
+// Clock tells time since boot, which is what start times of processes are
+// relative to. ProcInfo takes one, so that tests can decide what time it is.
+type Clock interface {
+	SinceBoot() time.Duration
+}
+
+// systemClock reads monotonic clock of the kernel once, and from then on adds
+// however much time passed since, as measured by monotonic clock Go keeps in
+// time.Time, which is the same clock.
+type systemClock struct {
+	boot time.Duration
+	at   time.Time
+}
+
+func newSystemClock() *systemClock {
+	var ts syscall.Timespec
+	at := time.Now()
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0); errno == 0 {
+		return &systemClock{boot: time.Duration(ts.Nano()), at: at}
+	}
+	return &systemClock{boot: readUptime("/proc/uptime"), at: at}
+}
+
+func (c *systemClock) SinceBoot() time.Duration {
+	return c.boot + time.Since(c.at)
+}
+
+// readUptime returns uptime from /proc/uptime, with a resolution of 10ms,
+// which only matters if we cannot read monotonic clock directly.
+func readUptime(path string) time.Duration {
+	data, err := ReadFileNoStat(path)
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(bytes.TrimSpace(data)))
+	if len(fields) == 0 {
+		return 0
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// systemBootClock is the clock used unless a ProcInfo is given another one.
+var systemBootClock Clock = newSystemClock()
+
+func monotonicClockTicks() int64 {
+	return nsecsToTicks(monotonicSinceBoot().Nanoseconds())
 }
 
 func monotonicSinceBoot() time.Duration {
-	var ts C.struct_timespec
-	C.clock_gettime(C.CLOCK_MONOTONIC, &ts)
-	return time.Duration(int64(ts.tv_sec*1e9) + int64(ts.tv_nsec))
+	return systemBootClock.SinceBoot()
 }
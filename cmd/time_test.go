@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/binary"
+	"path/filepath"
+	"strconv"
+	"testing"
 	"time"
 )
-import "testing"
 
 func Test_ticksToNsecs(t *testing.T) {
 	type args struct {
@@ -73,3 +76,66 @@ func timeIsIncreasing(samples []time.Duration) bool {
 	}
 	return true
 }
+
+// auxv returns contents of an auxiliary vector with given pairs, ending with
+// AT_NULL.
+func auxv(pairs ...uint64) []byte {
+	const word = strconv.IntSize / 8
+	var b []byte
+	for _, v := range append(pairs, 0, 0) {
+		w := make([]byte, word)
+		if word == 8 {
+			binary.NativeEndian.PutUint64(w, v)
+		} else {
+			binary.NativeEndian.PutUint32(w, uint32(v))
+		}
+		b = append(b, w...)
+	}
+	return b
+}
+
+func Test_parseAuxvClockTicks(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		want   int64
+		wantOk bool
+	}{
+		// AT_PAGESZ, AT_CLKTCK, AT_PHDR.
+		{name: "USER_HZ of 100", data: auxv(6, 4096, 17, 100, 3, 0x400040), want: 100, wantOk: true},
+		{name: "USER_HZ of 1024", data: auxv(17, 1024), want: 1024, wantOk: true},
+		{name: "no AT_CLKTCK", data: auxv(6, 4096)},
+		{name: "empty", data: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseAuxvClockTicks(tt.data)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseAuxvClockTicks() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+	if got := readClockTicks("/nonexistent/auxv"); got != defaultClockTicks {
+		t.Errorf("readClockTicks() = %v, want %v without auxv", got, defaultClockTicks)
+	}
+}
+
+func Test_readUptime(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{"uptime": "2730.06 2298.03\n", "bad": "soon\n"})
+	if got := readUptime(filepath.Join(root, "uptime")); got != 2730060*time.Millisecond {
+		t.Errorf("readUptime() = %v, want 45m30.06s", got)
+	}
+	if got := readUptime(filepath.Join(root, "bad")); got != 0 {
+		t.Errorf("readUptime() = %v, want 0 for bad uptime", got)
+	}
+}
+
+func Test_systemClock(t *testing.T) {
+	c := newSystemClock()
+	uptime := readUptime("/proc/uptime")
+	// Uptime is truncated to 10ms, and was read a little later.
+	if got := c.SinceBoot(); got < uptime-time.Second || got > uptime+time.Second {
+		t.Errorf("systemClock.SinceBoot() = %v, want about %v", got, uptime)
+	}
+}
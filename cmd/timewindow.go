@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// SampleInterval is how often a monitor samples the process it watches.
+const SampleInterval = time.Second
+
+// maxSampleGap is the longest time between two samples we still consider
+// continuous. Anything longer, for example because the host was too busy to
+// run the monitor, is a gap.
+const maxSampleGap = 2 * SampleInterval
+
+// timedSample is a value along with time it was sampled at. A NaN value marks
+// a sample we failed to take. Samples of CPU rate also carry the process'
+// cumulative time on CPU and run time, which are NaN otherwise, along with the
+// source of those times, see CPUTimes.
+type timedSample struct {
+	at      time.Time
+	v       float64
+	onCPU   float64
+	runTime float64
+	source  string
+}
+
+// SampleSeries keeps timestamped samples going back as far as the longest of
+// windows statistics are computed over.
+type SampleSeries struct {
+	keep    time.Duration
+	samples []timedSample
+}
+
+// NewSampleSeries returns a series keeping samples for keep.
+func NewSampleSeries(keep time.Duration) *SampleSeries {
+	return &SampleSeries{keep: keep}
+}
+
+// Add appends a sample taken at time at, dropping those no longer needed.
+func (ss *SampleSeries) Add(at time.Time, v float64) {
+	ss.add(timedSample{at: at, v: v, onCPU: math.NaN(), runTime: math.NaN()})
+}
+
+// AddTimes appends a sample of CPU rate along with CPU times it was computed
+// from, which gives us rate over a whole window, see WindowStats.
+func (ss *SampleSeries) AddTimes(at time.Time, v float64, onCPU, runTime int64, source string) {
+	ss.add(timedSample{at: at, v: v, onCPU: float64(onCPU), runTime: float64(runTime), source: source})
+}
+
+// Reset drops all samples, such as when the process they were taken of was
+// replaced by another one.
+func (ss *SampleSeries) Reset() {
+	ss.samples = nil
+}
+
+func (ss *SampleSeries) add(s timedSample) {
+	ss.samples = append(ss.samples, s)
+	at := s.at
+	var cutoff = at.Add(-ss.keep)
+	var i int
+	for i < len(ss.samples) && !ss.samples[i].at.After(cutoff) {
+		i++
+	}
+	ss.samples = ss.samples[i:]
+}
+
+// WindowStats are statistics of samples taken within a window of wall-clock
+// time. Samples is the number of samples with a value, and Gaps the number of
+// samples we failed to take plus the number of times samples were further
+// apart than maxSampleGap. Coverage is the share of the window for which we
+// have continuous samples. Rate is the share of the window's run time the
+// process spent on CPU, computed from CPU times at both ends of the window,
+// and is NaN unless the series has CPU times.
+type WindowStats struct {
+	Window      time.Duration `json:"window"`
+	Rate        float64       `json:"rate"`
+	Samples     int           `json:"samples"`
+	Gaps        int           `json:"gaps"`
+	Coverage    float64       `json:"coverage"`
+	Mean        float64       `json:"mean"`
+	StandardDev float64       `json:"standard_dev"`
+	Min         float64       `json:"min"`
+	Max         float64       `json:"max"`
+}
+
+// Window computes statistics of samples taken within length before now.
+func (ss *SampleSeries) Window(length time.Duration, now time.Time) WindowStats {
+	var st = WindowStats{Window: length, Rate: math.NaN(), Min: math.NaN(), Max: math.NaN()}
+	var first, last *timedSample
+	var cutoff = now.Add(-length)
+	var values []float64
+	var prev *timedSample
+	var covered time.Duration
+	for i := range ss.samples {
+		s := &ss.samples[i]
+		if !s.at.After(cutoff) || s.at.After(now) {
+			continue
+		}
+		if !math.IsNaN(s.onCPU) {
+			// CPU times going backwards mean we are looking at a different
+			// process than before, so the window starts over with it. So
+			// does a change of source, which changes units of times.
+			if first == nil || s.source != last.source ||
+				s.onCPU < last.onCPU || s.runTime < last.runTime {
+				first = s
+			}
+			last = s
+		}
+		if math.IsNaN(s.v) {
+			st.Gaps++
+		} else {
+			values = append(values, s.v)
+			if len(values) == 1 || s.v < st.Min {
+				st.Min = s.v
+			}
+			if len(values) == 1 || s.v > st.Max {
+				st.Max = s.v
+			}
+		}
+		if prev != nil {
+			dt := s.at.Sub(prev.at)
+			switch {
+			case dt > maxSampleGap:
+				st.Gaps++
+			case !math.IsNaN(s.v) && !math.IsNaN(prev.v):
+				covered += dt
+			}
+		}
+		prev = s
+	}
+	if first != nil && last.runTime > first.runTime {
+		st.Rate = (last.onCPU - first.onCPU) / (last.runTime - first.runTime)
+	}
+	st.Samples = len(values)
+	st.Coverage = covered.Seconds() / length.Seconds()
+	st.Mean = avg(values)
+	if len(values) > 1 {
+		st.StandardDev = stddev(values)
+	} else {
+		st.StandardDev = math.NaN()
+	}
+	return st
+}
+
+// Windows computes statistics over each of the given windows.
+func (ss *SampleSeries) Windows(lengths []time.Duration, now time.Time) []WindowStats {
+	var l = make([]WindowStats, len(lengths))
+	for i, length := range lengths {
+		l[i] = ss.Window(length, now)
+	}
+	return l
+}
+
+func (st WindowStats) safe() WindowStats {
+	for _, v := range []*float64{&st.Rate, &st.Mean, &st.StandardDev, &st.Min, &st.Max} {
+		if math.IsNaN(*v) {
+			*v = -1
+		}
+	}
+	return st
+}
+
+// windowsWithSize replaces the shortest of windows with one n samples long,
+// which is what -window-size used to give.
+func windowsWithSize(windows durations, n uint64) (durations, error) {
+	if n == 0 {
+		return nil, fmt.Errorf("window size must be positive")
+	}
+	var l = durations{time.Duration(n) * SampleInterval}
+	if len(windows) > 0 {
+		l = append(l, windows[1:]...)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	return l, nil
+}
+
+// longest returns the longest of durations, zero if there are none.
+func longest(l []time.Duration) time.Duration {
+	var max time.Duration
+	for _, d := range l {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampleSeries_Window(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	ss := NewSampleSeries(5 * time.Minute)
+	at := start
+	// A minute of samples a second apart, the last ten of which failed,
+	// followed by thirty seconds without any samples, and a single sample.
+	for i := 0; i < 60; i++ {
+		v := 0.5
+		if i >= 50 {
+			v = math.NaN()
+		}
+		if i == 10 {
+			v = 0.9
+		}
+		ss.Add(at, v)
+		at = at.Add(time.Second)
+	}
+	at = at.Add(30 * time.Second)
+	ss.Add(at, 0.1)
+
+	got := ss.Window(2*time.Minute, at)
+	if got.Samples != 51 || got.Gaps != 11 {
+		t.Errorf("SampleSeries.Window() = %d samples and %d gaps, want 51 and 11", got.Samples, got.Gaps)
+	}
+	if !tolerance(got.Coverage, 49.0/120, 1e-9) {
+		t.Errorf("SampleSeries.Window() Coverage = %v, want %v", got.Coverage, 49.0/120)
+	}
+	if got.Min != 0.1 || got.Max != 0.9 || !tolerance(got.Mean, (49*0.5+0.9+0.1)/51, 1e-9) {
+		t.Errorf("SampleSeries.Window() = %+v", got)
+	}
+
+	// Only the most recent sample falls into a short window.
+	got = ss.Window(10*time.Second, at)
+	if got.Samples != 1 || got.Mean != 0.1 || !math.IsNaN(got.StandardDev) {
+		t.Errorf("SampleSeries.Window() = %+v, want a single sample", got)
+	}
+	if safe := got.safe(); safe.StandardDev != -1 {
+		t.Errorf("WindowStats.safe() = %+v, want -1 standard deviation", safe)
+	}
+	got = ss.Window(time.Minute, at.Add(time.Hour))
+	if got.Samples != 0 || !math.IsNaN(got.Mean) {
+		t.Errorf("SampleSeries.Window() = %+v, want no samples", got)
+	}
+}
+
+func TestSampleSeries_Add(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	ss := NewSampleSeries(time.Minute)
+	for i := 0; i < 600; i++ {
+		ss.Add(start.Add(time.Duration(i)*time.Second), 1)
+	}
+	if len(ss.samples) != 60 {
+		t.Errorf("SampleSeries kept %d samples, want 60", len(ss.samples))
+	}
+	now := start.Add(599 * time.Second)
+	windows := ss.Windows([]time.Duration{time.Minute, 15 * time.Minute}, now)
+	if len(windows) != 2 || windows[0].Samples != 60 || windows[1].Samples != 60 {
+		t.Errorf("SampleSeries.Windows() = %+v, want both windows limited by what was kept", windows)
+	}
+	ss.Reset()
+	ss.Add(now.Add(time.Second), 3)
+	if w := ss.Window(time.Minute, now.Add(time.Second)); w.Samples != 1 || w.Mean != 3 {
+		t.Errorf("SampleSeries.Window() = %+v after Reset(), want only the sample added since", w)
+	}
+}
+
+func TestSampleSeries_WindowRate(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	ss := NewSampleSeries(5 * time.Minute)
+	// A process busy a quarter of the time, then restarted and busy half of
+	// the time, with a failed sample in between.
+	var onCPU, runTime int64 = 1000, 4000
+	at := start
+	for i := 0; i < 30; i++ {
+		ss.AddTimes(at, 0.25, onCPU, runTime, cpuSourceTicks)
+		onCPU, runTime = onCPU+25, runTime+100
+		at = at.Add(time.Second)
+	}
+	ss.Add(at, math.NaN())
+	onCPU, runTime = 0, 0
+	for i := 0; i < 10; i++ {
+		at = at.Add(time.Second)
+		ss.AddTimes(at, 0.5, onCPU, runTime, cpuSourceTicks)
+		onCPU, runTime = onCPU+50, runTime+100
+	}
+
+	tests := []struct {
+		name   string
+		length time.Duration
+		want   float64
+	}{
+		{"since restart", time.Minute, 0.5},
+		{"before restart", 20 * time.Second, 0.5},
+		{"no times", time.Millisecond, math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ss.Window(tt.length, at).Rate
+			if math.IsNaN(tt.want) != math.IsNaN(got) || !math.IsNaN(got) && !tolerance(got, tt.want, 1e-9) {
+				t.Errorf("SampleSeries.Window() Rate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Nanoseconds are not comparable with ticks taken before them.
+	ss = NewSampleSeries(time.Minute)
+	for i := 0; i < 10; i++ {
+		ss.AddTimes(start.Add(time.Duration(i)*time.Second), 0.25, int64(25*i), int64(100*i), cpuSourceTicks)
+	}
+	for i := 10; i < 20; i++ {
+		ss.AddTimes(start.Add(time.Duration(i)*time.Second), 0.75, int64(75e7*i), int64(1e9*i), cpuSourceSchedstat)
+	}
+	if got := ss.Window(time.Minute, start.Add(19*time.Second)).Rate; !tolerance(got, 0.75, 1e-9) {
+		t.Errorf("SampleSeries.Window() Rate = %v, want 0.75", got)
+	}
+}
+
+func Test_windowsWithSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows durations
+		n       uint64
+		want    durations
+		wantErr bool
+	}{
+		{"defaults", defaultStatsWindows, 10, durations{10 * time.Second, 5 * time.Minute, 15 * time.Minute}, false},
+		{"longer than the rest", durations{time.Minute, 5 * time.Minute}, 600, durations{5 * time.Minute, 10 * time.Minute}, false},
+		{"none", nil, 30, durations{30 * time.Second}, false},
+		{"zero", defaultStatsWindows, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := windowsWithSize(tt.windows, tt.n)
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("windowsWithSize() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
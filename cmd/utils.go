@@ -5,7 +5,7 @@ import (
 	"io"
 	"log"
 	"os"
-	"strings"
+	"syscall"
 )
 
 func handleErr(e error, doPanic bool) {
@@ -27,22 +27,23 @@ func nullByteToSpace(b []byte) []byte {
 	return b
 }
 
-// isTargetProcess returns true if given pid is referring to executable
-// identified by target, otherwise it returns false.
-func isTargetProcess(pid int, target string) bool {
-	var fileInfo os.FileInfo
-	var exe, lnk string
-	var err error
-
-	lnk = fmt.Sprintf("/proc/%d/exe", pid)
-	if fileInfo, err = os.Lstat(lnk); err != nil {
-		return false
+// exeInode returns inode number of the executable behind given pid, or zero if
+// it cannot be determined. Stat follows the /proc/<pid>/exe link, so this is
+// the inode of what the process is running even if file on disk was since
+// replaced.
+func exeInode(pid int) uint64 {
+	fileInfo, err := os.Stat(hostFS.PIDPath(pid, "exe"))
+	if err != nil {
+		return 0
 	}
-	if fileInfo.Mode()&os.ModeSymlink != 0 {
-		exe, err = os.Readlink(lnk)
-		if err != nil {
-			return false
-		}
+	if st, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
 	}
-	return strings.Compare(target, exe) == 0
+	return 0
+}
+
+// isTargetProcess returns true if given pid is referring to executable
+// identified by target, otherwise it returns false, see exeMatcher.
+func isTargetProcess(pid int, target string) bool {
+	return newExeMatcher(hostFS, target).Matches(hostFS, pid)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"reflect"
 	"testing"
 )
@@ -44,6 +45,10 @@ func Test_nullByteToSpace(t *testing.T) {
 }
 
 func Test_isTargetProcess(t *testing.T) {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Skip("no link to our own executable")
+	}
 	type args struct {
 		pid    int
 		target string
@@ -53,7 +58,9 @@ func Test_isTargetProcess(t *testing.T) {
 		args args
 		want bool
 	}{
-		// TODO: Add test cases.
+		{name: "own executable", args: args{pid: os.Getpid(), target: self}, want: true},
+		{name: "other executable", args: args{pid: os.Getpid(), target: "/usr/local/bin/zeek"}, want: false},
+		{name: "no such process", args: args{pid: 1 << 30, target: self}, want: false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
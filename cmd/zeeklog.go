@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ZeekLogInterval is how often we look for new entries in Zeek's logs. Zeek
+// writes stats.log and capture_loss.log every few minutes, so there is no
+// point in looking more often.
+const ZeekLogInterval = 10 * time.Second
+
+// logRecord is a single log entry with field names mapped to values. Fields
+// which are unset or empty are left out.
+type logRecord map[string]string
+
+// logHeader is what we learn from header of a log written by Zeek's ASCII
+// writer in its default TSV format.
+type logHeader struct {
+	separator string
+	unset     string
+	empty     string
+	fields    []string
+}
+
+func defaultLogHeader() logHeader {
+	return logHeader{separator: "\t", unset: "-", empty: "(empty)"}
+}
+
+// unescapeSeparator turns separator as written in the header, such as \x09,
+// into the separator itself.
+func unescapeSeparator(s string) string {
+	if strings.HasPrefix(s, "\\x") && len(s) == 4 {
+		if n, err := strconv.ParseUint(s[2:], 16, 8); err == nil {
+			return string([]byte{byte(n)})
+		}
+	}
+	return s
+}
+
+// parseLogLine parses a single line of a Zeek log, either TSV or JSON. Header
+// lines update h and do not produce a record.
+func parseLogLine(h *logHeader, line string) (logRecord, bool, error) {
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case line == "":
+		return nil, false, nil
+	case strings.HasPrefix(line, "{"):
+		return parseJSONLogLine(line)
+	case strings.HasPrefix(line, "#separator "):
+		h.separator = unescapeSeparator(strings.TrimPrefix(line, "#separator "))
+		return nil, false, nil
+	case strings.HasPrefix(line, "#"):
+		parts := strings.Split(line, h.separator)
+		switch parts[0] {
+		case "#fields":
+			h.fields = parts[1:]
+		case "#unset_field":
+			if len(parts) > 1 {
+				h.unset = parts[1]
+			}
+		case "#empty_field":
+			if len(parts) > 1 {
+				h.empty = parts[1]
+			}
+		}
+		return nil, false, nil
+	}
+	if len(h.fields) == 0 {
+		return nil, false, fmt.Errorf("log entry before #fields header: %q", line)
+	}
+	values := strings.Split(line, h.separator)
+	if len(values) != len(h.fields) {
+		return nil, false, fmt.Errorf("log entry has %d fields, header has %d", len(values), len(h.fields))
+	}
+	var rec = make(logRecord, len(values))
+	for i, v := range values {
+		if v == h.unset || v == h.empty {
+			continue
+		}
+		rec[h.fields[i]] = v
+	}
+	return rec, true, nil
+}
+
+// parseJSONLogLine parses an entry written by Zeek's ASCII writer with
+// LogAscii::use_json set. Numbers are kept in their shortest form, so that
+// they look the same as in TSV logs.
+func parseJSONLogLine(line string) (logRecord, bool, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		return nil, false, fmt.Errorf("bad JSON log entry: %v", err)
+	}
+	var rec = make(logRecord, len(m))
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			rec[k] = v
+		case float64:
+			rec[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			rec[k] = fmt.Sprint(v)
+		}
+	}
+	return rec, true, nil
+}
+
+// logFollower follows a log file the way tail -F does, surviving rotation,
+// where the file is renamed and a new one created in its place, and
+// truncation.
+type logFollower struct {
+	path    string
+	f       *os.File
+	offset  int64
+	partial string
+	header  logHeader
+}
+
+func newLogFollower(path string) *logFollower {
+	return &logFollower{path: path, header: defaultLogHeader()}
+}
+
+// Poll returns entries added since it was last called. Whatever is left of a
+// file being rotated away is read before moving on to the new file.
+func (lf *logFollower) Poll() ([]logRecord, error) {
+	if lf.f == nil {
+		if err := lf.open(); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+	recs, err := lf.drain()
+	if err != nil {
+		return recs, err
+	}
+	fi, err := os.Stat(lf.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Rotated away and the new file was not created yet.
+			return recs, nil
+		}
+		return recs, err
+	}
+	cur, err := lf.f.Stat()
+	if err != nil {
+		return recs, err
+	}
+	switch {
+	case !os.SameFile(fi, cur):
+		lf.close()
+		if err := lf.open(); err != nil {
+			return recs, err
+		}
+	case fi.Size() < lf.offset:
+		if _, err := lf.f.Seek(0, 0); err != nil {
+			return recs, err
+		}
+		lf.offset, lf.partial, lf.header = 0, "", defaultLogHeader()
+	default:
+		return recs, nil
+	}
+	more, err := lf.drain()
+	return append(recs, more...), err
+}
+
+func (lf *logFollower) open() error {
+	f, err := os.Open(lf.path)
+	if err != nil {
+		return err
+	}
+	lf.f, lf.offset, lf.partial, lf.header = f, 0, "", defaultLogHeader()
+	return nil
+}
+
+func (lf *logFollower) close() {
+	if lf.f != nil {
+		lf.f.Close()
+		lf.f = nil
+	}
+}
+
+// drain reads everything up to the end of file. A trailing line without a
+// newline is kept until the rest of it is written.
+func (lf *logFollower) drain() ([]logRecord, error) {
+	data, err := ioutil.ReadAll(lf.f)
+	if err != nil {
+		return nil, err
+	}
+	lf.offset += int64(len(data))
+	lines := strings.Split(lf.partial+string(data), "\n")
+	lf.partial = lines[len(lines)-1]
+	var recs []logRecord
+	var errs []string
+	for _, line := range lines[:len(lines)-1] {
+		rec, ok, err := parseLogLine(&lf.header, line)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if ok {
+			recs = append(recs, rec)
+		}
+	}
+	if len(errs) > 0 {
+		return recs, fmt.Errorf("%s: %s", lf.path, strings.Join(errs, "; "))
+	}
+	return recs, nil
+}
+
+// parseLogTime parses ts field, which is seconds since epoch, or ISO 8601
+// when JSON logs are written with JSON::TS_ISO8601.
+func parseLogTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// ZeekPeerStats is Zeek's own view of a node, taken from the most recent
+// entries of stats.log and capture_loss.log for that node. Packet and event
+// counts are those processed since the previous stats.log entry.
+type ZeekPeerStats struct {
+	Peer           string    `json:"peer"`
+	StatsTimestamp time.Time `json:"stats_ts"`
+	MemMB          uint64    `json:"mem"`
+	PktsProc       uint64    `json:"pkts_proc"`
+	BytesRecv      uint64    `json:"bytes_recv"`
+	PktsDropped    uint64    `json:"pkts_dropped"`
+	PktsLink       uint64    `json:"pkts_link"`
+	EventsProc     uint64    `json:"events_proc"`
+	EventsQueued   uint64    `json:"events_queued"`
+	LossTimestamp  time.Time `json:"capture_loss_ts"`
+	Gaps           uint64    `json:"gaps"`
+	Acks           uint64    `json:"acks"`
+	PercentLost    float64   `json:"percent_lost"`
+}
+
+// setUints parses unsigned fields of rec into their destinations. Fields
+// missing from the record are left alone.
+func setUints(rec logRecord, dst map[string]*uint64) error {
+	for name, v := range dst {
+		s, ok := rec[name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad %s %q: %v", name, s, err)
+		}
+		*v = n
+	}
+	return nil
+}
+
+func (ps *ZeekPeerStats) applyStats(rec logRecord) error {
+	if ts, ok := rec["ts"]; ok {
+		t, err := parseLogTime(ts)
+		if err != nil {
+			return fmt.Errorf("bad ts %q: %v", ts, err)
+		}
+		ps.StatsTimestamp = t
+	}
+	return setUints(rec, map[string]*uint64{
+		"mem":           &ps.MemMB,
+		"pkts_proc":     &ps.PktsProc,
+		"bytes_recv":    &ps.BytesRecv,
+		"pkts_dropped":  &ps.PktsDropped,
+		"pkts_link":     &ps.PktsLink,
+		"events_proc":   &ps.EventsProc,
+		"events_queued": &ps.EventsQueued,
+	})
+}
+
+func (ps *ZeekPeerStats) applyCaptureLoss(rec logRecord) error {
+	if ts, ok := rec["ts"]; ok {
+		t, err := parseLogTime(ts)
+		if err != nil {
+			return fmt.Errorf("bad ts %q: %v", ts, err)
+		}
+		ps.LossTimestamp = t
+	}
+	if s, ok := rec["percent_lost"]; ok {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("bad percent_lost %q: %v", s, err)
+		}
+		ps.PercentLost = v
+	}
+	return setUints(rec, map[string]*uint64{
+		"gaps": &ps.Gaps,
+		"acks": &ps.Acks,
+	})
+}
+
+// String returns Zeek's view of a node in Prometheus format with given labels.
+func (ps ZeekPeerStats) String(labels string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bro_zeek_mem_bytes{%s} %d\n", labels, ps.MemMB<<20)
+	fmt.Fprintf(&b, "bro_zeek_pkts_proc{%s} %d\n", labels, ps.PktsProc)
+	fmt.Fprintf(&b, "bro_zeek_events_proc{%s} %d\n", labels, ps.EventsProc)
+	fmt.Fprintf(&b, "bro_zeek_percent_lost{%s} %g\n", labels, ps.PercentLost)
+	return b.String()
+}
+
+// ZeekLogs follows stats.log and capture_loss.log in Zeek's log directory and
+// keeps the most recent entries for each node.
+type ZeekLogs struct {
+	stats *logFollower
+	loss  *logFollower
+	peers map[string]*ZeekPeerStats
+	mtx   sync.RWMutex
+}
+
+// NewZeekLogs returns a collector of logs in dir, which is normally the
+// current directory of ZeekControl's log directory.
+func NewZeekLogs(dir string) *ZeekLogs {
+	return &ZeekLogs{
+		stats: newLogFollower(filepath.Join(dir, "stats.log")),
+		loss:  newLogFollower(filepath.Join(dir, "capture_loss.log")),
+		peers: make(map[string]*ZeekPeerStats),
+	}
+}
+
+func (zl *ZeekLogs) peer(name string) *ZeekPeerStats {
+	ps, ok := zl.peers[name]
+	if !ok {
+		ps = &ZeekPeerStats{Peer: name}
+		zl.peers[name] = ps
+	}
+	return ps
+}
+
+// Collect reads entries written since it was last called. Entries without a
+// peer, which a standalone Zeek writes, are attributed to role "zeek".
+func (zl *ZeekLogs) Collect() error {
+	var errs []string
+	for _, src := range []struct {
+		lf    *logFollower
+		apply func(*ZeekPeerStats, logRecord) error
+	}{
+		{zl.stats, (*ZeekPeerStats).applyStats},
+		{zl.loss, (*ZeekPeerStats).applyCaptureLoss},
+	} {
+		recs, err := src.lf.Poll()
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		zl.mtx.Lock()
+		for _, rec := range recs {
+			name, ok := rec["peer"]
+			if !ok {
+				name = "zeek"
+			}
+			if err := src.apply(zl.peer(name), rec); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", src.lf.path, err))
+			}
+		}
+		zl.mtx.Unlock()
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Peer returns a copy of the most recent stats of a node, nil if we have not
+// seen any.
+func (zl *ZeekLogs) Peer(name string) *ZeekPeerStats {
+	zl.mtx.RLock()
+	defer zl.mtx.RUnlock()
+	ps, ok := zl.peers[name]
+	if !ok {
+		return nil
+	}
+	copied := *ps
+	return &copied
+}
+
+// startZeekLogCollector follows Zeek's logs until context is cancelled.
+func startZeekLogCollector(ctx context.Context, zl *ZeekLogs) {
+	tick := time.NewTicker(ZeekLogInterval)
+	defer tick.Stop()
+	defer zl.stats.close()
+	defer zl.loss.close()
+	for {
+		if err := zl.Collect(); err != nil {
+			handleErr(err, false)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const statsLogHeader = "#separator \\x09\n" +
+	"#set_separator\t,\n" +
+	"#empty_field\t(empty)\n" +
+	"#unset_field\t-\n" +
+	"#path\tstats\n" +
+	"#open\t2020-09-13-12-26-40\n" +
+	"#fields\tts\tpeer\tmem\tpkts_proc\tbytes_recv\tpkts_dropped\tpkts_link\tpkt_lag\tevents_proc\tevents_queued\n" +
+	"#types\ttime\tstring\tcount\tcount\tcount\tcount\tcount\tinterval\tcount\tcount\n"
+
+const captureLossLog = "#separator \\x09\n" +
+	"#unset_field\t-\n" +
+	"#fields\tts\tts_delta\tpeer\tgaps\tacks\tpercent_lost\n" +
+	"1600000000.000000\t900.000000\tworker-1-1\t12\t1200\t1.0\n" +
+	"1600000900.000000\t900.000000\tworker-1-1\t5\t1000\t0.5\n"
+
+func Test_parseLogLine(t *testing.T) {
+	h := defaultLogHeader()
+	for _, line := range []string{"#separator \\x09", "#unset_field\t-", "#fields\tts\tpeer\tmem\tpkts_dropped"} {
+		if _, ok, err := parseLogLine(&h, line); ok || err != nil {
+			t.Fatalf("parseLogLine(%q) = %v, %v, want header", line, ok, err)
+		}
+	}
+	tests := []struct {
+		name    string
+		line    string
+		want    logRecord
+		wantErr bool
+	}{
+		{"tsv", "1600000000.5\tworker-1\t512\t-\n",
+			logRecord{"ts": "1600000000.5", "peer": "worker-1", "mem": "512"}, false},
+		{"json", `{"ts":1600000000.5,"peer":"worker-1","mem":512,"pkts_dropped":null}`,
+			logRecord{"ts": "1600000000.5", "peer": "worker-1", "mem": "512"}, false},
+		{"short", "1600000000.5\tworker-1", nil, true},
+		{"bad json", `{"ts":`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := parseLogLine(&h, tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLogLine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLogLine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	h = defaultLogHeader()
+	if _, _, err := parseLogLine(&h, "1600000000.5\tworker-1"); err == nil {
+		t.Errorf("parseLogLine() expected error for entry without #fields header")
+	}
+}
+
+func Test_parseLogTime(t *testing.T) {
+	want := time.Unix(1600000000, 500000000).UTC()
+	for _, s := range []string{"1600000000.5", "2020-09-13T12:26:40.5Z"} {
+		got, err := parseLogTime(s)
+		if err != nil {
+			t.Fatalf("parseLogTime(%q) error = %v", s, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseLogTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func peersOf(recs []logRecord) []string {
+	var l []string
+	for _, rec := range recs {
+		l = append(l, rec["peer"])
+	}
+	return l
+}
+
+func Test_logFollower(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats.log")
+	lf := newLogFollower(path)
+	defer lf.close()
+
+	if recs, err := lf.Poll(); err != nil || len(recs) != 0 {
+		t.Fatalf("logFollower.Poll() = %v, %v, want nothing before log exists", recs, err)
+	}
+	appendFile(t, path, statsLogHeader+"1600000000.0\tworker-1\t100\t10\t0\t0\t0\t0\t5\t5\n1600000000.0\twork")
+	recs, err := lf.Poll()
+	if err != nil {
+		t.Fatalf("logFollower.Poll() error = %v", err)
+	}
+	if got := peersOf(recs); !reflect.DeepEqual(got, []string{"worker-1"}) {
+		t.Errorf("logFollower.Poll() = %v, want worker-1 only", got)
+	}
+	appendFile(t, path, "er-2\t100\t10\t0\t0\t0\t0\t5\t5\n")
+	recs, _ = lf.Poll()
+	if got := peersOf(recs); !reflect.DeepEqual(got, []string{"worker-2"}) {
+		t.Errorf("logFollower.Poll() = %v, want worker-2 after partial line completed", got)
+	}
+
+	// Rotation, with an entry written to the old file just before.
+	appendFile(t, path, "1600000300.0\tworker-3\t100\t10\t0\t0\t0\t0\t5\t5\n")
+	if err := os.Rename(path, filepath.Join(dir, "stats.1.log")); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, statsLogHeader+"1600000600.0\tworker-4\t100\t10\t0\t0\t0\t0\t5\t5\n")
+	recs, _ = lf.Poll()
+	if got := peersOf(recs); !reflect.DeepEqual(got, []string{"worker-3", "worker-4"}) {
+		t.Errorf("logFollower.Poll() = %v, want worker-3 and worker-4 across rotation", got)
+	}
+
+	// Truncation, and a switch to JSON.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, `{"ts":1600000900.0,"peer":"worker-5","mem":100}`+"\n")
+	recs, _ = lf.Poll()
+	if got := peersOf(recs); !reflect.DeepEqual(got, []string{"worker-5"}) {
+		t.Errorf("logFollower.Poll() = %v, want worker-5 after truncation", got)
+	}
+}
+
+func TestZeekLogs_Collect(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string]string{
+		"stats.log": statsLogHeader +
+			"1600000000.000000\tworker-1-1\t512\t1000\t100000\t-\t-\t0.1\t2000\t2000\n" +
+			"1600000000.000000\tmanager\t256\t0\t0\t-\t-\t-\t300\t300\n",
+		"capture_loss.log": captureLossLog,
+	})
+	zl := NewZeekLogs(dir)
+	if err := zl.Collect(); err != nil {
+		t.Fatalf("ZeekLogs.Collect() error = %v", err)
+	}
+	want := &ZeekPeerStats{
+		Peer:           "worker-1-1",
+		StatsTimestamp: time.Unix(1600000000, 0).UTC(),
+		MemMB:          512,
+		PktsProc:       1000,
+		BytesRecv:      100000,
+		EventsProc:     2000,
+		EventsQueued:   2000,
+		LossTimestamp:  time.Unix(1600000900, 0).UTC(),
+		Gaps:           5,
+		Acks:           1000,
+		PercentLost:    0.5,
+	}
+	if got := zl.Peer("worker-1-1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ZeekLogs.Peer() = %+v, want %+v", got, want)
+	}
+	if got := zl.Peer("proxy-1"); got != nil {
+		t.Errorf("ZeekLogs.Peer() = %+v, want nil for unknown peer", got)
+	}
+
+	saved := zeekLogs
+	defer func() { zeekLogs = saved }()
+	zeekLogs = zl
+	s := &Summaries{m: make(map[string]*IntervalReport)}
+	s.Insert(&IntervalReport{Role: "manager", PID: 100})
+	if rs := s.roleSummary("manager"); rs == nil || rs.Zeek == nil || rs.Zeek.MemMB != 256 {
+		t.Errorf("Summaries.roleSummary() = %+v, want Zeek stats of manager", rs)
+	}
+}